@@ -1,41 +1,186 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
 
 	"github.com/azdanov/go-rest-api/internal/comment"
-	"github.com/azdanov/go-rest-api/internal/db"
-	transportHttp "github.com/azdanov/go-rest-api/internal/transport/http"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/lib/pq"
+	"github.com/azdanov/go-rest-api/internal/config"
 )
 
-func Run(logger *slog.Logger) error {
-	logger.Info("starting server")
+const progName = "server"
 
-	db, err := db.NewDatabase(logger, db.CreateConnectionString())
-	if err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
+// Exit codes of the binary.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// errUsage marks errors caused by a malformed command line. The user has
+// already been told what is wrong when it is returned.
+var errUsage = errors.New("usage error")
+
+// cli holds what the commands share.
+type cli struct {
+	logger *slog.Logger
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	// run runs the command. path is the full name of the command, such as
+	// "server migrate up", and args are the arguments that follow it.
+	run func(ctx context.Context, c *cli, path string, args []string) error
+}
+
+func commands() []command {
+	return []command{
+		{name: "serve", summary: "migrate the database and run the API server", run: serve},
+		{
+			name:    "migrate",
+			args:    "<command>",
+			summary: "apply, roll back or inspect migrations",
+			run:     group(migrateCommands),
+		},
+		{name: "seed", summary: "create sample comments", run: seed},
+		{name: "token", args: "<command>", summary: "work with API tokens", run: group(tokenCommands)},
+		{name: "export", summary: "write the comments of a tenant as JSON lines", run: exportComments},
+		{name: "import", args: "[file]", summary: "read comments written by export into a tenant", run: importComments},
+		{name: "version", summary: "print version information", run: printVersion},
 	}
-	if err = db.Migrate("./migrations"); err != nil {
-		return err
+}
+
+// group returns a command that runs one of the given subcommands.
+func group(subcommands func() []command) func(context.Context, *cli, string, []string) error {
+	return func(ctx context.Context, c *cli, path string, args []string) error {
+		return c.dispatch(ctx, path, subcommands(), args)
 	}
+}
 
-	commentService := comment.NewService(db, logger)
+// dispatch runs the command in cmds named by the first argument.
+func (c *cli) dispatch(ctx context.Context, path string, cmds []command, args []string) error {
+	if len(args) == 0 {
+		c.usage(path, cmds)
+		return errUsage
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		c.usage(path, cmds)
+		return nil
+	}
 
-	httpHandler := transportHttp.NewHandler(commentService, logger)
-	if err = httpHandler.Serve(); err != nil {
-		return fmt.Errorf("failed to start server: %w", err)
+	for _, cmd := range cmds {
+		if cmd.name == args[0] {
+			return cmd.run(ctx, c, path+" "+cmd.name, args[1:])
+		}
 	}
 
-	return nil
+	_, _ = fmt.Fprintf(c.stderr, "%s: unknown command %q\n\n", path, args[0])
+	c.usage(path, cmds)
+	return errUsage
 }
 
-func main() {
-	logger := slog.Default()
+func (c *cli) usage(path string, cmds []command) {
+	_, _ = fmt.Fprintf(c.stderr, "Usage: %s <command> [flags] [arguments]\n\nCommands:\n", path)
+	w := tabwriter.NewWriter(c.stderr, 0, 0, 2, ' ', 0)
+	for _, cmd := range cmds {
+		_, _ = fmt.Fprintf(w, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.summary)
+	}
+	_ = w.Flush()
+	_, _ = fmt.Fprintf(c.stderr, "\nRun '%s <command> -h' for the flags of a command.\n", path)
+}
+
+// flagSet returns the flag set of the command at path, which takes the given
+// arguments after its flags.
+func (c *cli) flagSet(path, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(path, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "Usage: %s\n\nFlags:\n", strings.TrimSpace(path+" [flags] "+args))
+		fs.PrintDefaults()
+	}
+	return fs
+}
 
-	if err := Run(logger); err != nil {
-		logger.Error("failed to run server", slog.Any("error", err))
+// parse parses args and loads the configuration, which the flags registered by
+// loader take part in.
+func parse(fs *flag.FlagSet, loader *config.Loader, args []string) (config.Config, error) {
+	if err := fs.Parse(args); err != nil {
+		return config.Config{}, parseError(err)
 	}
+	return loader.Load()
+}
+
+// parseError marks an error returned by FlagSet.Parse, which has already
+// reported it, as a usage error. A request for help is not an error.
+func parseError(err error) error {
+	if errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", errUsage, err)
+}
+
+// badArgs reports malformed arguments of the command of fs.
+func badArgs(fs *flag.FlagSet, format string, a ...any) error {
+	_, _ = fmt.Fprintf(fs.Output(), "%s: %s\n\n", fs.Name(), fmt.Sprintf(format, a...))
+	fs.Usage()
+	return errUsage
+}
+
+// tenantFlag registers the -tenant flag of commands that work on the comments
+// of one tenant.
+func tenantFlag(fs *flag.FlagSet) *string {
+	return fs.String("tenant", "", "tenant to work on (default the tenant of unmapped hosts)")
+}
+
+// withTenant scopes ctx to tenant, or to the default tenant of cfg if tenant
+// is empty.
+func withTenant(ctx context.Context, cfg config.Config, tenant string) (context.Context, error) {
+	if tenant == "" {
+		tenant = cfg.Tenants.Default
+	}
+	if tenant == "" {
+		return nil, fmt.Errorf("%w: no default tenant is configured, use -tenant", comment.ErrTenantRequired)
+	}
+	return comment.WithTenant(ctx, tenant), nil
+}
+
+// run runs the command line args and returns the exit code.
+func run(ctx context.Context, c *cli, args []string) int {
+	err := c.dispatch(ctx, progName, commands(), args)
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.Is(err, errUsage):
+		return exitUsage
+	default:
+		c.logger.Error("command failed", slog.String("command", strings.Join(args, " ")), slog.Any("error", err))
+		return exitFailure
+	}
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, &cli{
+		logger: slog.Default(),
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}, os.Args[1:])
+	stop()
+	os.Exit(code)
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// newRegistry returns the registry served on /metrics, with the runtime and
+// process metrics already registered.
+func newRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return reg
+}
+
+// commentMetrics counts the comments created, updated and deleted through the
+// comment service.
+type commentMetrics map[comment.EventType]prometheus.Counter
+
+func newCommentMetrics(reg prometheus.Registerer) commentMetrics {
+	m := commentMetrics{
+		comment.CommentCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "comments_created_total",
+			Help: "Number of comments created.",
+		}),
+		comment.CommentUpdated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "comments_updated_total",
+			Help: "Number of comment updates, including restores and moderation decisions.",
+		}),
+		comment.CommentDeleted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "comments_deleted_total",
+			Help: "Number of comments deleted.",
+		}),
+	}
+	for _, c := range m {
+		reg.MustRegister(c)
+	}
+	return m
+}
+
+func (m commentMetrics) CountChange(t comment.EventType) {
+	if c, ok := m[t]; ok {
+		c.Inc()
+	}
+}
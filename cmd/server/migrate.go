@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/azdanov/go-rest-api/internal/config"
+	"github.com/azdanov/go-rest-api/internal/db"
+	"github.com/azdanov/go-rest-api/migrations"
+)
+
+func migrateCommands() []command {
+	return []command{
+		{name: "up", summary: "apply all pending migrations", run: migrateUp},
+		{name: "down", args: "[N]", summary: "roll back the last N migrations, 1 by default", run: migrateDown},
+		{name: "status", summary: "print the schema version", run: migrateStatus},
+		{name: "force", args: "<version>", summary: "record the version of a repaired schema", run: migrateForce},
+		{name: "goto", args: "<version>", summary: "apply or roll back migrations up to version", run: migrateGoto},
+		{name: "list", summary: "print the migrations known to the binary", run: migrateList},
+	}
+}
+
+// migrateArgs parses the arguments of a migrate command, which takes at most
+// maxArgs positional arguments.
+func migrateArgs(c *cli, path, usage string, args []string, maxArgs int) (config.Config, *flag.FlagSet, error) {
+	fs := c.flagSet(path, usage)
+	cfg, err := parse(fs, config.NewLoader(fs), args)
+	if err != nil {
+		return config.Config{}, nil, err
+	}
+	if fs.NArg() > maxArgs {
+		return config.Config{}, nil, badArgs(fs, "unexpected arguments %q", fs.Args()[maxArgs:])
+	}
+	return cfg, fs, nil
+}
+
+// migrate connects to the database and runs fn with it.
+func migrate(c *cli, cfg config.Config, fn func(*db.Database) error) error {
+	database, err := db.NewDatabase(c.logger, cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = database.Client.Close() }()
+
+	return fn(database)
+}
+
+func migrateUp(_ context.Context, c *cli, path string, args []string) error {
+	cfg, _, err := migrateArgs(c, path, "", args, 0)
+	if err != nil {
+		return err
+	}
+
+	return migrate(c, cfg, func(database *db.Database) error {
+		return database.Migrate()
+	})
+}
+
+func migrateDown(_ context.Context, c *cli, path string, args []string) error {
+	cfg, fs, err := migrateArgs(c, path, "[N]", args, 1)
+	if err != nil {
+		return err
+	}
+
+	steps := 1
+	if fs.NArg() == 1 {
+		steps, err = strconv.Atoi(fs.Arg(0))
+		if err != nil || steps <= 0 {
+			return badArgs(fs, "N must be a positive number, not %q", fs.Arg(0))
+		}
+	}
+
+	return migrate(c, cfg, func(database *db.Database) error {
+		return database.MigrateDown(steps)
+	})
+}
+
+// migrateStatus prints the schema version and the pending migrations. It
+// fails if the schema is dirty or newer than the binary, which serve refuses.
+func migrateStatus(_ context.Context, c *cli, path string, args []string) error {
+	cfg, _, err := migrateArgs(c, path, "", args, 0)
+	if err != nil {
+		return err
+	}
+
+	return migrate(c, cfg, func(database *db.Database) error {
+		status, statusErr := database.MigrationStatus()
+		if statusErr != nil {
+			return statusErr
+		}
+
+		dirty := ""
+		if status.Dirty {
+			dirty = " (dirty)"
+		}
+		_, statusErr = fmt.Fprintf(c.stdout, "version %d%s, latest %d\n", status.Version, dirty, status.Latest)
+		for _, m := range status.Pending {
+			if statusErr != nil {
+				break
+			}
+			_, statusErr = fmt.Fprintf(c.stdout, "pending %04d %s\n", m.Version, m.Name)
+		}
+		if statusErr != nil {
+			return statusErr
+		}
+
+		return status.Check()
+	})
+}
+
+// migrateForce takes -1 to record that no migration is applied. As it looks
+// like a flag, it has to follow "--".
+func migrateForce(_ context.Context, c *cli, path string, args []string) error {
+	cfg, fs, err := migrateArgs(c, path, "[--] <version>", args, 1)
+	if err != nil {
+		return err
+	}
+
+	version, err := strconv.Atoi(fs.Arg(0))
+	if err != nil || version < -1 {
+		return badArgs(fs, "version must be a migration version, or -1 for none, not %q", fs.Arg(0))
+	}
+
+	return migrate(c, cfg, func(database *db.Database) error {
+		return database.Force(version)
+	})
+}
+
+func migrateGoto(_ context.Context, c *cli, path string, args []string) error {
+	cfg, fs, err := migrateArgs(c, path, "<version>", args, 1)
+	if err != nil {
+		return err
+	}
+
+	version, err := strconv.ParseUint(fs.Arg(0), 10, 0)
+	if err != nil {
+		return badArgs(fs, "version must be a migration version, not %q", fs.Arg(0))
+	}
+
+	return migrate(c, cfg, func(database *db.Database) error {
+		return database.MigrateTo(uint(version))
+	})
+}
+
+// migrateList prints the migrations the binary applies, which are the embedded
+// ones unless -migrations-dir is given. It does not connect to the database.
+func migrateList(_ context.Context, c *cli, path string, args []string) error {
+	cfg, _, err := migrateArgs(c, path, "", args, 0)
+	if err != nil {
+		return err
+	}
+
+	list, err := migrations.List(db.MigrationFiles(cfg.Database.MigrationsDir))
+	if err != nil {
+		return err
+	}
+	for _, m := range list {
+		if _, err = fmt.Fprintf(c.stdout, "%04d %s\n", m.Version, m.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
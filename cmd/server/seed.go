@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/azdanov/go-rest-api/internal/config"
+	"github.com/azdanov/go-rest-api/internal/db"
+)
+
+const (
+	defaultSeedSlug    = "hello-world"
+	defaultSeedCount   = 10
+	defaultSeedReplies = 2
+	seedAuthor         = "seed"
+)
+
+// seed creates comments with replies to try the API with. They are created
+// through the comment service, so they are recorded in the outbox like any
+// other comment, but the content filters and the moderation policy do not
+// apply.
+func seed(ctx context.Context, c *cli, path string, args []string) error {
+	fs := c.flagSet(path, "")
+	tenant := tenantFlag(fs)
+	slug := fs.String("slug", defaultSeedSlug, "slug to comment on")
+	count := fs.Int("count", defaultSeedCount, "number of comments")
+	replies := fs.Int("replies", defaultSeedReplies, "number of replies to each comment")
+	cfg, err := parse(fs, config.NewLoader(fs), args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return badArgs(fs, "unexpected arguments %q", fs.Args())
+	}
+	if *count < 0 || *replies < 0 {
+		return badArgs(fs, "-count and -replies must not be negative")
+	}
+
+	ctx, err = withTenant(ctx, cfg, *tenant)
+	if err != nil {
+		return err
+	}
+	database, err := db.NewDatabase(c.logger, cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = database.Client.Close() }()
+
+	service := comment.NewService(database, c.logger)
+	for i := range *count {
+		parent, createErr := service.CreateComment(ctx, comment.Comment{
+			Slug:   *slug,
+			Body:   fmt.Sprintf("Comment %d", i+1),
+			Author: seedAuthor,
+		})
+		if createErr != nil {
+			return createErr
+		}
+		for j := range *replies {
+			_, createErr = service.CreateComment(ctx, comment.Comment{
+				ParentID: parent.ID,
+				Slug:     *slug,
+				Body:     fmt.Sprintf("Reply %d to comment %d", j+1, i+1),
+				Author:   seedAuthor,
+			})
+			if createErr != nil {
+				return createErr
+			}
+		}
+	}
+
+	c.logger.InfoContext(ctx, "seeded comments", slog.String("slug", *slug), slog.Int("count", *count*(1+*replies)))
+	return nil
+}
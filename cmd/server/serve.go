@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/azdanov/go-rest-api/internal/config"
+	"github.com/azdanov/go-rest-api/internal/db"
+	"github.com/azdanov/go-rest-api/internal/health"
+	transportHttp "github.com/azdanov/go-rest-api/internal/transport/http"
+	"github.com/azdanov/go-rest-api/internal/webhook"
+	_ "github.com/lib/pq"
+)
+
+const (
+	webhookTimeout = 10 * time.Second
+	streamBuffer   = 64
+	// healthCheckTimeout bounds each readiness check, so that a probe
+	// answers before the orchestrator gives up on it.
+	healthCheckTimeout = time.Second
+)
+
+// serve migrates the database, unless -no-migrate is given, and runs the API
+// server until it is interrupted.
+func serve(_ context.Context, c *cli, path string, args []string) error {
+	fs := c.flagSet(path, "")
+	noMigrate := fs.Bool("no-migrate", false, "do not apply pending migrations, they are applied separately")
+	cfg, err := parse(fs, config.NewLoader(fs), args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return badArgs(fs, "unexpected arguments %q", fs.Args())
+	}
+	if err = cfg.RequireSigningKey(); err != nil {
+		return err
+	}
+
+	return Run(c.logger, cfg, !*noMigrate)
+}
+
+// Run runs the API server until it is interrupted, applying pending migrations
+// first if migrate is set.
+func Run(logger *slog.Logger, cfg config.Config, migrate bool) error {
+	logger.Info("starting server")
+	if cfg.Dev {
+		logger.Warn("running in dev mode, insecure settings are allowed")
+	}
+
+	database, err := db.NewDatabase(logger, cfg.Database)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer func() { _ = database.Client.Close() }()
+	if migrate {
+		if err = database.Migrate(); err != nil {
+			return err
+		}
+	}
+	// Whatever migrated the schema, the binary must know it.
+	status, err := database.CheckSchema()
+	if err != nil {
+		return fmt.Errorf("refusing to serve: %w", err)
+	}
+	if len(status.Pending) > 0 {
+		logger.Warn(
+			"database schema is behind the binary",
+			slog.Uint64("version", uint64(status.Version)),
+			slog.Uint64("latest", uint64(status.Latest)),
+		)
+	}
+
+	reg := newRegistry()
+	reg.MustRegister(database.Collectors()...)
+
+	filters, err := contentFilters(cfg.Filters)
+	if err != nil {
+		return err
+	}
+
+	// The relay marks an event delivered once the subscribers have handled
+	// it, so they are called synchronously.
+	events := comment.NewDispatcher(logger)
+	subscribe(events, logger)
+
+	webhookService := webhook.NewService(database, logger)
+	events.Subscribe(webhookService.HandleEvent)
+
+	// Live streams need every change on every replica, so they are fed by a
+	// listener rather than by the relay, which hands each event to one replica.
+	changes := comment.NewDispatcher(logger)
+	broker := comment.NewBroker(database, streamBuffer)
+	changes.Subscribe(broker.Handle)
+
+	// The database records comment events in its outbox; the relay feeds them
+	// to the dispatcher. The background work stops once the server has shut
+	// down, and is waited for before the database is closed.
+	ctx, cancel := context.WithCancel(context.Background())
+	var background sync.WaitGroup
+	defer func() {
+		cancel()
+		background.Wait()
+	}()
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		db.NewOutboxRelay(database, events, logger).Run(ctx)
+	}()
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		webhook.NewWorker(database, logger, webhook.NewClient(webhookTimeout)).Run(ctx)
+	}()
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		if listenErr := db.NewListener(database, changes, logger).Run(ctx); listenErr != nil {
+			logger.Error("failed to listen for comment events", slog.Any("error", listenErr))
+		}
+	}()
+
+	commentService := comment.NewService(
+		database,
+		logger,
+		comment.WithModerationPolicy(moderationPolicy(cfg.Moderation)),
+		comment.WithFilters(filters...),
+		comment.WithMetrics(newCommentMetrics(reg)),
+	)
+
+	httpHandler := transportHttp.NewHandler(
+		commentService,
+		logger,
+		cfg,
+		transportHttp.WithWebhooks(webhookService),
+		transportHttp.WithStreamer(broker),
+		transportHttp.WithMigrations(database),
+		transportHttp.WithMetrics(reg),
+		transportHttp.WithReadinessCheck("database", healthCheckTimeout, database.Ping),
+		transportHttp.WithReadinessCheck("migrations", healthCheckTimeout, migrationsApplied(database)),
+	)
+	if err = httpHandler.Serve(); err != nil {
+		return fmt.Errorf("failed to start server: %w", err)
+	}
+
+	return nil
+}
+
+// subscribe registers the in-process consumers of comment events.
+func subscribe(events *comment.Dispatcher, logger *slog.Logger) {
+	events.Subscribe(func(ctx context.Context, e comment.Event) error {
+		logger.InfoContext(
+			ctx,
+			"comment event",
+			slog.String("type", string(e.Type)),
+			slog.String("comment_id", e.CommentID),
+			slog.String("actor", e.Actor),
+		)
+		return nil
+	})
+}
+
+// migrationsApplied fails while the schema is unusable or behind the binary,
+// such as while a separate deployment step is still migrating it. A newer
+// schema is fine once the server runs: it is what a rolling deployment of the
+// next version leaves behind.
+func migrationsApplied(database *db.Database) health.Func {
+	return func(ctx context.Context) error {
+		status, err := database.SchemaStatus(ctx)
+		if err != nil {
+			return err
+		}
+		if status.Dirty {
+			return db.ErrSchemaDirty
+		}
+		if len(status.Pending) > 0 {
+			return fmt.Errorf("%d migrations pending", len(status.Pending))
+		}
+		return nil
+	}
+}
+
+// moderationPolicy holds new comments for review if the default status is
+// pending, and always for the listed slugs.
+func moderationPolicy(cfg config.Moderation) comment.SlugPolicy {
+	policy := comment.SlugPolicy{
+		Default: cfg.Default == comment.StatusPending,
+		Slugs:   map[string]bool{},
+	}
+	for _, slug := range cfg.PendingSlugs {
+		policy.Slugs[slug] = true
+	}
+	return policy
+}
+
+// contentFilters builds the content filter chain: blocked words and patterns
+// are handled according to their configured actions, comments with too many
+// links are flagged and reposts of the same body within the duplicate window
+// are rejected.
+func contentFilters(cfg config.Filters) ([]comment.Filter, error) {
+	var filters []comment.Filter
+	if len(cfg.BlockedWords) > 0 {
+		filters = append(filters, comment.NewWordListFilter(cfg.BlockedWords, cfg.BlockedWordsAction))
+	}
+	for _, p := range cfg.Patterns {
+		f, err := comment.NewRegexFilter(p.Name, p.Pattern, p.Action, p.Reason)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	if cfg.MaxLinks > 0 {
+		filters = append(filters, comment.LinkLimitFilter{Max: cfg.MaxLinks, Action: comment.ActionFlag})
+	}
+	if cfg.DuplicateWindow > 0 {
+		filters = append(filters, comment.NewDuplicateFilter(cfg.DuplicateWindow))
+	}
+	return filters, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/azdanov/go-rest-api/internal/config"
+	transportHttp "github.com/azdanov/go-rest-api/internal/transport/http"
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+const defaultTokenTTL = 24 * time.Hour
+
+func tokenCommands() []command {
+	return []command{
+		{name: "mint", summary: "print a token signed with the configured key", run: mintToken},
+	}
+}
+
+func mintToken(_ context.Context, c *cli, path string, args []string) error {
+	fs := c.flagSet(path, "")
+	subject := fs.String("sub", "", "subject of the token, the user it acts as (required)")
+	roles := fs.String("roles", "", "comma separated roles: "+transportHttp.RoleModerator+", "+transportHttp.RoleAdmin)
+	tenant := fs.String(
+		"tenant",
+		"",
+		"tenant the token is limited to, empty for the tenant of the host it is used on; "+
+			"moderator and admin tokens without a tenant are refused on hosts mapped to a tenant",
+	)
+	ttl := fs.Duration("ttl", defaultTokenTTL, "time until the token expires")
+	cfg, err := parse(fs, config.NewLoader(fs), args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return badArgs(fs, "unexpected arguments %q", fs.Args())
+	}
+	if *subject == "" {
+		return badArgs(fs, "-sub is required")
+	}
+	if *ttl <= 0 {
+		return badArgs(fs, "-ttl must be positive")
+	}
+	if err = cfg.RequireSigningKey(); err != nil {
+		return err
+	}
+
+	var roleList []string
+	for role := range strings.SplitSeq(*roles, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roleList = append(roleList, role)
+		}
+	}
+
+	now := time.Now()
+	token, err := transportHttp.SignToken([]byte(cfg.Auth.JWTSigningKey), transportHttp.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   *subject,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(*ttl)),
+		},
+		Roles:  roleList,
+		Tenant: *tenant,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(c.stdout, token)
+	return err
+}
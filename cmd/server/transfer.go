@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/azdanov/go-rest-api/internal/config"
+	"github.com/azdanov/go-rest-api/internal/db"
+)
+
+// exportComments writes every comment of a tenant, deleted ones included, as
+// one JSON object per line. Reactions and revisions are not exported.
+func exportComments(ctx context.Context, c *cli, path string, args []string) error {
+	fs := c.flagSet(path, "")
+	tenant := tenantFlag(fs)
+	output := fs.String("o", "", "file to write to instead of standard output")
+	cfg, err := parse(fs, config.NewLoader(fs), args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return badArgs(fs, "unexpected arguments %q", fs.Args())
+	}
+
+	ctx, err = withTenant(ctx, cfg, *tenant)
+	if err != nil {
+		return err
+	}
+	database, err := db.NewDatabase(c.logger, cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = database.Client.Close() }()
+
+	out := c.stdout
+	var file *os.File
+	if *output != "" {
+		file, err = os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create export file: %w", err)
+		}
+		defer func() { _ = file.Close() }()
+		out = file
+	}
+
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	var exported int
+	err = database.ExportComments(ctx, func(cmt comment.Comment) error {
+		exported++
+		return enc.Encode(cmt)
+	})
+	if err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	if file != nil {
+		if err = file.Close(); err != nil {
+			return fmt.Errorf("failed to write export: %w", err)
+		}
+	}
+
+	c.logger.InfoContext(ctx, "exported comments", slog.Int("count", exported))
+	return nil
+}
+
+// importComments reads comments written by export from a file, or from
+// standard input if there is none or it is "-", into a tenant.
+func importComments(ctx context.Context, c *cli, path string, args []string) error {
+	fs := c.flagSet(path, "[file]")
+	tenant := tenantFlag(fs)
+	cfg, err := parse(fs, config.NewLoader(fs), args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return badArgs(fs, "unexpected arguments %q", fs.Args()[1:])
+	}
+
+	ctx, err = withTenant(ctx, cfg, *tenant)
+	if err != nil {
+		return err
+	}
+
+	in := c.stdin
+	if name := fs.Arg(0); name != "" && name != "-" {
+		f, openErr := os.Open(name)
+		if openErr != nil {
+			return fmt.Errorf("failed to open import file: %w", openErr)
+		}
+		defer func() { _ = f.Close() }()
+		in = f
+	}
+
+	var comments []comment.Comment
+	dec := json.NewDecoder(bufio.NewReader(in))
+	for {
+		var cmt comment.Comment
+		if err = dec.Decode(&cmt); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read comment %d: %w", len(comments)+1, err)
+		}
+		if cmt.ID == "" || cmt.Slug == "" {
+			return fmt.Errorf("failed to read comment %d: id and slug are required", len(comments)+1)
+		}
+		comments = append(comments, cmt)
+	}
+
+	database, err := db.NewDatabase(c.logger, cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = database.Client.Close() }()
+
+	imported, err := database.ImportComments(ctx, comments)
+	if err != nil {
+		return err
+	}
+
+	c.logger.InfoContext(
+		ctx,
+		"imported comments",
+		slog.Int("count", imported),
+		slog.Int("skipped", len(comments)-imported),
+	)
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/azdanov/go-rest-api/migrations"
+)
+
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+func printVersion(_ context.Context, c *cli, path string, args []string) error {
+	fs := c.flagSet(path, "")
+	if err := fs.Parse(args); err != nil {
+		return parseError(err)
+	}
+	if fs.NArg() > 0 {
+		return badArgs(fs, "unexpected arguments %q", fs.Args())
+	}
+
+	known, err := migrations.List(migrations.FS)
+	if err != nil {
+		return err
+	}
+	var schema uint
+	if len(known) > 0 {
+		schema = known[len(known)-1].Version
+	}
+
+	_, err = fmt.Fprintf(c.stdout, "%s %s\nschema: %d\n", progName, version, schema)
+	if err != nil {
+		return err
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil
+	}
+	_, err = fmt.Fprintf(c.stdout, "go: %s\n", info.GoVersion)
+	for _, s := range info.Settings {
+		if err != nil {
+			break
+		}
+		switch s.Key {
+		case "vcs.revision", "vcs.time", "vcs.modified":
+			_, err = fmt.Fprintf(c.stdout, "%s: %s\n", s.Key, s.Value)
+		}
+	}
+	return err
+}
@@ -0,0 +1,33 @@
+package comment
+
+import "context"
+
+// Actor identifies who performs an operation on a comment. Moderators may act
+// on comments written by others.
+type Actor struct {
+	ID        string
+	Moderator bool
+}
+
+type actorKey struct{}
+
+func WithActor(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, a)
+}
+
+func ActorFromContext(ctx context.Context) (Actor, bool) {
+	a, ok := ctx.Value(actorKey{}).(Actor)
+	return a, ok
+}
+
+// authorize checks that the Actor in ctx owns c or is a moderator.
+func authorize(ctx context.Context, c Comment) error {
+	actor, ok := ActorFromContext(ctx)
+	if !ok {
+		return ErrForbidden
+	}
+	if actor.Moderator || (actor.ID != "" && actor.ID == c.Author) {
+		return nil
+	}
+	return ErrForbidden
+}
@@ -5,41 +5,151 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"time"
 
 	"github.com/gofrs/uuid/v5"
 )
 
 var (
-	ErrCommentNotFound = errors.New("comment not found")
-	ErrNotImplemented  = errors.New("not implemented")
+	ErrCommentNotFound    = errors.New("comment not found")
+	ErrNotImplemented     = errors.New("not implemented")
+	ErrInvalidCursor      = errors.New("invalid cursor")
+	ErrInvalidListOptions = errors.New("invalid list options")
+	ErrInvalidParent      = errors.New("invalid parent comment")
+	ErrVersionConflict    = errors.New("comment version conflict")
+	ErrForbidden          = errors.New("operation not permitted")
+	ErrSlugChanged        = errors.New("slug cannot be changed")
+	// ErrUnavailable marks errors caused by the store being temporarily
+	// unreachable. Such operations may succeed when retried.
+	ErrUnavailable = errors.New("store unavailable")
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+	// AnyVersion in place of a version writes over whichever version is
+	// stored. Versions start at 1.
+	AnyVersion = 0
+)
+
+type Order string
+
+const (
+	OrderAsc  Order = "asc"
+	OrderDesc Order = "desc"
 )
 
 type Comment struct {
-	ID     string `json:"id"`
-	Slug   string `json:"slug"`
-	Body   string `json:"body"`
-	Author string `json:"author"`
+	ID        string     `json:"id"`
+	ParentID  string     `json:"parent_id,omitempty"`
+	Slug      string     `json:"slug"`
+	Body      string     `json:"body"`
+	Author    string     `json:"author"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Status    Status     `json:"status"`
+	// Reactions is only filled in by the read operations of the Service.
+	Reactions []ReactionSummary `json:"reactions,omitempty"`
+	// Version is incremented on every write and is used for optimistic
+	// concurrency control.
+	Version int `json:"version"`
+	// TenantID is the tenant the comment belongs to. It is not shown to
+	// clients, who only ever see the comments of their own tenant.
+	TenantID string `json:"-"`
+}
+
+// ListOptions is the client-facing description of a page of comments.
+type ListOptions struct {
+	Slug           string
+	Cursor         string
+	Limit          int
+	Order          Order
+	IncludeDeleted bool
+}
+
+// ListParams is the resolved query handed to the Store. AfterID is exclusive
+// and is compared in the direction given by Order. RootsOnly restricts the
+// result to comments without a parent. An empty Slug or Status matches every
+// comment.
+type ListParams struct {
+	Slug           string
+	Status         Status
+	AfterID        string
+	Limit          int
+	Order          Order
+	RootsOnly      bool
+	IncludeDeleted bool
+}
+
+// ReplyParams selects the replies below ParentIDs, at most MaxDepth levels
+// deep. A reply that does not have the given Status hides its subtree.
+type ReplyParams struct {
+	ParentIDs      []string
+	MaxDepth       int
+	Status         Status
+	IncludeDeleted bool
+}
+
+type Page struct {
+	Comments   []Comment `json:"comments"`
+	NextCursor string    `json:"next_cursor,omitempty"`
 }
 
 type Store interface {
 	GetComment(context.Context, string) (Comment, error)
+	GetCommentIncludingDeleted(context.Context, string) (Comment, error)
+	ListComments(context.Context, ListParams) ([]Comment, error)
+	ListReplies(context.Context, ReplyParams) ([]Comment, error)
 	CreateComment(context.Context, Comment) (Comment, error)
-	UpdateComment(context.Context, Comment) error
-	DeleteComment(context.Context, string) error
+	UpdateComment(context.Context, Comment) (Comment, error)
+	DeleteComment(ctx context.Context, id string, version int) error
+	RestoreComment(context.Context, string) (Comment, error)
+	ListRevisions(context.Context, string) ([]Revision, error)
+	ModerateComment(context.Context, Moderation) error
+	Search(context.Context, SearchParams) ([]SearchResult, error)
+	AddReaction(context.Context, Reaction) error
+	RemoveReaction(context.Context, Reaction) error
+	// SummarizeReactions returns the reaction summaries of the given
+	// comments, keyed by comment ID and ordered by kind. userID decides
+	// ReactedByMe.
+	SummarizeReactions(ctx context.Context, commentIDs []string, userID string) (map[string][]ReactionSummary, error)
 }
 
 type Service struct {
-	Store  Store
-	logger *slog.Logger
+	Store     Store
+	logger    *slog.Logger
+	policy    ModerationPolicy
+	filters   []Filter
+	publisher EventPublisher
+	metrics   Metrics
 }
 
-func NewService(store Store, logger *slog.Logger) *Service {
-	return &Service{
-		Store:  store,
-		logger: logger,
+// Option configures optional behaviour of a Service.
+type Option func(*Service)
+
+// WithModerationPolicy decides which new comments are held for review. By
+// default every comment is published right away.
+func WithModerationPolicy(p ModerationPolicy) Option {
+	return func(s *Service) {
+		s.policy = p
 	}
 }
 
+func NewService(store Store, logger *slog.Logger, opts ...Option) *Service {
+	s := &Service{
+		Store:     store,
+		logger:    logger,
+		policy:    SlugPolicy{},
+		publisher: nopPublisher{},
+		metrics:   nopMetrics{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
 func (s *Service) CreateComment(ctx context.Context, c Comment) (Comment, error) {
 	uuid, err := uuid.NewV7()
 	if err != nil {
@@ -48,6 +158,22 @@ func (s *Service) CreateComment(ctx context.Context, c Comment) (Comment, error)
 	}
 
 	c.ID = uuid.String()
+	c.TenantID, _ = TenantFromContext(ctx)
+	c.Status = s.initialStatus(ctx, c)
+
+	if c.ParentID != "" {
+		if err = s.checkParent(ctx, c); err != nil {
+			return Comment{}, err
+		}
+	}
+
+	c, flagged, err := s.runFilters(ctx, c)
+	if err != nil {
+		return Comment{}, err
+	}
+	if flagged && !isModerator(ctx) {
+		c.Status = StatusPending
+	}
 
 	c, err = s.Store.CreateComment(ctx, c)
 	if err != nil {
@@ -55,30 +181,238 @@ func (s *Service) CreateComment(ctx context.Context, c Comment) (Comment, error)
 		return Comment{}, fmt.Errorf("failed to create comment: %w", err)
 	}
 
+	s.recordFilters(c)
+	s.publish(ctx, CommentCreated, nil, &c)
+
 	return c, nil
 }
 
+func (s *Service) checkParent(ctx context.Context, c Comment) error {
+	parent, err := s.Store.GetComment(ctx, c.ParentID)
+	if errors.Is(err, ErrCommentNotFound) {
+		return fmt.Errorf("%w: parent comment not found", ErrInvalidParent)
+	}
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to get parent comment", slog.Any("error", err))
+		return fmt.Errorf("failed to get parent comment: %w", err)
+	}
+	if parent.Status != StatusApproved {
+		return fmt.Errorf("%w: parent comment is not published", ErrInvalidParent)
+	}
+	if parent.Slug != c.Slug {
+		return fmt.Errorf("%w: parent comment belongs to a different slug", ErrInvalidParent)
+	}
+	return nil
+}
+
+// GetComment returns a comment together with its reactions. Comments that are
+// not approved are only visible to their author and to moderators.
 func (s *Service) GetComment(ctx context.Context, id string) (Comment, error) {
+	comment, err := s.getComment(ctx, id)
+	if err != nil {
+		return Comment{}, err
+	}
+
+	comments := []Comment{comment}
+	if err = s.attachReactions(ctx, comments); err != nil {
+		return Comment{}, err
+	}
+	return comments[0], nil
+}
+
+// getComment returns a comment that is visible to the Actor in ctx.
+func (s *Service) getComment(ctx context.Context, id string) (Comment, error) {
 	comment, err := s.Store.GetComment(ctx, id)
 	if err != nil {
 		s.logger.ErrorContext(ctx, "failed to get comment", slog.Any("error", err))
+		return Comment{}, fmt.Errorf("failed to get comment: %w", err)
+	}
+	if comment.Status != StatusApproved && authorize(ctx, comment) != nil {
 		return Comment{}, ErrCommentNotFound
 	}
 	return comment, nil
 }
 
+// GetCommentIncludingDeleted behaves like GetComment but also returns
+// comments that have been soft deleted.
+func (s *Service) GetCommentIncludingDeleted(ctx context.Context, id string) (Comment, error) {
+	comment, err := s.Store.GetCommentIncludingDeleted(ctx, id)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to get comment", slog.Any("error", err))
+		return Comment{}, fmt.Errorf("failed to get comment: %w", err)
+	}
+	return comment, nil
+}
+
+// ListComments pages through the approved comments of a slug.
+func (s *Service) ListComments(ctx context.Context, opts ListOptions) (Page, error) {
+	params, err := resolveListOptions(opts)
+	if err != nil {
+		return Page{}, err
+	}
+	params.Status = StatusApproved
+
+	page, err := s.listPage(ctx, params)
+	if err != nil {
+		return Page{}, err
+	}
+
+	if err = s.attachReactions(ctx, page.Comments); err != nil {
+		return Page{}, err
+	}
+	return page, nil
+}
+
+func (s *Service) listPage(ctx context.Context, params ListParams) (Page, error) {
+	// Fetch one extra row to find out whether there is a next page.
+	limit := params.Limit
+	params.Limit++
+
+	comments, err := s.Store.ListComments(ctx, params)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to list comments", slog.Any("error", err))
+		return Page{}, fmt.Errorf("failed to list comments: %w", err)
+	}
+
+	page := Page{Comments: comments}
+	if page.Comments == nil {
+		page.Comments = []Comment{}
+	}
+	if len(comments) > limit {
+		page.Comments = comments[:limit]
+		page.NextCursor = encodeCursor(cursor{ID: page.Comments[limit-1].ID})
+	}
+
+	return page, nil
+}
+
+func resolveListOptions(opts ListOptions) (ListParams, error) {
+	if opts.Slug == "" {
+		return ListParams{}, fmt.Errorf("%w: slug is required", ErrInvalidListOptions)
+	}
+	return resolvePageOptions(opts)
+}
+
+// resolvePageOptions validates the paging part of opts. The slug is optional.
+func resolvePageOptions(opts ListOptions) (ListParams, error) {
+	params := ListParams{
+		Slug:           opts.Slug,
+		Limit:          opts.Limit,
+		Order:          opts.Order,
+		IncludeDeleted: opts.IncludeDeleted,
+	}
+
+	if params.Limit == 0 {
+		params.Limit = DefaultPageSize
+	}
+	if params.Limit < 1 || params.Limit > MaxPageSize {
+		return ListParams{}, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidListOptions, MaxPageSize)
+	}
+
+	switch params.Order {
+	case "":
+		params.Order = OrderAsc
+	case OrderAsc, OrderDesc:
+	default:
+		return ListParams{}, fmt.Errorf("%w: order must be %q or %q", ErrInvalidListOptions, OrderAsc, OrderDesc)
+	}
+
+	if opts.Cursor != "" {
+		c, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return ListParams{}, err
+		}
+		params.AfterID = c.ID
+	}
+
+	return params, nil
+}
+
+// UpdateComment stores c if the stored version still matches c.Version, or
+// c.Version is AnyVersion, otherwise ErrVersionConflict is returned. The previous content is kept as a
+// Revision, attributed to the Actor found in ctx. Only the author or a
+// moderator may update a comment, and neither the author nor the slug ever
+// change: a slug of its own would separate a reply from its thread. An
+// update flagged by a filter sends the comment back to the moderation queue.
 func (s *Service) UpdateComment(ctx context.Context, c Comment) error {
-	if err := s.Store.UpdateComment(ctx, c); err != nil {
+	existing, err := s.Store.GetComment(ctx, c.ID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to get comment", slog.Any("error", err))
+		return fmt.Errorf("failed to update comment: %w", err)
+	}
+	if err = authorize(ctx, existing); err != nil {
+		return err
+	}
+	if c.Slug != "" && c.Slug != existing.Slug {
+		return ErrSlugChanged
+	}
+
+	c.Slug = existing.Slug
+	c.Author = existing.Author
+	c.Status = existing.Status
+
+	c, flagged, err := s.runFilters(ctx, c)
+	if err != nil {
+		return err
+	}
+	if flagged && !isModerator(ctx) {
+		c.Status = StatusPending
+	}
+
+	updated, err := s.Store.UpdateComment(ctx, c)
+	if err != nil {
 		s.logger.ErrorContext(ctx, "failed to update comment", slog.Any("error", err))
 		return fmt.Errorf("failed to update comment: %w", err)
 	}
+
+	s.recordFilters(updated)
+	s.publish(ctx, CommentUpdated, &existing, &updated)
+
 	return nil
 }
 
-func (s *Service) DeleteComment(ctx context.Context, id string) error {
-	if err := s.Store.DeleteComment(ctx, id); err != nil {
+// DeleteComment soft deletes the comment if its stored version still matches
+// version, or version is AnyVersion, otherwise ErrVersionConflict is returned. Only the author or a
+// moderator may delete a comment.
+func (s *Service) DeleteComment(ctx context.Context, id string, version int) error {
+	existing, err := s.Store.GetComment(ctx, id)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to get comment", slog.Any("error", err))
+		return fmt.Errorf("failed to delete comment: %w", err)
+	}
+	if err = authorize(ctx, existing); err != nil {
+		return err
+	}
+
+	if err = s.Store.DeleteComment(ctx, id, version); err != nil {
 		s.logger.ErrorContext(ctx, "failed to delete comment", slog.Any("error", err))
 		return fmt.Errorf("failed to delete comment: %w", err)
 	}
+
+	s.publish(ctx, CommentDeleted, &existing, nil)
+
+	return nil
+}
+
+// RestoreComment undoes a soft delete. Only the author or a moderator may
+// restore a comment.
+func (s *Service) RestoreComment(ctx context.Context, id string) error {
+	existing, err := s.Store.GetCommentIncludingDeleted(ctx, id)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to get comment", slog.Any("error", err))
+		return fmt.Errorf("failed to restore comment: %w", err)
+	}
+	if err = authorize(ctx, existing); err != nil {
+		return err
+	}
+
+	restored, err := s.Store.RestoreComment(ctx, id)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to restore comment", slog.Any("error", err))
+		return fmt.Errorf("failed to restore comment: %w", err)
+	}
+
+	s.publish(ctx, CommentUpdated, &existing, &restored)
+
 	return nil
 }
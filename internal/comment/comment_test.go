@@ -3,10 +3,14 @@
 package comment_test
 
 import (
+	"cmp"
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/azdanov/go-rest-api/internal/comment"
 	"github.com/stretchr/testify/assert"
@@ -23,6 +27,21 @@ func (m *MockStore) GetComment(ctx context.Context, id string) (comment.Comment,
 	return args.Get(0).(comment.Comment), args.Error(1)
 }
 
+func (m *MockStore) ListComments(ctx context.Context, p comment.ListParams) ([]comment.Comment, error) {
+	args := m.Called(ctx, p)
+	return args.Get(0).([]comment.Comment), args.Error(1)
+}
+
+func (m *MockStore) GetCommentIncludingDeleted(ctx context.Context, id string) (comment.Comment, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(comment.Comment), args.Error(1)
+}
+
+func (m *MockStore) ListReplies(ctx context.Context, p comment.ReplyParams) ([]comment.Comment, error) {
+	args := m.Called(ctx, p)
+	return args.Get(0).([]comment.Comment), args.Error(1)
+}
+
 func (m *MockStore) CreateComment(ctx context.Context, c comment.Comment) (comment.Comment, error) {
 	args := m.Called(ctx, c)
 
@@ -31,13 +50,54 @@ func (m *MockStore) CreateComment(ctx context.Context, c comment.Comment) (comme
 	return cmt, args.Error(1)
 }
 
-func (m *MockStore) UpdateComment(ctx context.Context, c comment.Comment) error {
+func (m *MockStore) UpdateComment(ctx context.Context, c comment.Comment) (comment.Comment, error) {
 	args := m.Called(ctx, c)
+
+	c.Version++ // Simulate the stored version
+	return c, args.Error(0)
+}
+
+func (m *MockStore) DeleteComment(ctx context.Context, id string, version int) error {
+	args := m.Called(ctx, id, version)
 	return args.Error(0)
 }
 
-func (m *MockStore) DeleteComment(ctx context.Context, id string) error {
+func (m *MockStore) ListRevisions(ctx context.Context, commentID string) ([]comment.Revision, error) {
+	args := m.Called(ctx, commentID)
+	return args.Get(0).([]comment.Revision), args.Error(1)
+}
+
+func (m *MockStore) RestoreComment(ctx context.Context, id string) (comment.Comment, error) {
 	args := m.Called(ctx, id)
+	return comment.Comment{ID: id}, args.Error(0)
+}
+
+func (m *MockStore) Search(ctx context.Context, p comment.SearchParams) ([]comment.SearchResult, error) {
+	args := m.Called(ctx, p)
+	return args.Get(0).([]comment.SearchResult), args.Error(1)
+}
+
+func (m *MockStore) AddReaction(ctx context.Context, r comment.Reaction) error {
+	args := m.Called(ctx, r)
+	return args.Error(0)
+}
+
+func (m *MockStore) RemoveReaction(ctx context.Context, r comment.Reaction) error {
+	args := m.Called(ctx, r)
+	return args.Error(0)
+}
+
+func (m *MockStore) SummarizeReactions(
+	ctx context.Context,
+	commentIDs []string,
+	userID string,
+) (map[string][]comment.ReactionSummary, error) {
+	args := m.Called(ctx, commentIDs, userID)
+	return args.Get(0).(map[string][]comment.ReactionSummary), args.Error(1)
+}
+
+func (m *MockStore) ModerateComment(ctx context.Context, mod comment.Moderation) error {
+	args := m.Called(ctx, mod)
 	return args.Error(0)
 }
 
@@ -80,6 +140,22 @@ func TestGetComment_NotFound(t *testing.T) {
 	mockStore.AssertExpectations(t)
 }
 
+func TestGetComment_Unavailable(t *testing.T) {
+	mockStore := new(MockStore)
+	logger := slog.Default()
+	service := comment.NewService(mockStore, logger)
+
+	ctx := t.Context()
+	storeErr := fmt.Errorf("failed to scan comment row: %w", comment.ErrUnavailable)
+	mockStore.On("GetComment", ctx, "test-id").Return(comment.Comment{}, storeErr)
+
+	_, err := service.GetComment(ctx, "test-id")
+	require.ErrorIs(t, err, comment.ErrUnavailable)
+	require.NotErrorIs(t, err, comment.ErrCommentNotFound)
+
+	mockStore.AssertExpectations(t)
+}
+
 func TestGetComment_Success(t *testing.T) {
 	mockStore := new(MockStore)
 	logger := slog.Default()
@@ -91,13 +167,18 @@ func TestGetComment_Success(t *testing.T) {
 		Slug:   "test-slug",
 		Body:   "This is a test comment",
 		Author: "test-author",
+		Status: comment.StatusApproved,
 	}
 
+	reactions := []comment.ReactionSummary{{Kind: comment.ReactionLike, Count: 3}}
 	mockStore.On("GetComment", ctx, "test-id").Return(expectedComment, nil)
+	mockStore.On("SummarizeReactions", ctx, []string{"test-id"}, "").
+		Return(map[string][]comment.ReactionSummary{"test-id": reactions}, nil)
 
 	retrievedComment, err := service.GetComment(ctx, "test-id")
 
 	require.NoError(t, err)
+	assert.Equal(t, reactions, retrievedComment.Reactions)
 	assert.Equal(t, expectedComment.ID, retrievedComment.ID)
 	assert.Equal(t, expectedComment.Slug, retrievedComment.Slug)
 	assert.Equal(t, expectedComment.Body, retrievedComment.Body)
@@ -111,7 +192,7 @@ func TestUpdateComment_Success(t *testing.T) {
 	logger := slog.Default()
 	service := comment.NewService(mockStore, logger)
 
-	ctx := t.Context()
+	ctx := comment.WithActor(t.Context(), comment.Actor{ID: "test-author"})
 	commentToUpdate := comment.Comment{
 		ID:     "test-id",
 		Slug:   "updated-slug",
@@ -119,6 +200,7 @@ func TestUpdateComment_Success(t *testing.T) {
 		Author: "test-author",
 	}
 
+	mockStore.On("GetComment", ctx, commentToUpdate.ID).Return(commentToUpdate, nil)
 	mockStore.On("UpdateComment", ctx, commentToUpdate).Return(nil)
 
 	err := service.UpdateComment(ctx, commentToUpdate)
@@ -132,7 +214,7 @@ func TestUpdateComment_Error(t *testing.T) {
 	logger := slog.Default()
 	service := comment.NewService(mockStore, logger)
 
-	ctx := t.Context()
+	ctx := comment.WithActor(t.Context(), comment.Actor{ID: "test-author"})
 	commentToUpdate := comment.Comment{
 		ID:     "test-id",
 		Slug:   "updated-slug",
@@ -141,6 +223,7 @@ func TestUpdateComment_Error(t *testing.T) {
 	}
 
 	mockError := errors.New("update failed")
+	mockStore.On("GetComment", ctx, commentToUpdate.ID).Return(commentToUpdate, nil)
 	mockStore.On("UpdateComment", ctx, commentToUpdate).Return(mockError)
 
 	err := service.UpdateComment(ctx, commentToUpdate)
@@ -150,15 +233,34 @@ func TestUpdateComment_Error(t *testing.T) {
 	mockStore.AssertExpectations(t)
 }
 
+func TestUpdateComment_SlugChanged(t *testing.T) {
+	mockStore := new(MockStore)
+	service := comment.NewService(mockStore, slog.Default())
+
+	ctx := comment.WithActor(t.Context(), comment.Actor{ID: "test-author"})
+	existing := comment.Comment{ID: "test-id", ParentID: "parent-id", Slug: "slug", Author: "test-author"}
+	mockStore.On("GetComment", ctx, existing.ID).Return(existing, nil)
+
+	err := service.UpdateComment(ctx, comment.Comment{ID: existing.ID, Slug: "other-slug", Body: "moved"})
+	require.ErrorIs(t, err, comment.ErrSlugChanged)
+
+	mockStore.On("UpdateComment", ctx, mock.MatchedBy(func(c comment.Comment) bool {
+		return c.Slug == existing.Slug
+	})).Return(nil)
+	require.NoError(t, service.UpdateComment(ctx, comment.Comment{ID: existing.ID, Body: "kept"}))
+	mockStore.AssertExpectations(t)
+}
+
 func TestDeleteComment_Success(t *testing.T) {
 	mockStore := new(MockStore)
 	logger := slog.Default()
 	service := comment.NewService(mockStore, logger)
 
-	ctx := t.Context()
-	mockStore.On("DeleteComment", ctx, "test-id").Return(nil)
+	ctx := comment.WithActor(t.Context(), comment.Actor{ID: "test-author"})
+	mockStore.On("GetComment", ctx, "test-id").Return(comment.Comment{ID: "test-id", Author: "test-author"}, nil)
+	mockStore.On("DeleteComment", ctx, "test-id", 1).Return(nil)
 
-	err := service.DeleteComment(ctx, "test-id")
+	err := service.DeleteComment(ctx, "test-id", 1)
 
 	require.NoError(t, err)
 	mockStore.AssertExpectations(t)
@@ -169,13 +271,739 @@ func TestDeleteComment_Error(t *testing.T) {
 	logger := slog.Default()
 	service := comment.NewService(mockStore, logger)
 
-	ctx := t.Context()
+	ctx := comment.WithActor(t.Context(), comment.Actor{ID: "test-author"})
 	mockError := errors.New("delete failed")
-	mockStore.On("DeleteComment", ctx, "test-id").Return(mockError)
+	mockStore.On("GetComment", ctx, "test-id").Return(comment.Comment{ID: "test-id", Author: "test-author"}, nil)
+	mockStore.On("DeleteComment", ctx, "test-id", 1).Return(mockError)
 
-	err := service.DeleteComment(ctx, "test-id")
+	err := service.DeleteComment(ctx, "test-id", 1)
 
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to delete comment")
 	mockStore.AssertExpectations(t)
 }
+
+func TestListComments_NextCursor(t *testing.T) {
+	mockStore := new(MockStore)
+	logger := slog.Default()
+	service := comment.NewService(mockStore, logger)
+
+	ctx := t.Context()
+	mockStore.On("SummarizeReactions", mock.Anything, mock.Anything, mock.Anything).
+		Return(map[string][]comment.ReactionSummary{}, nil)
+	stored := []comment.Comment{
+		{ID: "0196a0c0-0000-7000-8000-000000000001", Slug: "test-slug"},
+		{ID: "0196a0c0-0000-7000-8000-000000000002", Slug: "test-slug"},
+		{ID: "0196a0c0-0000-7000-8000-000000000003", Slug: "test-slug"},
+	}
+
+	mockStore.On("ListComments", ctx, comment.ListParams{
+		Slug:   "test-slug",
+		Status: comment.StatusApproved,
+		Limit:  3,
+		Order:  comment.OrderAsc,
+	}).Return(stored, nil)
+
+	page, err := service.ListComments(ctx, comment.ListOptions{Slug: "test-slug", Limit: 2})
+
+	require.NoError(t, err)
+	assert.Equal(t, stored[:2], page.Comments)
+	require.NotEmpty(t, page.NextCursor)
+
+	mockStore.On("ListComments", ctx, comment.ListParams{
+		Slug:    "test-slug",
+		Status:  comment.StatusApproved,
+		AfterID: stored[1].ID,
+		Limit:   3,
+		Order:   comment.OrderAsc,
+	}).Return(stored[2:], nil)
+
+	page, err = service.ListComments(ctx, comment.ListOptions{Slug: "test-slug", Limit: 2, Cursor: page.NextCursor})
+
+	require.NoError(t, err)
+	assert.Equal(t, stored[2:], page.Comments)
+	assert.Empty(t, page.NextCursor)
+
+	mockStore.AssertExpectations(t)
+}
+
+func TestListComments_InvalidOptions(t *testing.T) {
+	mockStore := new(MockStore)
+	logger := slog.Default()
+	service := comment.NewService(mockStore, logger)
+
+	ctx := t.Context()
+
+	_, err := service.ListComments(ctx, comment.ListOptions{})
+	require.ErrorIs(t, err, comment.ErrInvalidListOptions)
+
+	_, err = service.ListComments(ctx, comment.ListOptions{Slug: "test-slug", Limit: comment.MaxPageSize + 1})
+	require.ErrorIs(t, err, comment.ErrInvalidListOptions)
+
+	_, err = service.ListComments(ctx, comment.ListOptions{Slug: "test-slug", Order: "sideways"})
+	require.ErrorIs(t, err, comment.ErrInvalidListOptions)
+
+	_, err = service.ListComments(ctx, comment.ListOptions{Slug: "test-slug", Cursor: "not-a-cursor"})
+	require.ErrorIs(t, err, comment.ErrInvalidCursor)
+
+	mockStore.AssertNotCalled(t, "ListComments")
+}
+
+func TestCreateComment_ParentSlugMismatch(t *testing.T) {
+	mockStore := new(MockStore)
+	logger := slog.Default()
+	service := comment.NewService(mockStore, logger)
+
+	ctx := t.Context()
+	parent := comment.Comment{ID: "parent-id", Slug: "other-slug", Status: comment.StatusApproved}
+	mockStore.On("GetComment", ctx, parent.ID).Return(parent, nil)
+
+	_, err := service.CreateComment(ctx, comment.Comment{
+		ParentID: parent.ID,
+		Slug:     "test-slug",
+		Body:     "This is a reply",
+		Author:   "test-author",
+	})
+
+	require.ErrorIs(t, err, comment.ErrInvalidParent)
+	mockStore.AssertNotCalled(t, "CreateComment", mock.Anything, mock.Anything)
+	mockStore.AssertExpectations(t)
+}
+
+func TestGetReplies_BuildsTree(t *testing.T) {
+	mockStore := new(MockStore)
+	logger := slog.Default()
+	service := comment.NewService(mockStore, logger)
+
+	ctx := t.Context()
+	root := comment.Comment{ID: "root", Slug: "test-slug", Status: comment.StatusApproved}
+	replies := []comment.Comment{
+		{ID: "a", ParentID: "root", Slug: "test-slug"},
+		{ID: "b", ParentID: "a", Slug: "test-slug"},
+		{ID: "c", ParentID: "root", Slug: "test-slug"},
+	}
+
+	mockStore.On("GetComment", ctx, root.ID).Return(root, nil)
+	mockStore.On("SummarizeReactions", mock.Anything, mock.Anything, mock.Anything).
+		Return(map[string][]comment.ReactionSummary{}, nil)
+	mockStore.On("ListReplies", ctx, comment.ReplyParams{
+		ParentIDs: []string{root.ID},
+		MaxDepth:  comment.DefaultThreadDepth,
+		Status:    comment.StatusApproved,
+	}).Return(replies, nil)
+
+	threads, err := service.GetReplies(ctx, root.ID, 0)
+
+	require.NoError(t, err)
+	require.Len(t, threads, 2)
+	assert.Equal(t, "a", threads[0].ID)
+	require.Len(t, threads[0].Replies, 1)
+	assert.Equal(t, "b", threads[0].Replies[0].ID)
+	assert.Equal(t, "c", threads[1].ID)
+	assert.Empty(t, threads[1].Replies)
+
+	mockStore.AssertExpectations(t)
+}
+
+func TestRestoreComment_NotFound(t *testing.T) {
+	mockStore := new(MockStore)
+	logger := slog.Default()
+	service := comment.NewService(mockStore, logger)
+
+	ctx := comment.WithActor(t.Context(), comment.Actor{ID: "test-author"})
+	mockStore.On("GetCommentIncludingDeleted", ctx, "test-id").
+		Return(comment.Comment{ID: "test-id", Author: "test-author"}, nil)
+	mockStore.On("RestoreComment", ctx, "test-id").Return(comment.ErrCommentNotFound)
+
+	err := service.RestoreComment(ctx, "test-id")
+
+	require.ErrorIs(t, err, comment.ErrCommentNotFound)
+	mockStore.AssertExpectations(t)
+}
+
+func TestUpdateComment_VersionConflict(t *testing.T) {
+	mockStore := new(MockStore)
+	logger := slog.Default()
+	service := comment.NewService(mockStore, logger)
+
+	ctx := comment.WithActor(t.Context(), comment.Actor{ID: "test-author"})
+	commentToUpdate := comment.Comment{
+		ID:      "test-id",
+		Slug:    "updated-slug",
+		Body:    "This is an updated comment",
+		Author:  "test-author",
+		Version: 1,
+	}
+
+	mockStore.On("GetComment", ctx, commentToUpdate.ID).Return(commentToUpdate, nil)
+	mockStore.On("UpdateComment", ctx, commentToUpdate).Return(comment.ErrVersionConflict)
+
+	err := service.UpdateComment(ctx, commentToUpdate)
+
+	require.ErrorIs(t, err, comment.ErrVersionConflict)
+	mockStore.AssertExpectations(t)
+}
+
+func TestListRevisions_LargeDiff(t *testing.T) {
+	mockStore := new(MockStore)
+	service := comment.NewService(mockStore, slog.Default())
+
+	ctx := t.Context()
+	lines := strings.Repeat("\n", 5000)
+	current := comment.Comment{ID: "test-id", Body: lines + "b", Status: comment.StatusApproved}
+	mockStore.On("GetComment", ctx, current.ID).Return(current, nil)
+	mockStore.On("ListRevisions", ctx, current.ID).Return([]comment.Revision{
+		{CommentID: current.ID, Number: 1, Body: lines + "a"},
+	}, nil)
+
+	revisions, err := service.ListRevisions(ctx, current.ID)
+
+	require.NoError(t, err)
+	require.Len(t, revisions, 1)
+	// Too large to compare line by line, the whole body is replaced.
+	diff := revisions[0].Diff
+	assert.Equal(t, 5001, strings.Count(diff, "\n-"))
+	assert.True(t, strings.HasSuffix(diff, "\n-a\n"+strings.Repeat("+\n", 5000)+"+b\n"))
+}
+
+func TestListRevisions_Diff(t *testing.T) {
+	mockStore := new(MockStore)
+	logger := slog.Default()
+	service := comment.NewService(mockStore, logger)
+
+	ctx := t.Context()
+	current := comment.Comment{ID: "test-id", Body: "first line\nthird line", Status: comment.StatusApproved}
+	mockStore.On("GetComment", ctx, current.ID).Return(current, nil)
+	mockStore.On("ListRevisions", ctx, current.ID).Return([]comment.Revision{
+		{CommentID: current.ID, Number: 1, Body: "first line"},
+		{CommentID: current.ID, Number: 2, Body: "first line\nsecond line"},
+	}, nil)
+
+	revisions, err := service.ListRevisions(ctx, current.ID)
+
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	assert.Equal(t, "--- revision 1\n+++ revision 2\n first line\n+second line\n", revisions[0].Diff)
+	assert.Equal(t, "--- revision 2\n+++ current\n first line\n-second line\n+third line\n", revisions[1].Diff)
+
+	_, err = service.GetRevision(ctx, current.ID, 3)
+	require.ErrorIs(t, err, comment.ErrRevisionNotFound)
+
+	mockStore.AssertExpectations(t)
+}
+
+func TestUpdateComment_Forbidden(t *testing.T) {
+	mockStore := new(MockStore)
+	logger := slog.Default()
+	service := comment.NewService(mockStore, logger)
+
+	ctx := comment.WithActor(t.Context(), comment.Actor{ID: "someone-else"})
+	existing := comment.Comment{
+		ID:      "test-id",
+		Slug:    "test-slug",
+		Body:    "This is a test comment",
+		Author:  "test-author",
+		Version: 1,
+	}
+	mockStore.On("GetComment", ctx, existing.ID).Return(existing, nil)
+
+	update := existing
+	update.Body = "Hijacked"
+	err := service.UpdateComment(ctx, update)
+
+	require.ErrorIs(t, err, comment.ErrForbidden)
+	mockStore.AssertNotCalled(t, "UpdateComment", mock.Anything, mock.Anything)
+	mockStore.AssertExpectations(t)
+}
+
+func TestDeleteComment_Moderator(t *testing.T) {
+	mockStore := new(MockStore)
+	logger := slog.Default()
+	service := comment.NewService(mockStore, logger)
+
+	ctx := comment.WithActor(t.Context(), comment.Actor{ID: "moderator", Moderator: true})
+	mockStore.On("GetComment", ctx, "test-id").Return(comment.Comment{ID: "test-id", Author: "test-author"}, nil)
+	mockStore.On("DeleteComment", ctx, "test-id", 1).Return(nil)
+
+	err := service.DeleteComment(ctx, "test-id", 1)
+
+	require.NoError(t, err)
+	mockStore.AssertExpectations(t)
+}
+
+func TestUpdateComment_KeepsAuthor(t *testing.T) {
+	mockStore := new(MockStore)
+	logger := slog.Default()
+	service := comment.NewService(mockStore, logger)
+
+	ctx := comment.WithActor(t.Context(), comment.Actor{ID: "moderator", Moderator: true})
+	existing := comment.Comment{ID: "test-id", Slug: "test-slug", Body: "body", Author: "test-author", Version: 1}
+	mockStore.On("GetComment", ctx, existing.ID).Return(existing, nil)
+	mockStore.On("UpdateComment", ctx, existing).Return(nil)
+
+	update := existing
+	update.Author = "moderator"
+	err := service.UpdateComment(ctx, update)
+
+	require.NoError(t, err)
+	mockStore.AssertExpectations(t)
+}
+
+func TestCreateComment_PendingPolicy(t *testing.T) {
+	mockStore := new(MockStore)
+	logger := slog.Default()
+	policy := comment.SlugPolicy{Slugs: map[string]bool{"moderated-slug": true}}
+	service := comment.NewService(mockStore, logger, comment.WithModerationPolicy(policy))
+
+	ctx := t.Context()
+	mockStore.On("CreateComment", ctx, mock.MatchedBy(func(c comment.Comment) bool {
+		return c.Slug == "moderated-slug" && c.Status == comment.StatusPending
+	})).Return(comment.Comment{Slug: "moderated-slug", Status: comment.StatusPending}, nil)
+	mockStore.On("CreateComment", ctx, mock.MatchedBy(func(c comment.Comment) bool {
+		return c.Slug == "open-slug" && c.Status == comment.StatusApproved
+	})).Return(comment.Comment{Slug: "open-slug", Status: comment.StatusApproved}, nil)
+
+	created, err := service.CreateComment(ctx, comment.Comment{Slug: "moderated-slug", Body: "body"})
+	require.NoError(t, err)
+	assert.Equal(t, comment.StatusPending, created.Status)
+
+	created, err = service.CreateComment(ctx, comment.Comment{Slug: "open-slug", Body: "body"})
+	require.NoError(t, err)
+	assert.Equal(t, comment.StatusApproved, created.Status)
+
+	mockStore.AssertExpectations(t)
+}
+
+func TestGetComment_PendingHiddenFromPublic(t *testing.T) {
+	mockStore := new(MockStore)
+	logger := slog.Default()
+	service := comment.NewService(mockStore, logger)
+
+	pending := comment.Comment{ID: "test-id", Author: "test-author", Status: comment.StatusPending}
+	mockStore.On("GetComment", mock.Anything, pending.ID).Return(pending, nil)
+	mockStore.On("SummarizeReactions", mock.Anything, mock.Anything, mock.Anything).
+		Return(map[string][]comment.ReactionSummary{}, nil)
+
+	_, err := service.GetComment(t.Context(), pending.ID)
+	require.ErrorIs(t, err, comment.ErrCommentNotFound)
+
+	ctx := comment.WithActor(t.Context(), comment.Actor{ID: "test-author"})
+	fetched, err := service.GetComment(ctx, pending.ID)
+	require.NoError(t, err)
+	assert.Equal(t, pending.ID, fetched.ID)
+}
+
+func TestModerate_Transitions(t *testing.T) {
+	mockStore := new(MockStore)
+	logger := slog.Default()
+	service := comment.NewService(mockStore, logger)
+
+	ctx := comment.WithActor(t.Context(), comment.Actor{ID: "moderator", Moderator: true})
+	pending := comment.Comment{ID: "test-id", Status: comment.StatusPending}
+	approved := comment.Comment{ID: "test-id", Status: comment.StatusApproved}
+
+	mockStore.On("GetComment", ctx, pending.ID).Return(pending, nil).Once()
+	mockStore.On("ModerateComment", ctx, comment.Moderation{
+		CommentID: pending.ID,
+		From:      comment.StatusPending,
+		To:        comment.StatusApproved,
+		Reason:    "looks fine",
+		Moderator: "moderator",
+	}).Return(nil)
+	mockStore.On("GetComment", ctx, pending.ID).Return(approved, nil).Once()
+
+	moderated, err := service.Moderate(ctx, pending.ID, comment.StatusApproved, "looks fine")
+	require.NoError(t, err)
+	assert.Equal(t, comment.StatusApproved, moderated.Status)
+
+	mockStore.On("GetComment", ctx, pending.ID).Return(approved, nil).Once()
+	_, err = service.Moderate(ctx, pending.ID, comment.StatusRejected, "too late")
+	require.ErrorIs(t, err, comment.ErrInvalidTransition)
+
+	_, err = service.Moderate(
+		comment.WithActor(t.Context(), comment.Actor{ID: "test-author"}), pending.ID, comment.StatusHidden, "",
+	)
+	require.ErrorIs(t, err, comment.ErrForbidden)
+
+	mockStore.AssertExpectations(t)
+}
+
+func TestCreateComment_FilterVerdicts(t *testing.T) {
+	mockStore := new(MockStore)
+	logger := slog.Default()
+	shouting, err := comment.NewRegexFilter("no-shouting", `[A-Z]{10,}`, comment.ActionFlag, "too much shouting")
+	require.NoError(t, err)
+	service := comment.NewService(mockStore, logger, comment.WithFilters(
+		comment.NewWordListFilter([]string{"darn"}, comment.ActionRewrite),
+		comment.NewWordListFilter([]string{"casino"}, comment.ActionReject),
+		comment.LinkLimitFilter{Max: 1, Action: comment.ActionReject},
+		shouting,
+	))
+
+	ctx := t.Context()
+	var stored comment.Comment
+	mockStore.On("CreateComment", ctx, mock.AnythingOfType("comment.Comment")).Run(func(args mock.Arguments) {
+		stored = args.Get(1).(comment.Comment)
+	}).Return(comment.Comment{}, nil)
+
+	_, err = service.CreateComment(ctx, comment.Comment{Slug: "test-slug", Body: "Darn it"})
+	require.NoError(t, err)
+	assert.Equal(t, "**** it", stored.Body)
+	assert.Equal(t, comment.StatusApproved, stored.Status)
+
+	_, err = service.CreateComment(ctx, comment.Comment{Slug: "test-slug", Body: "STOPSHOUTING please"})
+	require.NoError(t, err)
+	assert.Equal(t, comment.StatusPending, stored.Status)
+
+	_, err = service.CreateComment(ctx, comment.Comment{Slug: "test-slug", Body: "visit the Casino"})
+	require.ErrorIs(t, err, comment.ErrRejected)
+	var fe *comment.FilterError
+	require.ErrorAs(t, err, &fe)
+	assert.Equal(t, "word-list", fe.Verdict.Filter)
+	assert.Equal(t, comment.ActionReject, fe.Verdict.Action)
+
+	_, err = service.CreateComment(ctx, comment.Comment{Slug: "test-slug", Body: "https://a.example https://b.example"})
+	require.ErrorAs(t, err, &fe)
+	assert.Equal(t, "link-limit", fe.Verdict.Filter)
+
+	mockStore.AssertNumberOfCalls(t, "CreateComment", 2)
+}
+
+func TestDuplicateFilter(t *testing.T) {
+	f := comment.NewDuplicateFilter(time.Minute)
+	ctx := t.Context()
+
+	first := comment.Comment{ID: "a", Slug: "test-slug", Author: "test-author", Body: "Same body"}
+	v, err := f.Check(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, comment.ActionAllow, v.Action)
+
+	v, err = f.Check(ctx, comment.Comment{ID: "b", Slug: "test-slug", Author: "test-author", Body: "Same body"})
+	require.NoError(t, err)
+	assert.Equal(t, comment.ActionAllow, v.Action, "a comment that was not stored is no duplicate")
+	f.Record(first)
+
+	v, err = f.Check(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, comment.ActionAllow, v.Action, "an update keeping the body is no duplicate")
+
+	v, err = f.Check(ctx, comment.Comment{ID: "b", Slug: "test-slug", Author: "test-author", Body: " same BODY "})
+	require.NoError(t, err)
+	assert.Equal(t, comment.ActionReject, v.Action)
+
+	v, err = f.Check(ctx, comment.Comment{ID: "c", Slug: "test-slug", Author: "other-author", Body: "Same body"})
+	require.NoError(t, err)
+	assert.Equal(t, comment.ActionAllow, v.Action)
+}
+
+func TestCreateComment_RetryIsNoDuplicate(t *testing.T) {
+	mockStore := new(MockStore)
+	service := comment.NewService(
+		mockStore,
+		slog.Default(),
+		comment.WithFilters(comment.NewDuplicateFilter(time.Minute)),
+	)
+
+	ctx := t.Context()
+	posted := comment.Comment{Slug: "test-slug", Body: "Same body", Author: "test-author"}
+	mockStore.On("CreateComment", ctx, mock.AnythingOfType("comment.Comment")).
+		Return(comment.Comment{}, comment.ErrUnavailable).Once()
+	mockStore.On("CreateComment", ctx, mock.AnythingOfType("comment.Comment")).Return(posted, nil)
+
+	_, err := service.CreateComment(ctx, posted)
+	require.ErrorIs(t, err, comment.ErrUnavailable)
+
+	_, err = service.CreateComment(ctx, posted)
+	require.NoError(t, err)
+
+	_, err = service.CreateComment(ctx, posted)
+	require.ErrorIs(t, err, comment.ErrRejected)
+	mockStore.AssertNumberOfCalls(t, "CreateComment", 2)
+}
+
+func TestSearch_Pagination(t *testing.T) {
+	mockStore := new(MockStore)
+	logger := slog.Default()
+	service := comment.NewService(mockStore, logger)
+
+	ctx := t.Context()
+	results := []comment.SearchResult{
+		{Comment: comment.Comment{ID: "a"}, Rank: 0.9},
+		{Comment: comment.Comment{ID: "b"}, Rank: 0.5},
+		{Comment: comment.Comment{ID: "c"}, Rank: 0.1},
+	}
+
+	mockStore.On("Search", ctx, comment.SearchParams{
+		Query:  "needle",
+		Author: "test-author",
+		Status: comment.StatusApproved,
+		Limit:  3,
+	}).Return(results, nil)
+
+	page, err := service.Search(ctx, comment.SearchOptions{Query: " needle ", Author: "test-author", Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, results[:2], page.Results)
+	require.NotEmpty(t, page.NextCursor)
+
+	mockStore.On("Search", ctx, comment.SearchParams{
+		Query:  "needle",
+		Author: "test-author",
+		Status: comment.StatusApproved,
+		Offset: 2,
+		Limit:  3,
+	}).Return(results[2:], nil)
+
+	page, err = service.Search(ctx, comment.SearchOptions{
+		Query:  "needle",
+		Author: "test-author",
+		Limit:  2,
+		Cursor: page.NextCursor,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, results[2:], page.Results)
+	assert.Empty(t, page.NextCursor)
+
+	_, err = service.Search(ctx, comment.SearchOptions{Query: "  "})
+	require.ErrorIs(t, err, comment.ErrInvalidListOptions)
+
+	_, err = service.Search(ctx, comment.SearchOptions{Query: "needle", Cursor: "bogus"})
+	require.ErrorIs(t, err, comment.ErrInvalidCursor)
+
+	mockStore.AssertExpectations(t)
+}
+
+func TestAddReaction(t *testing.T) {
+	mockStore := new(MockStore)
+	logger := slog.Default()
+	service := comment.NewService(mockStore, logger)
+
+	ctx := comment.WithActor(t.Context(), comment.Actor{ID: "test-user"})
+	target := comment.Comment{ID: "test-id", Author: "test-author", Status: comment.StatusApproved}
+	mockStore.On("GetComment", ctx, target.ID).Return(target, nil)
+	mockStore.On("AddReaction", ctx, comment.Reaction{
+		CommentID: target.ID,
+		UserID:    "test-user",
+		Kind:      comment.ReactionLike,
+	}).Return(nil)
+
+	require.NoError(t, service.AddReaction(ctx, target.ID, comment.ReactionLike))
+
+	err := service.AddReaction(ctx, target.ID, "shrug")
+	require.ErrorIs(t, err, comment.ErrInvalidReaction)
+
+	err = service.AddReaction(t.Context(), target.ID, comment.ReactionLike)
+	require.ErrorIs(t, err, comment.ErrForbidden)
+
+	mockStore.AssertExpectations(t)
+}
+
+func TestEvents_PublishedAfterWrites(t *testing.T) {
+	mockStore := new(MockStore)
+	logger := slog.Default()
+	dispatcher := comment.NewDispatcher(logger)
+	service := comment.NewService(mockStore, logger, comment.WithPublisher(dispatcher))
+
+	var events []comment.Event
+	dispatcher.Subscribe(func(_ context.Context, e comment.Event) error {
+		events = append(events, e)
+		return nil
+	})
+	var deleted int
+	dispatcher.Subscribe(func(_ context.Context, _ comment.Event) error {
+		deleted++
+		return errors.New("subscriber errors do not fail the operation")
+	}, comment.CommentDeleted)
+
+	ctx := comment.WithActor(t.Context(), comment.Actor{ID: "test-author"})
+	existing := comment.Comment{ID: "test-id", Body: "old body", Author: "test-author", Version: 1}
+	update := comment.Comment{ID: "test-id", Body: "new body", Version: 1}
+
+	mockStore.On("CreateComment", ctx, mock.AnythingOfType("comment.Comment")).Return(existing, nil)
+	mockStore.On("GetComment", ctx, existing.ID).Return(existing, nil)
+	mockStore.On("UpdateComment", ctx, mock.AnythingOfType("comment.Comment")).Return(nil)
+	mockStore.On("DeleteComment", ctx, existing.ID, 2).Return(nil)
+
+	_, err := service.CreateComment(ctx, comment.Comment{Body: "old body"})
+	require.NoError(t, err)
+	require.NoError(t, service.UpdateComment(ctx, update))
+	require.NoError(t, service.DeleteComment(ctx, existing.ID, 2))
+
+	require.Len(t, events, 3)
+	assert.Equal(t, comment.CommentCreated, events[0].Type)
+	assert.Nil(t, events[0].Before)
+	assert.Equal(t, comment.CommentUpdated, events[1].Type)
+	assert.Equal(t, "old body", events[1].Before.Body)
+	assert.Equal(t, "new body", events[1].After.Body)
+	assert.Equal(t, 2, events[1].After.Version)
+	assert.Equal(t, comment.CommentDeleted, events[2].Type)
+	assert.Nil(t, events[2].After)
+	assert.Equal(t, 1, deleted)
+
+	for _, e := range events {
+		assert.NotEmpty(t, e.ID)
+		assert.Equal(t, e.CommentID, cmp.Or(e.After, e.Before).ID)
+		assert.Equal(t, "test-author", e.Actor)
+		assert.False(t, e.OccurredAt.IsZero())
+	}
+}
+
+type changeCounts map[comment.EventType]int
+
+func (c changeCounts) CountChange(t comment.EventType) {
+	c[t]++
+}
+
+func TestMetrics_CountChanges(t *testing.T) {
+	mockStore := new(MockStore)
+	counts := changeCounts{}
+	service := comment.NewService(mockStore, slog.Default(), comment.WithMetrics(counts))
+
+	ctx := comment.WithActor(t.Context(), comment.Actor{ID: "test-author"})
+	existing := comment.Comment{ID: "test-id", Body: "body", Author: "test-author", Version: 1}
+
+	mockStore.On("CreateComment", ctx, mock.AnythingOfType("comment.Comment")).Return(existing, nil)
+	mockStore.On("GetComment", ctx, existing.ID).Return(existing, nil)
+	mockStore.On("GetCommentIncludingDeleted", ctx, existing.ID).Return(existing, nil)
+	mockStore.On("UpdateComment", ctx, mock.AnythingOfType("comment.Comment")).Return(nil)
+	mockStore.On("DeleteComment", ctx, existing.ID, 1).Return(errors.New("db error")).Once()
+	mockStore.On("DeleteComment", ctx, existing.ID, 2).Return(nil)
+	mockStore.On("RestoreComment", ctx, existing.ID).Return(nil)
+
+	_, err := service.CreateComment(ctx, comment.Comment{Body: "body"})
+	require.NoError(t, err)
+	require.NoError(t, service.UpdateComment(ctx, existing))
+	require.Error(t, service.DeleteComment(ctx, existing.ID, 1))
+	require.NoError(t, service.DeleteComment(ctx, existing.ID, 2))
+	require.NoError(t, service.RestoreComment(ctx, existing.ID))
+
+	assert.Equal(t, changeCounts{
+		comment.CommentCreated: 1,
+		comment.CommentUpdated: 2,
+		comment.CommentDeleted: 1,
+	}, counts)
+}
+
+func TestAsyncDispatcher_DrainsOnClose(t *testing.T) {
+	dispatcher := comment.NewAsyncDispatcher(slog.Default(), 1)
+
+	var delivered []string
+	dispatcher.Subscribe(func(_ context.Context, e comment.Event) error {
+		delivered = append(delivered, e.ID)
+		return nil
+	})
+	dispatcher.Subscribe(func(_ context.Context, _ comment.Event) error {
+		panic("a panicking subscriber does not stop delivery")
+	})
+
+	for _, id := range []string{"a", "b", "c"} {
+		require.NoError(t, dispatcher.Publish(t.Context(), comment.Event{ID: id}))
+	}
+	require.NoError(t, dispatcher.Close(t.Context()))
+
+	assert.Equal(t, []string{"a", "b", "c"}, delivered)
+	require.ErrorIs(t, dispatcher.Publish(t.Context(), comment.Event{ID: "d"}), comment.ErrDispatcherClosed)
+}
+
+type MockEventLog struct {
+	mock.Mock
+}
+
+func (m *MockEventLog) EventsAfter(ctx context.Context, slug, id string, limit int) ([]comment.Event, error) {
+	args := m.Called(ctx, slug, id, limit)
+	return args.Get(0).([]comment.Event), args.Error(1)
+}
+
+func TestPublicChange(t *testing.T) {
+	approved := &comment.Comment{ID: "test-id", Slug: "test-slug", Status: comment.StatusApproved}
+	pending := &comment.Comment{ID: "test-id", Slug: "test-slug", Status: comment.StatusPending}
+	hidden := &comment.Comment{ID: "test-id", Slug: "test-slug", Status: comment.StatusHidden}
+
+	tests := []struct {
+		name   string
+		event  comment.Event
+		want   comment.ChangeKind
+		public bool
+	}{
+		{"created approved", comment.Event{Type: comment.CommentCreated, After: approved}, comment.ChangeCreated, true},
+		{"created pending", comment.Event{Type: comment.CommentCreated, After: pending}, "", false},
+		{
+			"approved by moderator",
+			comment.Event{Type: comment.CommentUpdated, Before: pending, After: approved},
+			comment.ChangeCreated,
+			true,
+		},
+		{
+			"edited",
+			comment.Event{Type: comment.CommentUpdated, Before: approved, After: approved},
+			comment.ChangeUpdated,
+			true,
+		},
+		{
+			"hidden by moderator",
+			comment.Event{Type: comment.CommentUpdated, Before: approved, After: hidden},
+			comment.ChangeDeleted,
+			true,
+		},
+		{"deleted", comment.Event{Type: comment.CommentDeleted, Before: approved}, comment.ChangeDeleted, true},
+		{"deleted pending", comment.Event{Type: comment.CommentDeleted, Before: pending}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, ok := comment.PublicChange(tt.event)
+			require.Equal(t, tt.public, ok)
+			assert.Equal(t, tt.want, c.Kind)
+			if ok {
+				assert.Equal(t, "test-slug", c.Slug)
+				assert.Equal(t, tt.want == comment.ChangeDeleted, c.Comment == nil)
+			}
+		})
+	}
+}
+
+func TestBroker(t *testing.T) {
+	eventLog := new(MockEventLog)
+	broker := comment.NewBroker(eventLog, 1)
+	ctx := t.Context()
+
+	created := func(id, slug string) comment.Event {
+		return comment.Event{
+			ID:        id,
+			Type:      comment.CommentCreated,
+			CommentID: id,
+			After:     &comment.Comment{ID: id, Slug: slug, Status: comment.StatusApproved},
+		}
+	}
+
+	lastEventID := "01890a5d-ac96-774b-bcce-b302099a8057"
+	eventLog.On("EventsAfter", ctx, "test-slug", lastEventID, 100).
+		Return([]comment.Event{created("missed", "test-slug")}, nil)
+
+	_, err := broker.Subscribe(ctx, "test-slug", "not-a-uuid")
+	require.ErrorIs(t, err, comment.ErrInvalidEventID)
+
+	stream, err := broker.Subscribe(ctx, "test-slug", lastEventID)
+	require.NoError(t, err)
+	require.Len(t, stream.Replay(), 1)
+	assert.Equal(t, "missed", stream.Replay()[0].EventID)
+
+	require.NoError(t, broker.Handle(ctx, created("other-slug", "other-slug")))
+	otherTenant := created("other-tenant", "test-slug")
+	otherTenant.TenantID = "other-tenant"
+	require.NoError(t, broker.Handle(ctx, otherTenant))
+	require.NoError(t, broker.Handle(ctx, created("live", "test-slug")))
+
+	c := <-stream.Changes()
+	assert.Equal(t, "live", c.EventID)
+	assert.Equal(t, comment.ChangeCreated, c.Kind)
+
+	// A stream that falls behind its buffer is dropped.
+	require.NoError(t, broker.Handle(ctx, created("first", "test-slug")))
+	require.NoError(t, broker.Handle(ctx, created("overflow", "test-slug")))
+	assert.Equal(t, "first", (<-stream.Changes()).EventID)
+	_, open := <-stream.Changes()
+	assert.False(t, open)
+	stream.Close()
+
+	eventLog.AssertExpectations(t)
+}
@@ -0,0 +1,77 @@
+package comment
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// cursor is the decoded form of the opaque pagination token handed to clients.
+// IDs are UUIDv7, so they sort by creation time and can be used directly as a
+// keyset position.
+type cursor struct {
+	ID string `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+
+	var c cursor
+	if err = json.Unmarshal(b, &c); err != nil {
+		return cursor{}, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+
+	id, err := uuid.FromString(c.ID)
+	if err != nil {
+		return cursor{}, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	if id.Version() != uuid.V7 {
+		return cursor{}, fmt.Errorf("%w: unexpected id version", ErrInvalidCursor)
+	}
+
+	return c, nil
+}
+
+// searchCursor points into a ranked result list. Ranks are not unique, so
+// search pages by position instead of by keyset.
+type searchCursor struct {
+	Offset int `json:"offset"`
+}
+
+func encodeSearchCursor(c searchCursor) string {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSearchCursor(s string) (searchCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return searchCursor{}, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+
+	var c searchCursor
+	if err = json.Unmarshal(b, &c); err != nil {
+		return searchCursor{}, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	if c.Offset < 1 {
+		return searchCursor{}, fmt.Errorf("%w: offset must be positive", ErrInvalidCursor)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,61 @@
+package comment
+
+import "strings"
+
+// maxDiffCells bounds the table of the longest common subsequence. Larger
+// diffs replace the whole body instead.
+const maxDiffCells = 1 << 20
+
+// diffLines returns a line based diff turning a into b. Removed lines are
+// prefixed with "-", added lines with "+" and unchanged lines with a space.
+func diffLines(fromLabel, toLabel, a, b string) string {
+	x := strings.Split(a, "\n")
+	y := strings.Split(b, "\n")
+
+	var sb strings.Builder
+	sb.WriteString("--- " + fromLabel + "\n")
+	sb.WriteString("+++ " + toLabel + "\n")
+
+	if (len(x)+1)*(len(y)+1) > maxDiffCells {
+		for _, line := range x {
+			sb.WriteString("-" + line + "\n")
+		}
+		for _, line := range y {
+			sb.WriteString("+" + line + "\n")
+		}
+		return sb.String()
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			sb.WriteString(" " + x[i] + "\n")
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			sb.WriteString("-" + x[i] + "\n")
+			i++
+		default:
+			sb.WriteString("+" + y[j] + "\n")
+			j++
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,159 @@
+package comment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+var ErrDispatcherClosed = errors.New("dispatcher closed")
+
+// Subscriber is called with every event it subscribed to.
+type Subscriber func(ctx context.Context, e Event) error
+
+type subscription struct {
+	types []EventType
+	fn    Subscriber
+}
+
+// Dispatcher is an in-process EventPublisher. A synchronous Dispatcher calls
+// the subscribers before Publish returns and reports their errors. An
+// asynchronous one queues the event and calls the subscribers from a
+// background goroutine, logging their errors.
+type Dispatcher struct {
+	logger *slog.Logger
+
+	mu            sync.RWMutex
+	subscriptions []subscription
+
+	// sendMu guards closed and keeps Close from closing the queue while a
+	// Publish is sending to it.
+	sendMu sync.RWMutex
+	closed bool
+	queue  chan queuedEvent
+	done   chan struct{}
+}
+
+type queuedEvent struct {
+	ctx   context.Context //nolint:containedctx // carries request values to the background delivery
+	event Event
+}
+
+// NewDispatcher returns a synchronous Dispatcher.
+func NewDispatcher(logger *slog.Logger) *Dispatcher {
+	return &Dispatcher{logger: logger}
+}
+
+// NewAsyncDispatcher returns an asynchronous Dispatcher that queues up to
+// buffer events. Publish blocks while the queue is full. Close must be called
+// to deliver the remaining events and stop the background goroutine.
+func NewAsyncDispatcher(logger *slog.Logger, buffer int) *Dispatcher {
+	d := &Dispatcher{
+		logger: logger,
+		queue:  make(chan queuedEvent, buffer),
+		done:   make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+// Subscribe registers fn for the given event types, or for every event if no
+// type is given.
+func (d *Dispatcher) Subscribe(fn Subscriber, types ...EventType) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.subscriptions = append(d.subscriptions, subscription{types: types, fn: fn})
+}
+
+func (d *Dispatcher) Publish(ctx context.Context, e Event) error {
+	if d.queue == nil {
+		return d.deliver(ctx, e)
+	}
+
+	// The request that caused the event may be over before it is delivered,
+	// so only its values are kept.
+	qe := queuedEvent{ctx: context.WithoutCancel(ctx), event: e}
+
+	d.sendMu.RLock()
+	defer d.sendMu.RUnlock()
+	if d.closed {
+		return ErrDispatcherClosed
+	}
+
+	select {
+	case d.queue <- qe:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to queue event: %w", ctx.Err())
+	}
+}
+
+// Close stops accepting events and waits until the queued ones have been
+// delivered or ctx is done. It is a no-op for a synchronous Dispatcher.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	if d.queue == nil {
+		return nil
+	}
+
+	d.sendMu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.sendMu.Unlock()
+
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to drain event queue: %w", ctx.Err())
+	}
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	for qe := range d.queue {
+		d.deliverAsync(qe)
+	}
+}
+
+func (d *Dispatcher) deliverAsync(qe queuedEvent) {
+	if err := d.deliver(qe.ctx, qe.event); err != nil {
+		d.logger.ErrorContext(
+			qe.ctx,
+			"failed to deliver event",
+			slog.String("type", string(qe.event.Type)),
+			slog.Any("error", err),
+		)
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, e Event) error {
+	d.mu.RLock()
+	subs := slices.Clone(d.subscriptions)
+	d.mu.RUnlock()
+
+	var errs []error
+	for _, sub := range subs {
+		if len(sub.types) > 0 && !slices.Contains(sub.types, e.Type) {
+			continue
+		}
+		if err := callSubscriber(ctx, sub.fn, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// callSubscriber shields the dispatcher from panicking subscribers.
+func callSubscriber(ctx context.Context, fn Subscriber, e Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("subscriber panicked: %v", r)
+		}
+	}()
+	return fn(ctx, e)
+}
@@ -0,0 +1,104 @@
+package comment
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// EventType names a change to a comment.
+type EventType string
+
+const (
+	CommentCreated EventType = "comment.created"
+	CommentUpdated EventType = "comment.updated"
+	CommentDeleted EventType = "comment.deleted"
+)
+
+// Event describes a change to a comment after it has been stored. Before is
+// nil for created comments and After is nil for deleted ones. Restores and
+// moderation decisions are reported as updates.
+type Event struct {
+	ID         string    `json:"id"`
+	TenantID   string    `json:"tenant_id,omitempty"`
+	Type       EventType `json:"type"`
+	CommentID  string    `json:"comment_id"`
+	Before     *Comment  `json:"before,omitempty"`
+	After      *Comment  `json:"after,omitempty"`
+	Actor      string    `json:"actor,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// EventPublisher hands events to interested parties. The change an event
+// describes has already been committed, so a failure to publish is logged but
+// does not fail the operation.
+type EventPublisher interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// WithPublisher sets where the Service sends its events. By default events
+// are dropped. Events published this way are lost if the process stops right
+// after a write; stores with an outbox, such as db.Database, record them in
+// the same transaction instead and need no publisher.
+func WithPublisher(p EventPublisher) Option {
+	return func(s *Service) {
+		s.publisher = p
+	}
+}
+
+type nopPublisher struct{}
+
+func (nopPublisher) Publish(context.Context, Event) error {
+	return nil
+}
+
+// NewEvent describes a change made by the actor and within the tenant in ctx.
+// Stores that record events themselves use it to build the same events the
+// Service publishes.
+func NewEvent(ctx context.Context, t EventType, before, after *Comment) (Event, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to generate event ID: %w", err)
+	}
+
+	e := Event{
+		ID:         id.String(),
+		Type:       t,
+		OccurredAt: time.Now().UTC(),
+		Before:     before,
+		After:      after,
+	}
+	if after != nil {
+		e.CommentID = after.ID
+	} else if before != nil {
+		e.CommentID = before.ID
+	}
+	if actor, ok := ActorFromContext(ctx); ok {
+		e.Actor = actor.ID
+	}
+	e.TenantID, _ = TenantFromContext(ctx)
+	return e, nil
+}
+
+func (s *Service) publish(ctx context.Context, t EventType, before, after *Comment) {
+	s.metrics.CountChange(t)
+
+	e, err := NewEvent(ctx, t, before, after)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to create event", slog.Any("error", err))
+		return
+	}
+
+	if err = s.publisher.Publish(ctx, e); err != nil {
+		s.logger.ErrorContext(
+			ctx,
+			"failed to publish event",
+			slog.String("type", string(t)),
+			slog.String("comment_id", e.CommentID),
+			slog.Any("error", err),
+		)
+	}
+}
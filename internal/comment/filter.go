@@ -0,0 +1,261 @@
+package comment
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrRejected = errors.New("comment rejected by filter")
+
+// linkPattern matches the links counted by LinkLimitFilter.
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// Action is the outcome of running a Filter on a comment.
+type Action string
+
+const (
+	ActionAllow   Action = "allow"
+	ActionFlag    Action = "flag"
+	ActionReject  Action = "reject"
+	ActionRewrite Action = "rewrite"
+)
+
+// Verdict is what a Filter decided about a comment. Body holds the new body
+// when Action is ActionRewrite.
+type Verdict struct {
+	Filter string `json:"filter"`
+	Action Action `json:"action"`
+	Reason string `json:"reason,omitempty"`
+	Body   string `json:"-"`
+}
+
+// Filter inspects a comment before it is written. Filters can let it pass,
+// flag it for moderation, reject it or rewrite its body.
+type Filter interface {
+	Name() string
+	Check(ctx context.Context, c Comment) (Verdict, error)
+}
+
+// Recorder is implemented by filters that remember the comments they let
+// through. The Service records a comment only once it is stored, so that a
+// write that is rejected or fails does not count.
+type Recorder interface {
+	Record(c Comment)
+}
+
+// FilterError is returned when a Filter rejects a comment.
+type FilterError struct {
+	Verdict Verdict
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", ErrRejected, e.Verdict.Filter, e.Verdict.Reason)
+}
+
+func (e *FilterError) Unwrap() error {
+	return ErrRejected
+}
+
+// WithFilters sets the filters that every created or updated comment passes
+// through, in order.
+func WithFilters(filters ...Filter) Option {
+	return func(s *Service) {
+		s.filters = filters
+	}
+}
+
+// runFilters passes c through the filter chain. It returns the possibly
+// rewritten comment and whether any filter flagged it for moderation. The
+// first rejection stops the chain.
+func (s *Service) runFilters(ctx context.Context, c Comment) (Comment, bool, error) {
+	var flagged bool
+	for _, f := range s.filters {
+		v, err := f.Check(ctx, c)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "failed to run filter", slog.String("filter", f.Name()), slog.Any("error", err))
+			return Comment{}, false, fmt.Errorf("failed to run filter %s: %w", f.Name(), err)
+		}
+		v.Filter = f.Name()
+
+		switch v.Action {
+		case ActionAllow:
+		case ActionFlag:
+			s.logger.InfoContext(
+				ctx,
+				"comment flagged",
+				slog.String("filter", v.Filter),
+				slog.String("reason", v.Reason),
+			)
+			flagged = true
+		case ActionReject:
+			return Comment{}, false, &FilterError{Verdict: v}
+		case ActionRewrite:
+			c.Body = v.Body
+		default:
+			return Comment{}, false, fmt.Errorf("filter %s returned unknown action %q", v.Filter, v.Action)
+		}
+	}
+	return c, flagged, nil
+}
+
+// recordFilters tells the filters that remember comments that c was stored.
+func (s *Service) recordFilters(c Comment) {
+	for _, f := range s.filters {
+		if r, ok := f.(Recorder); ok {
+			r.Record(c)
+		}
+	}
+}
+
+// WordListFilter matches a list of words, case insensitively and on word
+// boundaries. With ActionRewrite the words are masked, otherwise Action is
+// the verdict for a match.
+type WordListFilter struct {
+	pattern *regexp.Regexp
+	action  Action
+}
+
+func NewWordListFilter(words []string, action Action) *WordListFilter {
+	quoted := make([]string, 0, len(words))
+	for _, w := range words {
+		if w = strings.TrimSpace(w); w != "" {
+			quoted = append(quoted, regexp.QuoteMeta(w))
+		}
+	}
+
+	f := &WordListFilter{action: action}
+	if len(quoted) > 0 {
+		f.pattern = regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	}
+	return f
+}
+
+func (f *WordListFilter) Name() string {
+	return "word-list"
+}
+
+func (f *WordListFilter) Check(_ context.Context, c Comment) (Verdict, error) {
+	if f.pattern == nil || !f.pattern.MatchString(c.Body) {
+		return Verdict{Action: ActionAllow}, nil
+	}
+	if f.action == ActionRewrite {
+		body := f.pattern.ReplaceAllStringFunc(c.Body, func(w string) string {
+			return strings.Repeat("*", len([]rune(w)))
+		})
+		return Verdict{Action: ActionRewrite, Reason: "blocked words masked", Body: body}, nil
+	}
+	return Verdict{Action: f.action, Reason: "contains blocked words"}, nil
+}
+
+// LinkLimitFilter applies Action to comments with more than Max links.
+type LinkLimitFilter struct {
+	Max    int
+	Action Action
+}
+
+func (f LinkLimitFilter) Name() string {
+	return "link-limit"
+}
+
+func (f LinkLimitFilter) Check(_ context.Context, c Comment) (Verdict, error) {
+	if n := len(linkPattern.FindAllStringIndex(c.Body, -1)); n > f.Max {
+		return Verdict{Action: f.Action, Reason: fmt.Sprintf("contains %d links, at most %d allowed", n, f.Max)}, nil
+	}
+	return Verdict{Action: ActionAllow}, nil
+}
+
+// RegexFilter applies its action to comments whose body matches a pattern.
+// With ActionRewrite the matches are removed.
+type RegexFilter struct {
+	name    string
+	pattern *regexp.Regexp
+	action  Action
+	reason  string
+}
+
+func NewRegexFilter(name, pattern string, action Action, reason string) (*RegexFilter, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile filter %s: %w", name, err)
+	}
+	return &RegexFilter{name: name, pattern: re, action: action, reason: reason}, nil
+}
+
+func (f *RegexFilter) Name() string {
+	return f.name
+}
+
+func (f *RegexFilter) Check(_ context.Context, c Comment) (Verdict, error) {
+	if !f.pattern.MatchString(c.Body) {
+		return Verdict{Action: ActionAllow}, nil
+	}
+	if f.action == ActionRewrite {
+		return Verdict{Action: ActionRewrite, Reason: f.reason, Body: f.pattern.ReplaceAllString(c.Body, "")}, nil
+	}
+	return Verdict{Action: f.action, Reason: f.reason}, nil
+}
+
+// DuplicateFilter rejects a comment when the same author posted the same body
+// on the same slug of the same tenant within the window. Bodies are compared
+// after trimming and lower casing. Only stored comments are recorded, so a
+// retry after a failed write is not a duplicate. The filter keeps its state in
+// memory, so it only sees comments handled by this process.
+type DuplicateFilter struct {
+	window time.Duration
+
+	mu   sync.Mutex
+	seen map[string]seenBody
+}
+
+type seenBody struct {
+	commentID string
+	at        time.Time
+}
+
+func NewDuplicateFilter(window time.Duration) *DuplicateFilter {
+	return &DuplicateFilter{
+		window: window,
+		seen:   map[string]seenBody{},
+	}
+}
+
+func (f *DuplicateFilter) Name() string {
+	return "duplicate"
+}
+
+func (f *DuplicateFilter) Check(_ context.Context, c Comment) (Verdict, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	for k, sb := range f.seen {
+		if now.Sub(sb.at) > f.window {
+			delete(f.seen, k)
+		}
+	}
+
+	// An update that keeps the body is not a duplicate of itself.
+	if sb, ok := f.seen[duplicateKey(c)]; ok && sb.commentID != c.ID {
+		return Verdict{Action: ActionReject, Reason: "duplicate of a recent comment"}, nil
+	}
+	return Verdict{Action: ActionAllow}, nil
+}
+
+func (f *DuplicateFilter) Record(c Comment) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seen[duplicateKey(c)] = seenBody{commentID: c.ID, at: time.Now()}
+}
+
+func duplicateKey(c Comment) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(c.Body))))
+	return c.TenantID + "\x00" + c.Author + "\x00" + c.Slug + "\x00" + hex.EncodeToString(sum[:])
+}
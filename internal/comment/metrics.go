@@ -0,0 +1,20 @@
+package comment
+
+// Metrics counts the changes made through a Service. Changes are counted by
+// the type of event that describes them, so restores and moderation decisions
+// count as updates.
+type Metrics interface {
+	CountChange(t EventType)
+}
+
+// WithMetrics sets what the Service reports its changes to. By default they
+// are not counted.
+func WithMetrics(m Metrics) Option {
+	return func(s *Service) {
+		s.metrics = m
+	}
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) CountChange(EventType) {}
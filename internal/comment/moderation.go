@@ -0,0 +1,148 @@
+package comment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+var ErrInvalidTransition = errors.New("invalid moderation transition")
+
+// Status is the moderation state of a comment. Only approved comments are
+// shown to the public.
+type Status string
+
+const (
+	StatusPending  Status = "pending"
+	StatusApproved Status = "approved"
+	StatusRejected Status = "rejected"
+	StatusHidden   Status = "hidden"
+)
+
+// CanTransitionTo reports whether a moderator may move a comment from s to
+// next. Pending comments are either approved or rejected, and approved
+// comments may later be hidden.
+func (s Status) CanTransitionTo(next Status) bool {
+	switch s {
+	case StatusPending:
+		return next == StatusApproved || next == StatusRejected
+	case StatusApproved:
+		return next == StatusHidden
+	case StatusRejected, StatusHidden:
+		return false
+	default:
+		return false
+	}
+}
+
+// Moderation records a single moderation decision.
+type Moderation struct {
+	CommentID string
+	From      Status
+	To        Status
+	Reason    string
+	Moderator string
+	CreatedAt time.Time
+}
+
+// ModerationPolicy decides whether new comments on a slug are held for review
+// before they are published.
+type ModerationPolicy interface {
+	RequiresApproval(ctx context.Context, slug string) bool
+}
+
+// SlugPolicy is a ModerationPolicy configured up front. Slugs overrides
+// Default for individual slugs.
+type SlugPolicy struct {
+	Default bool
+	Slugs   map[string]bool
+}
+
+func (p SlugPolicy) RequiresApproval(_ context.Context, slug string) bool {
+	if v, ok := p.Slugs[slug]; ok {
+		return v
+	}
+	return p.Default
+}
+
+// initialStatus returns the status a new comment starts with. Comments
+// written by moderators never wait for review.
+func (s *Service) initialStatus(ctx context.Context, c Comment) Status {
+	if isModerator(ctx) {
+		return StatusApproved
+	}
+	if s.policy.RequiresApproval(ctx, c.Slug) {
+		return StatusPending
+	}
+	return StatusApproved
+}
+
+// ModerationQueue pages through the comments waiting for review, oldest
+// first. The slug of opts is optional and narrows the queue to one page.
+func (s *Service) ModerationQueue(ctx context.Context, opts ListOptions) (Page, error) {
+	if err := requireModerator(ctx); err != nil {
+		return Page{}, err
+	}
+
+	params, err := resolvePageOptions(opts)
+	if err != nil {
+		return Page{}, err
+	}
+	params.Status = StatusPending
+
+	return s.listPage(ctx, params)
+}
+
+// Moderate moves a comment to the given status and records the decision
+// together with the reason. Only moderators may moderate comments.
+func (s *Service) Moderate(ctx context.Context, id string, to Status, reason string) (Comment, error) {
+	if err := requireModerator(ctx); err != nil {
+		return Comment{}, err
+	}
+
+	existing, err := s.Store.GetComment(ctx, id)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to get comment", slog.Any("error", err))
+		return Comment{}, fmt.Errorf("failed to moderate comment: %w", err)
+	}
+	if !existing.Status.CanTransitionTo(to) {
+		return Comment{}, fmt.Errorf("%w: %s to %s", ErrInvalidTransition, existing.Status, to)
+	}
+
+	actor, _ := ActorFromContext(ctx)
+	err = s.Store.ModerateComment(ctx, Moderation{
+		CommentID: id,
+		From:      existing.Status,
+		To:        to,
+		Reason:    reason,
+		Moderator: actor.ID,
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to moderate comment", slog.Any("error", err))
+		return Comment{}, fmt.Errorf("failed to moderate comment: %w", err)
+	}
+
+	moderated, err := s.Store.GetComment(ctx, id)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to get comment", slog.Any("error", err))
+		return Comment{}, fmt.Errorf("failed to get comment: %w", err)
+	}
+
+	s.publish(ctx, CommentUpdated, &existing, &moderated)
+
+	return moderated, nil
+}
+
+func requireModerator(ctx context.Context) error {
+	if isModerator(ctx) {
+		return nil
+	}
+	return ErrForbidden
+}
+
+func isModerator(ctx context.Context) bool {
+	actor, ok := ActorFromContext(ctx)
+	return ok && actor.Moderator
+}
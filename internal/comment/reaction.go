@@ -0,0 +1,118 @@
+package comment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+var ErrInvalidReaction = errors.New("invalid reaction")
+
+// ReactionKind names one of the reactions offered by the UI.
+type ReactionKind string
+
+const (
+	ReactionLike      ReactionKind = "like"
+	ReactionLove      ReactionKind = "love"
+	ReactionLaugh     ReactionKind = "laugh"
+	ReactionSurprised ReactionKind = "surprised"
+	ReactionSad       ReactionKind = "sad"
+	ReactionAngry     ReactionKind = "angry"
+)
+
+func (k ReactionKind) Valid() bool {
+	switch k {
+	case ReactionLike, ReactionLove, ReactionLaugh, ReactionSurprised, ReactionSad, ReactionAngry:
+		return true
+	default:
+		return false
+	}
+}
+
+// Reaction is a single user's reaction to a comment.
+type Reaction struct {
+	CommentID string
+	UserID    string
+	Kind      ReactionKind
+}
+
+// ReactionSummary aggregates the reactions of one kind on a comment.
+// ReactedByMe tells whether the Actor of the request is among them.
+type ReactionSummary struct {
+	Kind        ReactionKind `json:"kind"`
+	Count       int          `json:"count"`
+	ReactedByMe bool         `json:"reacted_by_me"`
+}
+
+// AddReaction records the Actor's reaction to a comment. Adding the same
+// reaction twice has no further effect.
+func (s *Service) AddReaction(ctx context.Context, id string, kind ReactionKind) error {
+	r, err := s.reaction(ctx, id, kind)
+	if err != nil {
+		return err
+	}
+
+	if err = s.Store.AddReaction(ctx, r); err != nil {
+		s.logger.ErrorContext(ctx, "failed to add reaction", slog.Any("error", err))
+		return fmt.Errorf("failed to add reaction: %w", err)
+	}
+	return nil
+}
+
+// RemoveReaction withdraws the Actor's reaction to a comment. Removing a
+// reaction that does not exist is not an error.
+func (s *Service) RemoveReaction(ctx context.Context, id string, kind ReactionKind) error {
+	r, err := s.reaction(ctx, id, kind)
+	if err != nil {
+		return err
+	}
+
+	if err = s.Store.RemoveReaction(ctx, r); err != nil {
+		s.logger.ErrorContext(ctx, "failed to remove reaction", slog.Any("error", err))
+		return fmt.Errorf("failed to remove reaction: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) reaction(ctx context.Context, id string, kind ReactionKind) (Reaction, error) {
+	if !kind.Valid() {
+		return Reaction{}, fmt.Errorf("%w: unknown kind %q", ErrInvalidReaction, kind)
+	}
+
+	actor, ok := ActorFromContext(ctx)
+	if !ok || actor.ID == "" {
+		return Reaction{}, ErrForbidden
+	}
+
+	if _, err := s.getComment(ctx, id); err != nil {
+		return Reaction{}, err
+	}
+
+	return Reaction{CommentID: id, UserID: actor.ID, Kind: kind}, nil
+}
+
+// attachReactions fills in the reaction summaries of comments with a single
+// store query.
+func (s *Service) attachReactions(ctx context.Context, comments []Comment) error {
+	if len(comments) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(comments))
+	for _, c := range comments {
+		ids = append(ids, c.ID)
+	}
+
+	actor, _ := ActorFromContext(ctx)
+	summaries, err := s.Store.SummarizeReactions(ctx, ids, actor.ID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to summarize reactions", slog.Any("error", err))
+		return fmt.Errorf("failed to summarize reactions: %w", err)
+	}
+
+	for i := range comments {
+		comments[i].Reactions = summaries[comments[i].ID]
+	}
+	return nil
+}
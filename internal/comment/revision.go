@@ -0,0 +1,70 @@
+package comment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// Revision is a snapshot of a comment as it was before an edit. EditedBy and
+// EditedAt describe the edit that replaced it, and Diff shows what that edit
+// changed in the body.
+type Revision struct {
+	CommentID string    `json:"comment_id"`
+	Number    int       `json:"revision"`
+	Slug      string    `json:"slug"`
+	Body      string    `json:"body"`
+	Author    string    `json:"author"`
+	EditedBy  string    `json:"edited_by,omitempty"`
+	EditedAt  time.Time `json:"edited_at"`
+	Diff      string    `json:"diff"`
+}
+
+func (s *Service) ListRevisions(ctx context.Context, commentID string) ([]Revision, error) {
+	current, err := s.getComment(ctx, commentID)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := s.Store.ListRevisions(ctx, commentID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to list revisions", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to list revisions: %w", err)
+	}
+
+	for i := range revisions {
+		nextLabel, nextBody := "current", current.Body
+		if i+1 < len(revisions) {
+			nextLabel = "revision " + strconv.Itoa(revisions[i+1].Number)
+			nextBody = revisions[i+1].Body
+		}
+		revisions[i].Diff = diffLines(
+			"revision "+strconv.Itoa(revisions[i].Number),
+			nextLabel,
+			revisions[i].Body,
+			nextBody,
+		)
+	}
+
+	return revisions, nil
+}
+
+func (s *Service) GetRevision(ctx context.Context, commentID string, number int) (Revision, error) {
+	revisions, err := s.ListRevisions(ctx, commentID)
+	if err != nil {
+		return Revision{}, err
+	}
+
+	for _, r := range revisions {
+		if r.Number == number {
+			return r, nil
+		}
+	}
+
+	return Revision{}, ErrRevisionNotFound
+}
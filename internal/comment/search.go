@@ -0,0 +1,113 @@
+package comment
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// MaxSearchQueryLength bounds the length of a search query in bytes.
+const MaxSearchQueryLength = 256
+
+// SearchOptions is the client-facing description of a page of search
+// results. Query uses the web search syntax understood by Postgres, e.g.
+// `"exact phrase" -excluded or alternative`.
+type SearchOptions struct {
+	Query  string
+	Slug   string
+	Author string
+	Cursor string
+	Limit  int
+}
+
+// SearchParams is the resolved search handed to the Store. An empty Slug,
+// Author or Status matches every comment.
+type SearchParams struct {
+	Query  string
+	Slug   string
+	Author string
+	Status Status
+	Offset int
+	Limit  int
+}
+
+// SearchResult is a matching comment together with its rank and a snippet of
+// the body in which the matched terms are wrapped in <mark> tags. The rest of
+// the snippet is HTML escaped, so it can be rendered as HTML.
+type SearchResult struct {
+	Comment
+
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+type SearchPage struct {
+	Results    []SearchResult `json:"comments"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// Search returns the approved comments matching opts, best match first.
+func (s *Service) Search(ctx context.Context, opts SearchOptions) (SearchPage, error) {
+	params, err := resolveSearchOptions(opts)
+	if err != nil {
+		return SearchPage{}, err
+	}
+
+	limit := params.Limit
+	params.Limit++
+
+	results, err := s.Store.Search(ctx, params)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to search comments", slog.Any("error", err))
+		return SearchPage{}, fmt.Errorf("failed to search comments: %w", err)
+	}
+
+	page := SearchPage{Results: results}
+	if page.Results == nil {
+		page.Results = []SearchResult{}
+	}
+	if len(results) > limit {
+		page.Results = results[:limit]
+		page.NextCursor = encodeSearchCursor(searchCursor{Offset: params.Offset + limit})
+	}
+
+	return page, nil
+}
+
+func resolveSearchOptions(opts SearchOptions) (SearchParams, error) {
+	query := strings.TrimSpace(opts.Query)
+	if query == "" {
+		return SearchParams{}, fmt.Errorf("%w: q is required", ErrInvalidListOptions)
+	}
+	if len(query) > MaxSearchQueryLength {
+		return SearchParams{}, fmt.Errorf(
+			"%w: q must be at most %d bytes", ErrInvalidListOptions, MaxSearchQueryLength,
+		)
+	}
+
+	params := SearchParams{
+		Query:  query,
+		Slug:   opts.Slug,
+		Author: opts.Author,
+		Status: StatusApproved,
+		Limit:  opts.Limit,
+	}
+
+	if params.Limit == 0 {
+		params.Limit = DefaultPageSize
+	}
+	if params.Limit < 1 || params.Limit > MaxPageSize {
+		return SearchParams{}, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidListOptions, MaxPageSize)
+	}
+
+	if opts.Cursor != "" {
+		c, err := decodeSearchCursor(opts.Cursor)
+		if err != nil {
+			return SearchParams{}, err
+		}
+		params.Offset = c.Offset
+	}
+
+	return params, nil
+}
@@ -0,0 +1,193 @@
+package comment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+var ErrInvalidEventID = errors.New("invalid event ID")
+
+// replayPageSize is the number of past events read from the EventLog at once.
+const replayPageSize = 100
+
+// ChangeKind is how a change to a comment looks to the public.
+type ChangeKind string
+
+const (
+	ChangeCreated ChangeKind = "created"
+	ChangeUpdated ChangeKind = "updated"
+	ChangeDeleted ChangeKind = "deleted"
+)
+
+// Change is an Event as seen by anonymous readers of its tenant. A comment
+// that becomes visible, for instance by being approved, is created, and one
+// that leaves public view is deleted. Comment is nil for deleted comments, so
+// nothing that is not public is ever included.
+type Change struct {
+	EventID   string
+	TenantID  string
+	Kind      ChangeKind
+	Slug      string
+	CommentID string
+	Comment   *Comment
+}
+
+// PublicChange maps e onto the change anonymous readers see. It reports false
+// if the event is about a comment they could not see before or after it.
+func PublicChange(e Event) (Change, bool) {
+	wasVisible, isVisible := isPublic(e.Before), isPublic(e.After)
+
+	c := Change{EventID: e.ID, TenantID: e.TenantID, CommentID: e.CommentID}
+	switch {
+	case isVisible && wasVisible:
+		c.Kind = ChangeUpdated
+	case isVisible:
+		c.Kind = ChangeCreated
+	case wasVisible:
+		c.Kind = ChangeDeleted
+		c.Slug = e.Before.Slug
+		return c, true
+	default:
+		return Change{}, false
+	}
+	c.Slug = e.After.Slug
+	c.Comment = e.After
+	return c, true
+}
+
+func isPublic(c *Comment) bool {
+	return c != nil && c.Status == StatusApproved && c.DeletedAt == nil
+}
+
+// EventLog gives access to past events so that stream clients can catch up
+// after reconnecting.
+type EventLog interface {
+	// EventsAfter returns up to limit events about comments on slug, in the
+	// tenant in ctx, that follow the event with the given ID, oldest first.
+	// Event IDs are assigned before the write commits, so an event committed
+	// after the given one but with a smaller ID is not returned.
+	EventsAfter(ctx context.Context, slug, id string, limit int) ([]Event, error)
+}
+
+// Broker fans public changes out to the streams of the tenants and slugs they
+// happen on. Its Handle method is a Subscriber. A stream that falls more than
+// its buffer behind is dropped; its client reconnects and catches up from the
+// EventLog.
+type Broker struct {
+	log    EventLog
+	buffer int
+
+	mu      sync.Mutex
+	streams map[*Stream]struct{}
+}
+
+func NewBroker(log EventLog, buffer int) *Broker {
+	return &Broker{
+		log:     log,
+		buffer:  buffer,
+		streams: map[*Stream]struct{}{},
+	}
+}
+
+// Stream delivers the changes on one slug of one tenant. Replay holds the
+// changes missed since the client's last event, which have to be sent before
+// those from Changes. Changes may repeat events from Replay, which are
+// recognised by their IDs: UUIDv7 IDs sort in the order the events happened.
+type Stream struct {
+	broker  *Broker
+	tenant  string
+	slug    string
+	replay  []Change
+	changes chan Change
+}
+
+func (s *Stream) Replay() []Change {
+	return s.replay
+}
+
+// Changes is closed when the stream is dropped or closed.
+func (s *Stream) Changes() <-chan Change {
+	return s.changes
+}
+
+func (s *Stream) Close() {
+	s.broker.remove(s)
+}
+
+// Subscribe opens a stream of the changes on slug in the tenant in ctx. If
+// lastEventID is set, the changes after it are replayed first.
+func (b *Broker) Subscribe(ctx context.Context, slug, lastEventID string) (*Stream, error) {
+	if slug == "" {
+		return nil, fmt.Errorf("%w: slug is required", ErrInvalidListOptions)
+	}
+	if lastEventID != "" {
+		if _, err := uuid.FromString(lastEventID); err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidEventID, lastEventID)
+		}
+	}
+
+	// The stream listens before the log is read, so that no change falls
+	// between the two.
+	tenant, _ := TenantFromContext(ctx)
+	s := &Stream{broker: b, tenant: tenant, slug: slug, changes: make(chan Change, b.buffer)}
+	b.mu.Lock()
+	b.streams[s] = struct{}{}
+	b.mu.Unlock()
+
+	for after := lastEventID; after != ""; {
+		events, err := b.log.EventsAfter(ctx, slug, after, replayPageSize)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to replay events: %w", err)
+		}
+		for _, e := range events {
+			if c, ok := PublicChange(e); ok {
+				s.replay = append(s.replay, c)
+			}
+		}
+
+		after = ""
+		if len(events) == replayPageSize {
+			after = events[len(events)-1].ID
+		}
+	}
+
+	return s, nil
+}
+
+// Handle passes the public change described by e to the streams of its
+// tenant and slug.
+func (b *Broker) Handle(_ context.Context, e Event) error {
+	c, ok := PublicChange(e)
+	if !ok {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.streams {
+		if s.tenant != c.TenantID || s.slug != c.Slug {
+			continue
+		}
+		select {
+		case s.changes <- c:
+		default:
+			delete(b.streams, s)
+			close(s.changes)
+		}
+	}
+	return nil
+}
+
+func (b *Broker) remove(s *Stream) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.streams[s]; ok {
+		delete(b.streams, s)
+		close(s.changes)
+	}
+}
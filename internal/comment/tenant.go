@@ -0,0 +1,27 @@
+package comment
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrTenantRequired is returned by stores when an operation is not scoped to a
+// tenant.
+var ErrTenantRequired = errors.New("tenant required")
+
+// DefaultTenant owns the comments written before tenants were introduced, and
+// every comment of a single-site deployment.
+const DefaultTenant = "default"
+
+type tenantKey struct{}
+
+// WithTenant scopes the operations run with ctx to the given tenant. Stores
+// only ever read and write the comments of that tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok && tenant != ""
+}
@@ -0,0 +1,147 @@
+package comment
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+const (
+	DefaultThreadDepth = 3
+	MaxThreadDepth     = 10
+)
+
+// Thread is a comment together with its nested replies.
+type Thread struct {
+	Comment
+
+	Replies []Thread `json:"replies,omitempty"`
+}
+
+type ThreadPage struct {
+	Threads    []Thread `json:"comments"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+// ListThreads pages through the top-level comments of a slug and attaches up
+// to maxDepth levels of replies to each of them.
+func (s *Service) ListThreads(ctx context.Context, opts ListOptions, maxDepth int) (ThreadPage, error) {
+	maxDepth, err := resolveThreadDepth(maxDepth)
+	if err != nil {
+		return ThreadPage{}, err
+	}
+
+	params, err := resolveListOptions(opts)
+	if err != nil {
+		return ThreadPage{}, err
+	}
+	params.RootsOnly = true
+	params.Status = StatusApproved
+
+	limit := params.Limit
+	params.Limit++
+
+	roots, err := s.Store.ListComments(ctx, params)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to list comments", slog.Any("error", err))
+		return ThreadPage{}, fmt.Errorf("failed to list comments: %w", err)
+	}
+
+	var page ThreadPage
+	if len(roots) > limit {
+		roots = roots[:limit]
+		page.NextCursor = encodeCursor(cursor{ID: roots[limit-1].ID})
+	}
+
+	if err = s.attachReactions(ctx, roots); err != nil {
+		return ThreadPage{}, err
+	}
+
+	replies, err := s.listReplies(ctx, roots, maxDepth, opts.IncludeDeleted)
+	if err != nil {
+		return ThreadPage{}, err
+	}
+
+	page.Threads = buildThreads(roots, replies)
+
+	return page, nil
+}
+
+// GetReplies returns up to maxDepth levels of replies below the given comment.
+func (s *Service) GetReplies(ctx context.Context, id string, maxDepth int) ([]Thread, error) {
+	maxDepth, err := resolveThreadDepth(maxDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, err := s.getComment(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	replies, err := s.listReplies(ctx, []Comment{parent}, maxDepth, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildThreads(replies[parent.ID], replies), nil
+}
+
+func (s *Service) listReplies(
+	ctx context.Context,
+	parents []Comment,
+	maxDepth int,
+	includeDeleted bool,
+) (map[string][]Comment, error) {
+	if len(parents) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, 0, len(parents))
+	for _, p := range parents {
+		ids = append(ids, p.ID)
+	}
+
+	comments, err := s.Store.ListReplies(ctx, ReplyParams{
+		ParentIDs:      ids,
+		MaxDepth:       maxDepth,
+		Status:         StatusApproved,
+		IncludeDeleted: includeDeleted,
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to list replies", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to list replies: %w", err)
+	}
+
+	if err = s.attachReactions(ctx, comments); err != nil {
+		return nil, err
+	}
+
+	children := make(map[string][]Comment, len(comments))
+	for _, c := range comments {
+		children[c.ParentID] = append(children[c.ParentID], c)
+	}
+
+	return children, nil
+}
+
+func buildThreads(comments []Comment, children map[string][]Comment) []Thread {
+	threads := make([]Thread, 0, len(comments))
+	for _, c := range comments {
+		threads = append(threads, Thread{
+			Comment: c,
+			Replies: buildThreads(children[c.ID], children),
+		})
+	}
+	return threads
+}
+
+func resolveThreadDepth(depth int) (int, error) {
+	if depth == 0 {
+		return DefaultThreadDepth, nil
+	}
+	if depth < 1 || depth > MaxThreadDepth {
+		return 0, fmt.Errorf("%w: max_depth must be between 1 and %d", ErrInvalidListOptions, MaxThreadDepth)
+	}
+	return depth, nil
+}
@@ -0,0 +1,245 @@
+// Package config loads the server configuration. Every value has a built-in
+// default that can be overridden, in order of increasing precedence, by a YAML
+// or JSON file, by environment variables and by command-line flags.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+)
+
+var ErrInvalid = errors.New("invalid configuration")
+
+const (
+	// minSigningKeyLength is the shortest JWT signing key accepted outside
+	// dev mode. HS256 keys should have at least as many bits as the hash.
+	minSigningKeyLength = 32
+	// DevSigningKey signs tokens in dev mode when no key is configured. It is
+	// never accepted otherwise.
+	DevSigningKey = "default_secret"
+)
+
+type Config struct {
+	// Dev relaxes the checks that keep insecure settings out of production,
+	// such as the requirement for a strong JWT signing key.
+	Dev        bool       `yaml:"dev"`
+	HTTP       HTTP       `yaml:"http"`
+	Auth       Auth       `yaml:"auth"`
+	Database   Database   `yaml:"database"`
+	Tenants    Tenants    `yaml:"tenants"`
+	Moderation Moderation `yaml:"moderation"`
+	Filters    Filters    `yaml:"filters"`
+}
+
+type HTTP struct {
+	Addr string `yaml:"addr"`
+	// RequestTimeout bounds the handling of a single request. Long-lived
+	// streams are exempt.
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	// ServerTimeout bounds reading and writing a request and idle keep-alive
+	// connections.
+	ServerTimeout time.Duration `yaml:"server_timeout"`
+	// ShutdownTimeout is how long a shutdown waits for open requests.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+type Auth struct {
+	JWTSigningKey string `yaml:"jwt_signing_key"`
+}
+
+type Database struct {
+	// URL is a complete connection string. When it is set, the other fields
+	// are ignored.
+	URL      string `yaml:"url"`
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// Name defaults to Username when it is empty.
+	Name    string `yaml:"name"`
+	SSLMode string `yaml:"ssl_mode"`
+	// MigrationsDir loads the migrations from a directory instead of the ones
+	// embedded in the binary, to try new migrations without rebuilding.
+	MigrationsDir string `yaml:"migrations_dir"`
+}
+
+// ConnectionString returns URL, or a key/value connection string built from
+// the other fields.
+func (d Database) ConnectionString() string {
+	if d.URL != "" {
+		return d.URL
+	}
+	return fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		quote(d.Host),
+		d.Port,
+		quote(d.Username),
+		quote(d.Password),
+		quote(d.Name),
+		quote(d.SSLMode),
+	)
+}
+
+// quote makes v safe to use as a value in a key/value connection string.
+func quote(v string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
+// Tenants maps the hosts of the sites that embed the comments onto their
+// tenants. Requests for other hosts belong to Default; when it is empty, they
+// need a token that names their tenant.
+type Tenants struct {
+	Hosts   map[string]string `yaml:"hosts"`
+	Default string            `yaml:"default"`
+}
+
+type Moderation struct {
+	// Default is the status of new comments, approved or pending.
+	Default comment.Status `yaml:"default"`
+	// PendingSlugs lists slugs whose comments are always held for review.
+	PendingSlugs []string `yaml:"pending_slugs"`
+}
+
+type Filters struct {
+	// BlockedWords are handled according to BlockedWordsAction.
+	BlockedWords       []string       `yaml:"blocked_words"`
+	BlockedWordsAction comment.Action `yaml:"blocked_words_action"`
+	// Patterns are checked in order after the blocked words. They can only be
+	// set in the configuration file.
+	Patterns []Pattern `yaml:"patterns"`
+	// MaxLinks flags comments with more links. Zero disables the check.
+	MaxLinks int `yaml:"max_links"`
+	// DuplicateWindow rejects reposts of the same body within the window.
+	// Zero disables the check.
+	DuplicateWindow time.Duration `yaml:"duplicate_window"`
+}
+
+// Pattern is a content filter that applies Action to comments whose body
+// matches the regular expression Pattern. With the rewrite action the matches
+// are removed. Reason is recorded with the verdict.
+type Pattern struct {
+	Name    string         `yaml:"name"`
+	Pattern string         `yaml:"pattern"`
+	Action  comment.Action `yaml:"action"`
+	Reason  string         `yaml:"reason"`
+}
+
+// Defaults returns the configuration used for everything that is not set
+// explicitly.
+func Defaults() Config {
+	return Config{
+		HTTP: HTTP{
+			Addr:            "0.0.0.0:8080",
+			RequestTimeout:  10 * time.Second,
+			ServerTimeout:   15 * time.Second,
+			ShutdownTimeout: 15 * time.Second,
+		},
+		Database: Database{
+			Host:    "localhost",
+			Port:    5432,
+			SSLMode: "require",
+		},
+		Tenants: Tenants{
+			Hosts:   map[string]string{},
+			Default: comment.DefaultTenant,
+		},
+		Moderation: Moderation{
+			Default: comment.StatusApproved,
+		},
+		Filters: Filters{
+			BlockedWordsAction: comment.ActionReject,
+		},
+	}
+}
+
+// RequireSigningKey reports a missing JWT signing key. Validate lets it be
+// empty, since only the commands that sign or verify tokens need it.
+func (c Config) RequireSigningKey() error {
+	if c.Auth.JWTSigningKey == "" {
+		return fmt.Errorf("%w: auth.jwt_signing_key is required outside dev mode", ErrInvalid)
+	}
+	return nil
+}
+
+// Validate reports every setting that is missing, malformed or, outside dev
+// mode, insecure.
+func (c Config) Validate() error {
+	var errs []error
+	if c.HTTP.Addr == "" {
+		errs = append(errs, errors.New("http.addr is required"))
+	}
+	timeouts := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"request_timeout", c.HTTP.RequestTimeout},
+		{"server_timeout", c.HTTP.ServerTimeout},
+		{"shutdown_timeout", c.HTTP.ShutdownTimeout},
+	}
+	for _, t := range timeouts {
+		if t.d <= 0 {
+			errs = append(errs, fmt.Errorf("http.%s must be positive", t.name))
+		}
+	}
+
+	if c.Database.URL == "" && c.Database.Host == "" {
+		errs = append(errs, errors.New("database.url or database.host is required"))
+	}
+
+	switch {
+	case c.Dev, c.Auth.JWTSigningKey == "":
+	case c.Auth.JWTSigningKey == DevSigningKey || len(c.Auth.JWTSigningKey) < minSigningKeyLength:
+		errs = append(errs, fmt.Errorf(
+			"auth.jwt_signing_key must be at least %d bytes long outside dev mode", minSigningKeyLength,
+		))
+	}
+
+	for host, tenant := range c.Tenants.Hosts {
+		if host == "" || tenant == "" {
+			errs = append(errs, fmt.Errorf("tenants.hosts has an incomplete entry %q=%q", host, tenant))
+		}
+	}
+
+	if !slices.Contains([]comment.Status{comment.StatusApproved, comment.StatusPending}, c.Moderation.Default) {
+		errs = append(errs, fmt.Errorf("moderation.default must be approved or pending, not %q", c.Moderation.Default))
+	}
+
+	actions := []comment.Action{comment.ActionReject, comment.ActionFlag, comment.ActionRewrite}
+	if !slices.Contains(actions, c.Filters.BlockedWordsAction) {
+		errs = append(errs, fmt.Errorf(
+			"filters.blocked_words_action must be reject, flag or rewrite, not %q", c.Filters.BlockedWordsAction,
+		))
+	}
+	for i, p := range c.Filters.Patterns {
+		if p.Name == "" {
+			errs = append(errs, fmt.Errorf("filters.patterns[%d].name is required", i))
+		}
+		if p.Pattern == "" {
+			errs = append(errs, fmt.Errorf("filters.patterns[%d].pattern is required", i))
+		} else if _, err := regexp.Compile(p.Pattern); err != nil {
+			errs = append(errs, fmt.Errorf("filters.patterns[%d].pattern is invalid: %w", i, err))
+		}
+		if !slices.Contains(actions, p.Action) {
+			errs = append(errs, fmt.Errorf(
+				"filters.patterns[%d].action must be reject, flag or rewrite, not %q", i, p.Action,
+			))
+		}
+	}
+	if c.Filters.MaxLinks < 0 {
+		errs = append(errs, errors.New("filters.max_links must not be negative"))
+	}
+	if c.Filters.DuplicateWindow < 0 {
+		errs = append(errs, errors.New("filters.duplicate_window must not be negative"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrInvalid, errors.Join(errs...))
+	}
+	return nil
+}
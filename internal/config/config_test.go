@@ -0,0 +1,173 @@
+//go:build unit
+
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/azdanov/go-rest-api/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const strongKey = "0123456789abcdef0123456789abcdef"
+
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+http:
+  addr: file:8080
+  request_timeout: 3s
+database:
+  host: file-db
+  name: comments
+  port: 6543
+auth:
+  jwt_signing_key: `+strongKey+`
+`)
+	t.Setenv("CONFIG_FILE", file)
+	t.Setenv("HTTP_ADDR", "env:8080")
+	t.Setenv("DB_HOST", "env-db")
+
+	cfg, err := config.Load([]string{"-db-host", "flag-db"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "env:8080", cfg.HTTP.Addr)
+	assert.Equal(t, 3*time.Second, cfg.HTTP.RequestTimeout)
+	assert.Equal(t, 15*time.Second, cfg.HTTP.ServerTimeout)
+	assert.Equal(t, "flag-db", cfg.Database.Host)
+	assert.Equal(t, 6543, cfg.Database.Port)
+	assert.Equal(t, comment.StatusApproved, cfg.Moderation.Default)
+}
+
+func TestLoadJSONFile(t *testing.T) {
+	file := writeFile(t, "config.json", `{
+		"database": {"url": "postgres://localhost/comments"},
+		"tenants": {"hosts": {"blog.example.com": "blog"}, "default": ""},
+		"filters": {
+			"max_links": 3,
+			"duplicate_window": "1m",
+			"patterns": [{"name": "no-shouting", "pattern": "[A-Z]{10,}", "action": "flag"}]
+		}
+	}`)
+	t.Setenv("JWT_SIGNING_KEY", strongKey)
+
+	cfg, err := config.Load([]string{"-config", file})
+	require.NoError(t, err)
+
+	assert.Equal(t, "postgres://localhost/comments", cfg.Database.ConnectionString())
+	assert.Equal(t, map[string]string{"blog.example.com": "blog"}, cfg.Tenants.Hosts)
+	assert.Empty(t, cfg.Tenants.Default)
+	assert.Equal(t, 3, cfg.Filters.MaxLinks)
+	assert.Equal(t, time.Minute, cfg.Filters.DuplicateWindow)
+	assert.Equal(t, []config.Pattern{
+		{Name: "no-shouting", Pattern: "[A-Z]{10,}", Action: comment.ActionFlag},
+	}, cfg.Filters.Patterns)
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	file := writeFile(t, "config.yaml", "http:\n  adr: localhost:8080\n")
+
+	_, err := config.Load([]string{"-config", file})
+	require.ErrorIs(t, err, config.ErrInvalid)
+}
+
+func TestLoadRejectsMalformedValues(t *testing.T) {
+	_, err := config.Load([]string{"-http-request-timeout", "soon"})
+	require.Error(t, err)
+
+	t.Setenv("DB_PORT", "five")
+	_, err = config.Load([]string{"-dev", "-db-name", "comments"})
+	require.ErrorIs(t, err, config.ErrInvalid)
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	t.Setenv("DB_NAME", "comments")
+
+	tests := []struct {
+		name    string
+		args    []string
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{name: "missing"},
+		{name: "too short", key: "secret", wantErr: true},
+		{name: "dev key outside dev mode", key: config.DevSigningKey, wantErr: true},
+		{name: "strong", key: strongKey, want: strongKey},
+		{name: "dev mode default", args: []string{"-dev"}, want: config.DevSigningKey},
+		{name: "dev mode weak key", args: []string{"-dev"}, key: "secret", want: "secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("JWT_SIGNING_KEY", tt.key)
+
+			cfg, err := config.Load(tt.args)
+			if tt.wantErr {
+				require.ErrorIs(t, err, config.ErrInvalid)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, cfg.Auth.JWTSigningKey)
+			if tt.want == "" {
+				require.ErrorIs(t, cfg.RequireSigningKey(), config.ErrInvalid)
+			} else {
+				require.NoError(t, cfg.RequireSigningKey())
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.HTTP.RequestTimeout = 0
+	cfg.Database.Host = ""
+	cfg.Auth.JWTSigningKey = "secret"
+	cfg.Moderation.Default = "hidden"
+	cfg.Filters.BlockedWordsAction = "ignore"
+	cfg.Filters.MaxLinks = -1
+	cfg.Filters.Patterns = []config.Pattern{{Pattern: "(", Action: "ignore"}}
+
+	err := cfg.Validate()
+	require.ErrorIs(t, err, config.ErrInvalid)
+	for _, want := range []string{
+		"http.request_timeout",
+		"database.url",
+		"auth.jwt_signing_key",
+		"moderation.default",
+		"filters.blocked_words_action",
+		"filters.max_links",
+		"filters.patterns[0].name",
+		"filters.patterns[0].pattern",
+		"filters.patterns[0].action",
+	} {
+		assert.Contains(t, err.Error(), want)
+	}
+}
+
+func TestConnectionString(t *testing.T) {
+	db := config.Database{
+		Host:     "localhost",
+		Port:     5432,
+		Username: "user",
+		Password: `it's a \secret`,
+		Name:     "comments",
+		SSLMode:  "disable",
+	}
+
+	assert.Equal(t,
+		`host='localhost' port=5432 user='user' password='it\'s a \\secret' dbname='comments' sslmode='disable'`,
+		db.ConnectionString(),
+	)
+}
@@ -0,0 +1,264 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileEnv names the configuration file when the -config flag is not given.
+const fileEnv = "CONFIG_FILE"
+
+// setting is a configuration value that can be set from the environment and,
+// if flag is not empty, from the command line. Secrets have no flag, since
+// command lines are visible to other users of the machine.
+type setting struct {
+	env    string
+	flag   string
+	usage  string
+	isBool bool
+	set    func(c *Config, v string) error
+}
+
+func settings() []setting {
+	return []setting{
+		boolSetting("DEV_MODE", "dev", "relax the checks that keep insecure settings out of production",
+			func(c *Config) *bool { return &c.Dev }),
+
+		stringSetting("HTTP_ADDR", "http-addr", "address to listen on",
+			func(c *Config) *string { return &c.HTTP.Addr }),
+		durationSetting("HTTP_REQUEST_TIMEOUT", "http-request-timeout", "time limit for handling a request",
+			func(c *Config) *time.Duration { return &c.HTTP.RequestTimeout }),
+		durationSetting("HTTP_SERVER_TIMEOUT", "http-server-timeout", "read, write and idle timeout of connections",
+			func(c *Config) *time.Duration { return &c.HTTP.ServerTimeout }),
+		durationSetting("HTTP_SHUTDOWN_TIMEOUT", "http-shutdown-timeout", "time allowed for a graceful shutdown",
+			func(c *Config) *time.Duration { return &c.HTTP.ShutdownTimeout }),
+
+		stringSetting("JWT_SIGNING_KEY", "", "",
+			func(c *Config) *string { return &c.Auth.JWTSigningKey }),
+
+		stringSetting("DATABASE_URL", "database-url", "database connection string, overrides the db-* settings",
+			func(c *Config) *string { return &c.Database.URL }),
+		stringSetting("DB_HOST", "db-host", "database host",
+			func(c *Config) *string { return &c.Database.Host }),
+		intSetting("DB_PORT", "db-port", "database port",
+			func(c *Config) *int { return &c.Database.Port }),
+		stringSetting("DB_USERNAME", "db-username", "database user",
+			func(c *Config) *string { return &c.Database.Username }),
+		stringSetting("DB_PASSWORD", "", "",
+			func(c *Config) *string { return &c.Database.Password }),
+		stringSetting("DB_NAME", "db-name", "database name",
+			func(c *Config) *string { return &c.Database.Name }),
+		stringSetting("DB_SSL_MODE", "db-ssl-mode", "database sslmode",
+			func(c *Config) *string { return &c.Database.SSLMode }),
+		stringSetting("MIGRATIONS_DIR", "migrations-dir", "load migrations from a directory instead of the binary",
+			func(c *Config) *string { return &c.Database.MigrationsDir }),
+
+		mapSetting("TENANT_HOSTS", "tenant-hosts", "comma separated host=tenant pairs",
+			func(c *Config) *map[string]string { return &c.Tenants.Hosts }),
+		stringSetting("TENANT_DEFAULT", "tenant-default", "tenant of unmapped hosts, empty to require a mapping",
+			func(c *Config) *string { return &c.Tenants.Default }),
+
+		stringSetting("MODERATION_DEFAULT", "moderation-default", "status of new comments, approved or pending",
+			func(c *Config) *string { return (*string)(&c.Moderation.Default) }),
+		listSetting("MODERATION_PENDING_SLUGS", "moderation-pending-slugs", "comma separated slugs held for review",
+			func(c *Config) *[]string { return &c.Moderation.PendingSlugs }),
+
+		listSetting("FILTER_BLOCKED_WORDS", "filter-blocked-words", "comma separated blocked words",
+			func(c *Config) *[]string { return &c.Filters.BlockedWords }),
+		stringSetting("FILTER_BLOCKED_WORDS_ACTION", "filter-blocked-words-action",
+			"what to do with blocked words: reject, flag or rewrite",
+			func(c *Config) *string { return (*string)(&c.Filters.BlockedWordsAction) }),
+		intSetting("FILTER_MAX_LINKS", "filter-max-links", "flag comments with more links, 0 to disable",
+			func(c *Config) *int { return &c.Filters.MaxLinks }),
+		durationSetting("FILTER_DUPLICATE_WINDOW", "filter-duplicate-window",
+			"reject reposts of the same body within this window, 0 to disable",
+			func(c *Config) *time.Duration { return &c.Filters.DuplicateWindow }),
+	}
+}
+
+func stringSetting(env, flag, usage string, field func(*Config) *string) setting {
+	return setting{env: env, flag: flag, usage: usage, set: func(c *Config, v string) error {
+		*field(c) = strings.TrimSpace(v)
+		return nil
+	}}
+}
+
+func boolSetting(env, flag, usage string, field func(*Config) *bool) setting {
+	return setting{env: env, flag: flag, usage: usage, isBool: true, set: func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}}
+}
+
+func intSetting(env, flag, usage string, field func(*Config) *int) setting {
+	return setting{env: env, flag: flag, usage: usage, set: func(c *Config, v string) error {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}}
+}
+
+func durationSetting(env, flag, usage string, field func(*Config) *time.Duration) setting {
+	return setting{env: env, flag: flag, usage: usage, set: func(c *Config, v string) error {
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil {
+			return err
+		}
+		*field(c) = d
+		return nil
+	}}
+}
+
+func listSetting(env, flag, usage string, field func(*Config) *[]string) setting {
+	return setting{env: env, flag: flag, usage: usage, set: func(c *Config, v string) error {
+		*field(c) = splitList(v)
+		return nil
+	}}
+}
+
+func mapSetting(env, flag, usage string, field func(*Config) *map[string]string) setting {
+	return setting{env: env, flag: flag, usage: usage, set: func(c *Config, v string) error {
+		m := map[string]string{}
+		for _, pair := range splitList(v) {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("%q is not a key=value pair", pair)
+			}
+			m[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+		*field(c) = m
+		return nil
+	}}
+}
+
+func splitList(v string) []string {
+	var items []string
+	for item := range strings.SplitSeq(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Loader builds a Config from the sources in order of precedence. Its flags
+// are registered on a FlagSet, so that they can be shared with other flags of
+// a command.
+type Loader struct {
+	file  string
+	flags []flagValue
+}
+
+type flagValue struct {
+	setting setting
+	value   string
+}
+
+// NewLoader registers -config and a flag for every setting that has one on fs.
+// Load must be called after fs has been parsed.
+func NewLoader(fs *flag.FlagSet) *Loader {
+	l := &Loader{}
+	fs.StringVar(&l.file, "config", "", "YAML or JSON configuration file (env "+fileEnv+")")
+	for _, s := range settings() {
+		if s.flag == "" {
+			continue
+		}
+		record := func(v string) error {
+			// Malformed values are reported while the flags are parsed.
+			if err := s.set(&Config{}, v); err != nil {
+				return err
+			}
+			l.flags = append(l.flags, flagValue{setting: s, value: v})
+			return nil
+		}
+		usage := s.usage + " (env " + s.env + ")"
+		if s.isBool {
+			fs.BoolFunc(s.flag, usage, record)
+		} else {
+			fs.Func(s.flag, usage, record)
+		}
+	}
+	return l
+}
+
+// Load applies the defaults, the configuration file, the environment and the
+// flags, in that order, and validates the result. In dev mode a missing JWT
+// signing key is replaced by DevSigningKey.
+func (l *Loader) Load() (Config, error) {
+	c := Defaults()
+
+	file := l.file
+	if file == "" {
+		file = os.Getenv(fileEnv)
+	}
+	if file != "" {
+		if err := loadFile(&c, file); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, s := range settings() {
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(&c, v); err != nil {
+				return Config{}, fmt.Errorf("%w: %s: %w", ErrInvalid, s.env, err)
+			}
+		}
+	}
+
+	for _, f := range l.flags {
+		if err := f.setting.set(&c, f.value); err != nil {
+			return Config{}, fmt.Errorf("%w: -%s: %w", ErrInvalid, f.setting.flag, err)
+		}
+	}
+
+	if c.Dev && c.Auth.JWTSigningKey == "" {
+		c.Auth.JWTSigningKey = DevSigningKey
+	}
+
+	if err := c.Validate(); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// Load parses args as flags and loads the configuration.
+func Load(args []string) (Config, error) {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	l := NewLoader(fs)
+	if err := fs.Parse(args); err != nil {
+		return Config{}, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	return l.Load()
+}
+
+// loadFile reads a YAML file, or a JSON one, since JSON is valid YAML, over
+// c. Unknown keys are rejected so that typos do not go unnoticed.
+func loadFile(c *Config, name string) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err = dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: %s: %w", ErrInvalid, name, err)
+	}
+	return nil
+}
@@ -4,97 +4,377 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
+const commentColumns = "id, tenant_id, parent_id, slug, body, author, created_at, updated_at, deleted_at, " +
+	"version, status"
+
 type CommentRow struct {
-	ID     string
-	Slug   sql.NullString
-	Body   sql.NullString
-	Author sql.NullString
+	ID        string
+	TenantID  string         `db:"tenant_id"`
+	ParentID  sql.NullString `db:"parent_id"`
+	Slug      sql.NullString
+	Body      sql.NullString
+	Author    sql.NullString
+	CreatedAt time.Time    `db:"created_at"`
+	UpdatedAt time.Time    `db:"updated_at"`
+	DeletedAt sql.NullTime `db:"deleted_at"`
+	Version   int
+	Status    string
 }
 
 func convertRowToComment(cr CommentRow) comment.Comment {
-	return comment.Comment{
-		ID:     cr.ID,
-		Slug:   cr.Slug.String,
-		Body:   cr.Body.String,
-		Author: cr.Author.String,
+	c := comment.Comment{
+		ID:        cr.ID,
+		TenantID:  cr.TenantID,
+		ParentID:  cr.ParentID.String,
+		Slug:      cr.Slug.String,
+		Body:      cr.Body.String,
+		Author:    cr.Author.String,
+		CreatedAt: cr.CreatedAt,
+		UpdatedAt: cr.UpdatedAt,
+		Version:   cr.Version,
+		Status:    comment.Status(cr.Status),
+	}
+	if cr.DeletedAt.Valid {
+		c.DeletedAt = &cr.DeletedAt.Time
+	}
+	return c
+}
+
+func convertRowsToComments(rows []CommentRow) []comment.Comment {
+	comments := make([]comment.Comment, 0, len(rows))
+	for _, cr := range rows {
+		comments = append(comments, convertRowToComment(cr))
 	}
+	return comments
 }
 
 func convertCommentToRow(c comment.Comment) CommentRow {
 	return CommentRow{
-		ID:     c.ID,
-		Slug:   sql.NullString{String: c.Slug, Valid: true},
-		Body:   sql.NullString{String: c.Body, Valid: true},
-		Author: sql.NullString{String: c.Author, Valid: true},
+		ID:       c.ID,
+		ParentID: sql.NullString{String: c.ParentID, Valid: c.ParentID != ""},
+		Slug:     sql.NullString{String: c.Slug, Valid: true},
+		Body:     sql.NullString{String: c.Body, Valid: true},
+		Author:   sql.NullString{String: c.Author, Valid: true},
+		Version:  c.Version,
+		Status:   string(c.Status),
 	}
 }
 
 func (d *Database) GetComment(ctx context.Context, id string) (comment.Comment, error) {
+	defer d.observe("get_comment").ObserveDuration()
+	return d.getComment(ctx, id, false)
+}
+
+func (d *Database) GetCommentIncludingDeleted(ctx context.Context, id string) (comment.Comment, error) {
+	defer d.observe("get_comment_including_deleted").ObserveDuration()
+	return d.getComment(ctx, id, true)
+}
+
+func (d *Database) getComment(ctx context.Context, id string, includeDeleted bool) (comment.Comment, error) {
+	query := "SELECT " + commentColumns + " FROM comments WHERE id = $1"
+	if !includeDeleted {
+		query += " AND deleted_at IS NULL"
+	}
+
 	var cr CommentRow
-	row := d.Client.QueryRowContext(
-		ctx,
-		"SELECT id, slug, body, author FROM comments WHERE id = $1",
-		id,
-	)
-	err := row.Scan(&cr.ID, &cr.Slug, &cr.Body, &cr.Author)
+	err := d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		if err := tx.GetContext(ctx, &cr, query, id); err != nil {
+			return fmt.Errorf("failed to scan comment row: %w", translateError(err))
+		}
+		return nil
+	})
 	if err != nil {
-		return comment.Comment{}, fmt.Errorf("failed to scan comment row: %w", err)
+		return comment.Comment{}, err
 	}
 
 	return convertRowToComment(cr), nil
 }
 
-func (d *Database) CreateComment(ctx context.Context, c comment.Comment) (comment.Comment, error) {
-	cr := convertCommentToRow(c)
+// conditions accumulates WHERE clauses together with their positional arguments.
+type conditions struct {
+	clauses []string
+	args    []any
+}
+
+func (c *conditions) arg(v any) string {
+	c.args = append(c.args, v)
+	return "$" + strconv.Itoa(len(c.args))
+}
+
+func (c *conditions) where(clause string) {
+	c.clauses = append(c.clauses, clause)
+}
+
+func (c *conditions) String() string {
+	if len(c.clauses) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(c.clauses, " AND ")
+}
+
+func (d *Database) ListComments(ctx context.Context, p comment.ListParams) ([]comment.Comment, error) {
+	defer d.observe("list_comments").ObserveDuration()
 
-	rows, err := d.Client.NamedQueryContext(
-		ctx,
-		"INSERT INTO comments (id, slug, body, author) VALUES (:id, :slug, :body, :author)",
-		cr,
-	)
+	var c conditions
+	if p.Slug != "" {
+		c.where("slug = " + c.arg(p.Slug))
+	}
+	if p.Status != "" {
+		c.where("status = " + c.arg(string(p.Status)))
+	}
+
+	cmp, dir := ">", "ASC"
+	if p.Order == comment.OrderDesc {
+		cmp, dir = "<", "DESC"
+	}
+	if p.AfterID != "" {
+		c.where("id " + cmp + " " + c.arg(p.AfterID))
+	}
+	if p.RootsOnly {
+		c.where("parent_id IS NULL")
+	}
+	if !p.IncludeDeleted {
+		c.where("deleted_at IS NULL")
+	}
+
+	query := "SELECT " + commentColumns + " FROM comments" + c.String() +
+		" ORDER BY id " + dir + " LIMIT " + c.arg(p.Limit)
+
+	var rows []CommentRow
+	err := d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		if err := tx.SelectContext(ctx, &rows, query, c.args...); err != nil {
+			return fmt.Errorf("failed to list comments: %w", translateError(err))
+		}
+		return nil
+	})
 	if err != nil {
-		return comment.Comment{}, fmt.Errorf("failed to insert comment: %w", err)
+		return nil, err
+	}
+
+	return convertRowsToComments(rows), nil
+}
+
+// ListReplies walks the reply tree below the given parents with a recursive
+// CTE and returns every descendant at most MaxDepth levels deep, ordered by ID.
+// Unless deleted comments are included, a deleted reply hides its subtree, and
+// so does a reply that does not have the requested status.
+func (d *Database) ListReplies(ctx context.Context, p comment.ReplyParams) ([]comment.Comment, error) {
+	defer d.observe("list_replies").ObserveDuration()
+
+	args := []any{pq.Array(p.ParentIDs), p.MaxDepth}
+
+	var rootFilter, replyFilter string
+	if !p.IncludeDeleted {
+		rootFilter = " AND deleted_at IS NULL"
+		replyFilter = " AND c.deleted_at IS NULL"
 	}
-	if rows.Err() != nil {
-		return comment.Comment{}, fmt.Errorf("failed to execute insert query: %w", rows.Err())
+	if p.Status != "" {
+		args = append(args, string(p.Status))
+		rootFilter += " AND status = $3"
+		replyFilter += " AND c.status = $3"
 	}
-	defer rows.Close()
 
-	return c, nil
+	var rows []CommentRow
+	err := d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		err := tx.SelectContext(
+			ctx,
+			&rows,
+			`WITH RECURSIVE thread AS (
+				SELECT `+commentColumns+`, 1 AS depth
+				FROM comments
+				WHERE parent_id = ANY($1::uuid[])`+rootFilter+`
+				UNION ALL
+				SELECT c.id, c.tenant_id, c.parent_id, c.slug, c.body, c.author, c.created_at, c.updated_at,
+					c.deleted_at, c.version, c.status, t.depth + 1
+				FROM comments AS c
+				INNER JOIN thread AS t ON c.parent_id = t.id
+				WHERE t.depth < $2`+replyFilter+`
+			)
+			SELECT `+commentColumns+` FROM thread ORDER BY id`,
+			args...,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to list replies: %w", translateError(err))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return convertRowsToComments(rows), nil
 }
 
-func (d *Database) UpdateComment(ctx context.Context, c comment.Comment) error {
+// CreateComment inserts the comment into the tenant in ctx and records a
+// CommentCreated event in the outbox, in a single transaction.
+func (d *Database) CreateComment(ctx context.Context, c comment.Comment) (comment.Comment, error) {
+	defer d.observe("create_comment").ObserveDuration()
+
+	if c.Status == "" {
+		c.Status = comment.StatusApproved
+	}
 	cr := convertCommentToRow(c)
 
-	rows, err := d.Client.NamedQueryContext(
-		ctx,
-		"UPDATE comments SET slug = :slug, body = :body, author = :author WHERE id = :id",
-		cr,
-	)
+	var created comment.Comment
+	err := d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		query, args, err := tx.BindNamed(
+			"INSERT INTO comments (id, tenant_id, parent_id, slug, body, author, status) "+
+				"VALUES (:id, current_setting('app.tenant_id'), :parent_id, :slug, :body, :author, :status) "+
+				"RETURNING "+commentColumns,
+			cr,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to bind insert query: %w", err)
+		}
+
+		if err = tx.GetContext(ctx, &cr, query, args...); err != nil {
+			return fmt.Errorf("failed to insert comment: %w", translateError(err))
+		}
+
+		created = convertRowToComment(cr)
+		return insertOutbox(ctx, tx, comment.CommentCreated, nil, &created)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to update comment: %w", err)
+		return comment.Comment{}, err
 	}
-	if rows.Err() != nil {
-		return fmt.Errorf("failed to execute update query: %w", rows.Err())
+
+	return created, nil
+}
+
+// UpdateComment locks the comment, records its current content as a new
+// revision, applies the update and records a CommentUpdated event in the
+// outbox, all in a single transaction. It returns the comment as stored. The
+// slug is never changed, so that replies stay on the slug of their parent.
+func (d *Database) UpdateComment(ctx context.Context, c comment.Comment) (comment.Comment, error) {
+	defer d.observe("update_comment").ObserveDuration()
+
+	var updated comment.Comment
+	err := d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		old, err := lockComment(ctx, tx, c.ID, false)
+		if err != nil {
+			return err
+		}
+		if c.Version != comment.AnyVersion && old.Version != c.Version {
+			return comment.ErrVersionConflict
+		}
+
+		if err = insertRevision(ctx, tx, old, editorFromContext(ctx)); err != nil {
+			return err
+		}
+
+		if c.Status == "" {
+			c.Status = comment.Status(old.Status)
+		}
+
+		cr := convertCommentToRow(c)
+		query, args, err := tx.BindNamed(
+			"UPDATE comments SET body = :body, author = :author, status = :status, "+
+				"updated_at = now(), version = version + 1 WHERE id = :id RETURNING "+commentColumns,
+			cr,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to bind update query: %w", err)
+		}
+
+		if err = tx.GetContext(ctx, &cr, query, args...); err != nil {
+			return fmt.Errorf("failed to update comment: %w", translateError(err))
+		}
+
+		before := convertRowToComment(old)
+		updated = convertRowToComment(cr)
+		return insertOutbox(ctx, tx, comment.CommentUpdated, &before, &updated)
+	})
+	if err != nil {
+		return comment.Comment{}, err
 	}
-	defer rows.Close()
 
-	return nil
+	return updated, nil
 }
 
-func (d *Database) DeleteComment(ctx context.Context, id string) error {
-	_, err := d.Client.ExecContext(
-		ctx,
-		"DELETE FROM comments WHERE id = $1",
-		id,
-	)
+// DeleteComment locks the comment, soft deletes it and records a
+// CommentDeleted event in the outbox, all in a single transaction. The row
+// stays in place so that it can be restored later, but it is hidden from all
+// regular reads.
+func (d *Database) DeleteComment(ctx context.Context, id string, version int) error {
+	defer d.observe("delete_comment").ObserveDuration()
+	return d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		old, err := lockComment(ctx, tx, id, false)
+		if err != nil {
+			return err
+		}
+		if version != comment.AnyVersion && old.Version != version {
+			return comment.ErrVersionConflict
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			"UPDATE comments SET deleted_at = now(), version = version + 1 WHERE id = $1",
+			id,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to delete comment: %w", translateError(err))
+		}
+
+		before := convertRowToComment(old)
+		return insertOutbox(ctx, tx, comment.CommentDeleted, &before, nil)
+	})
+}
+
+// RestoreComment undoes a soft delete and records a CommentUpdated event in
+// the outbox, in a single transaction.
+func (d *Database) RestoreComment(ctx context.Context, id string) (comment.Comment, error) {
+	defer d.observe("restore_comment").ObserveDuration()
+
+	var restored comment.Comment
+	err := d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		old, err := lockComment(ctx, tx, id, true)
+		if err != nil {
+			return err
+		}
+
+		var cr CommentRow
+		err = tx.GetContext(
+			ctx,
+			&cr,
+			"UPDATE comments SET deleted_at = NULL, updated_at = now(), version = version + 1 "+
+				"WHERE id = $1 RETURNING "+commentColumns,
+			id,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to restore comment: %w", translateError(err))
+		}
+
+		before := convertRowToComment(old)
+		restored = convertRowToComment(cr)
+		return insertOutbox(ctx, tx, comment.CommentUpdated, &before, &restored)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to delete comment: %w", err)
+		return comment.Comment{}, err
+	}
+
+	return restored, nil
+}
+
+// lockComment reads the comment with a row lock that is held until tx ends.
+// It only finds deleted comments if deleted is set, and live ones otherwise.
+func lockComment(ctx context.Context, tx *sqlx.Tx, id string, deleted bool) (CommentRow, error) {
+	query := "SELECT " + commentColumns + " FROM comments WHERE id = $1 AND deleted_at IS NULL FOR UPDATE"
+	if deleted {
+		query = "SELECT " + commentColumns + " FROM comments WHERE id = $1 AND deleted_at IS NOT NULL FOR UPDATE"
 	}
 
-	return nil
+	var cr CommentRow
+	if err := tx.GetContext(ctx, &cr, query, id); err != nil {
+		return CommentRow{}, fmt.Errorf("failed to lock comment: %w", translateError(err))
+	}
+	return cr, nil
 }
@@ -4,15 +4,21 @@ package db_test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/azdanov/go-rest-api/internal/config"
 	"github.com/azdanov/go-rest-api/internal/db"
+	"github.com/azdanov/go-rest-api/internal/webhook"
 	uuid "github.com/gofrs/uuid/v5"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -37,6 +43,11 @@ func (s *CommentTestSuite) getUUID() string {
 	return uuid.String()
 }
 
+// tenantContext scopes a test to the default tenant.
+func tenantContext() context.Context {
+	return comment.WithTenant(context.Background(), comment.DefaultTenant)
+}
+
 func (s *CommentTestSuite) SetupSuite() {
 	ctx := context.Background()
 	pgContainer, err := postgres.Run(ctx,
@@ -58,12 +69,12 @@ func (s *CommentTestSuite) SetupSuite() {
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
-	newDB, err := db.NewDatabase(logger, connStr)
+	newDB, err := db.NewDatabase(logger, config.Database{URL: connStr})
 	require.NoError(s.T(), err)
 	s.db = newDB
 
 	// Run migrations
-	err = s.db.Migrate("../../migrations")
+	err = s.db.Migrate()
 	require.NoError(s.T(), err)
 }
 
@@ -79,6 +90,10 @@ func (s *CommentTestSuite) SetupTest() {
 	// Optional: Clean up tables before each test if needed
 	_, err := s.db.Client.ExecContext(context.Background(), "DELETE FROM comments")
 	require.NoError(s.T(), err)
+	_, err = s.db.Client.ExecContext(context.Background(), "DELETE FROM webhooks")
+	require.NoError(s.T(), err)
+	_, err = s.db.Client.ExecContext(context.Background(), "DELETE FROM outbox")
+	require.NoError(s.T(), err)
 }
 
 func TestCommentTestSuite(t *testing.T) {
@@ -86,7 +101,7 @@ func TestCommentTestSuite(t *testing.T) {
 }
 
 func (s *CommentTestSuite) TestCreateComment() {
-	ctx := context.Background()
+	ctx := tenantContext()
 	cmt := comment.Comment{
 		ID:     s.getUUID(),
 		Slug:   "test-slug",
@@ -101,40 +116,65 @@ func (s *CommentTestSuite) TestCreateComment() {
 	assert.Equal(s.T(), cmt.Body, createdCmt.Body)
 	assert.Equal(s.T(), cmt.Author, createdCmt.Author)
 
+	assert.False(s.T(), createdCmt.CreatedAt.IsZero())
+	assert.Equal(s.T(), createdCmt.CreatedAt, createdCmt.UpdatedAt)
+	assert.Nil(s.T(), createdCmt.DeletedAt)
+
 	// Verify by getting
 	fetchedCmt, err := s.db.GetComment(ctx, cmt.ID)
 	require.NoError(s.T(), err)
-	assert.Equal(s.T(), cmt, fetchedCmt)
+	assert.Equal(s.T(), createdCmt, fetchedCmt)
 }
 
 func (s *CommentTestSuite) TestGetComment() {
-	ctx := context.Background()
+	ctx := tenantContext()
 	cmt := comment.Comment{
 		ID:     s.getUUID(),
 		Slug:   "get-slug",
 		Body:   "get body",
 		Author: "get author",
 	}
-	_, err := s.db.CreateComment(ctx, cmt) // Insert first
+	createdCmt, err := s.db.CreateComment(ctx, cmt) // Insert first
 	require.NoError(s.T(), err)
 
 	fetchedCmt, err := s.db.GetComment(ctx, cmt.ID)
 	require.NoError(s.T(), err)
-	assert.Equal(s.T(), cmt, fetchedCmt)
+	assert.Equal(s.T(), createdCmt, fetchedCmt)
 }
 
 func (s *CommentTestSuite) TestGetComment_NotFound() {
-	ctx := context.Background()
+	ctx := tenantContext()
 	nonExistentID := s.getUUID()
 
 	_, err := s.db.GetComment(ctx, nonExistentID)
-	require.Error(s.T(), err)
-	// Depending on your error handling, you might want a more specific check
-	assert.Contains(s.T(), err.Error(), "failed to scan comment row")
+	require.ErrorIs(s.T(), err, comment.ErrCommentNotFound)
+}
+
+func (s *CommentTestSuite) TestUpdateDeleteComment_NotFound() {
+	ctx := tenantContext()
+	nonExistentID := s.getUUID()
+
+	_, err := s.db.UpdateComment(ctx, comment.Comment{ID: nonExistentID, Slug: "missing", Body: "missing", Version: 1})
+	require.ErrorIs(s.T(), err, comment.ErrCommentNotFound)
+
+	err = s.db.DeleteComment(ctx, nonExistentID, 1)
+	require.ErrorIs(s.T(), err, comment.ErrCommentNotFound)
+}
+
+func (s *CommentTestSuite) TestGetComment_Unavailable() {
+	ctx := tenantContext()
+	// Nothing listens on port 1, so every query fails to dial.
+	client, err := sqlx.Open("postgres", "host=127.0.0.1 port=1 user=none dbname=none sslmode=disable")
+	require.NoError(s.T(), err)
+	defer client.Close()
+
+	unreachable := &db.Database{Client: client}
+	_, err = unreachable.GetComment(ctx, s.getUUID())
+	require.ErrorIs(s.T(), err, comment.ErrUnavailable)
 }
 
 func (s *CommentTestSuite) TestUpdateComment() {
-	ctx := context.Background()
+	ctx := tenantContext()
 	cmt := comment.Comment{
 		ID:     s.getUUID(),
 		Slug:   "update-slug-initial",
@@ -144,23 +184,28 @@ func (s *CommentTestSuite) TestUpdateComment() {
 	_, err := s.db.CreateComment(ctx, cmt) // Insert first
 	require.NoError(s.T(), err)
 
-	// Update fields
+	// Update fields, the slug is ignored
 	cmt.Slug = "update-slug-updated"
 	cmt.Body = "update body updated"
+	cmt.Version = 1
 
-	err = s.db.UpdateComment(ctx, cmt)
+	updatedCmt, err := s.db.UpdateComment(ctx, cmt)
 	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, updatedCmt.Version)
+	assert.Equal(s.T(), cmt.Body, updatedCmt.Body)
 
 	// Verify by getting
 	fetchedCmt, err := s.db.GetComment(ctx, cmt.ID)
 	require.NoError(s.T(), err)
-	assert.Equal(s.T(), cmt.Slug, fetchedCmt.Slug)
+	assert.True(s.T(), fetchedCmt.UpdatedAt.After(fetchedCmt.CreatedAt))
+	assert.Equal(s.T(), 2, fetchedCmt.Version)
+	assert.Equal(s.T(), "update-slug-initial", fetchedCmt.Slug)
 	assert.Equal(s.T(), cmt.Body, fetchedCmt.Body)
 	assert.Equal(s.T(), cmt.Author, fetchedCmt.Author) // Author should remain the same
 }
 
 func (s *CommentTestSuite) TestDeleteComment() {
-	ctx := context.Background()
+	ctx := tenantContext()
 	cmt := comment.Comment{
 		ID:     s.getUUID(),
 		Slug:   "delete-slug",
@@ -170,11 +215,873 @@ func (s *CommentTestSuite) TestDeleteComment() {
 	_, err := s.db.CreateComment(ctx, cmt) // Insert first
 	require.NoError(s.T(), err)
 
-	err = s.db.DeleteComment(ctx, cmt.ID)
+	err = s.db.DeleteComment(ctx, cmt.ID, 1)
 	require.NoError(s.T(), err)
 
 	// Verify deletion by trying to get
 	_, err = s.db.GetComment(ctx, cmt.ID)
+	require.ErrorIs(s.T(), err, comment.ErrCommentNotFound)
+
+	// The row is only soft deleted
+	deletedCmt, err := s.db.GetCommentIncludingDeleted(ctx, cmt.ID)
+	require.NoError(s.T(), err)
+	assert.NotNil(s.T(), deletedCmt.DeletedAt)
+
+	listed, err := s.db.ListComments(ctx, comment.ListParams{Slug: cmt.Slug, Limit: 10, Order: comment.OrderAsc})
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), listed)
+}
+
+func (s *CommentTestSuite) TestRestoreComment() {
+	ctx := tenantContext()
+	cmt := comment.Comment{
+		ID:     s.getUUID(),
+		Slug:   "restore-slug",
+		Body:   "restore body",
+		Author: "restore author",
+	}
+	_, err := s.db.CreateComment(ctx, cmt)
+	require.NoError(s.T(), err)
+
+	_, err = s.db.RestoreComment(ctx, cmt.ID)
+	require.ErrorIs(s.T(), err, comment.ErrCommentNotFound, "live comments cannot be restored")
+
+	err = s.db.DeleteComment(ctx, cmt.ID, 1)
+	require.NoError(s.T(), err)
+
+	restoredCmt, err := s.db.RestoreComment(ctx, cmt.ID)
+	require.NoError(s.T(), err)
+	assert.Nil(s.T(), restoredCmt.DeletedAt)
+	assert.Equal(s.T(), 3, restoredCmt.Version)
+
+	restoredCmt, err = s.db.GetComment(ctx, cmt.ID)
+	require.NoError(s.T(), err)
+	assert.Nil(s.T(), restoredCmt.DeletedAt)
+}
+
+func (s *CommentTestSuite) TestListComments() {
+	ctx := tenantContext()
+	var ids []string
+	for range 3 {
+		cmt := comment.Comment{
+			ID:     s.getUUID(),
+			Slug:   "list-slug",
+			Body:   "list body",
+			Author: "list author",
+		}
+		_, err := s.db.CreateComment(ctx, cmt)
+		require.NoError(s.T(), err)
+		ids = append(ids, cmt.ID)
+	}
+	_, err := s.db.CreateComment(ctx, comment.Comment{ID: s.getUUID(), Slug: "other-slug"})
+	require.NoError(s.T(), err)
+
+	asc, err := s.db.ListComments(ctx, comment.ListParams{Slug: "list-slug", Limit: 10, Order: comment.OrderAsc})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), asc, 3)
+	assert.Equal(s.T(), ids[0], asc[0].ID)
+	assert.Equal(s.T(), ids[2], asc[2].ID)
+
+	after, err := s.db.ListComments(ctx, comment.ListParams{
+		Slug:    "list-slug",
+		AfterID: ids[0],
+		Limit:   1,
+		Order:   comment.OrderAsc,
+	})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), after, 1)
+	assert.Equal(s.T(), ids[1], after[0].ID)
+
+	desc, err := s.db.ListComments(ctx, comment.ListParams{
+		Slug:    "list-slug",
+		AfterID: ids[2],
+		Limit:   10,
+		Order:   comment.OrderDesc,
+	})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), desc, 2)
+	assert.Equal(s.T(), ids[1], desc[0].ID)
+	assert.Equal(s.T(), ids[0], desc[1].ID)
+}
+
+func (s *CommentTestSuite) TestListReplies() {
+	ctx := tenantContext()
+	parentID := ""
+	var ids []string
+	for range 3 {
+		cmt := comment.Comment{
+			ID:       s.getUUID(),
+			ParentID: parentID,
+			Slug:     "thread-slug",
+			Body:     "thread body",
+			Author:   "thread author",
+		}
+		_, err := s.db.CreateComment(ctx, cmt)
+		require.NoError(s.T(), err)
+		ids = append(ids, cmt.ID)
+		parentID = cmt.ID
+	}
+
+	replies, err := s.db.ListReplies(ctx, comment.ReplyParams{ParentIDs: []string{ids[0]}, MaxDepth: 1})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), replies, 1)
+	assert.Equal(s.T(), ids[1], replies[0].ID)
+	assert.Equal(s.T(), ids[0], replies[0].ParentID)
+
+	replies, err = s.db.ListReplies(ctx, comment.ReplyParams{ParentIDs: []string{ids[0]}, MaxDepth: 5})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), replies, 2)
+	assert.Equal(s.T(), ids[2], replies[1].ID)
+
+	roots, err := s.db.ListComments(ctx, comment.ListParams{
+		Slug:      "thread-slug",
+		Limit:     10,
+		Order:     comment.OrderAsc,
+		RootsOnly: true,
+	})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), roots, 1)
+	assert.Equal(s.T(), ids[0], roots[0].ID)
+}
+
+func (s *CommentTestSuite) TestUpdateComment_VersionConflict() {
+	ctx := tenantContext()
+	cmt := comment.Comment{
+		ID:     s.getUUID(),
+		Slug:   "conflict-slug",
+		Body:   "conflict body",
+		Author: "conflict author",
+	}
+	createdCmt, err := s.db.CreateComment(ctx, cmt)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 1, createdCmt.Version)
+
+	createdCmt.Body = "first writer"
+	_, err = s.db.UpdateComment(ctx, createdCmt)
+	require.NoError(s.T(), err)
+
+	createdCmt.Body = "second writer"
+	_, err = s.db.UpdateComment(ctx, createdCmt)
+	require.ErrorIs(s.T(), err, comment.ErrVersionConflict)
+
+	err = s.db.DeleteComment(ctx, cmt.ID, 1)
+	require.ErrorIs(s.T(), err, comment.ErrVersionConflict)
+
+	anyVersion := createdCmt
+	anyVersion.Version = comment.AnyVersion
+	anyVersion.Body = "first writer"
+	_, err = s.db.UpdateComment(ctx, anyVersion)
+	require.NoError(s.T(), err, "AnyVersion matches whichever version is stored")
+
+	fetchedCmt, err := s.db.GetComment(ctx, cmt.ID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "first writer", fetchedCmt.Body)
+}
+
+func (s *CommentTestSuite) TestUpdateComment_RecordsRevision() {
+	ctx := comment.WithActor(tenantContext(), comment.Actor{ID: "editor"})
+	cmt := comment.Comment{
+		ID:     s.getUUID(),
+		Slug:   "revision-slug",
+		Body:   "revision body initial",
+		Author: "revision author",
+	}
+	createdCmt, err := s.db.CreateComment(ctx, cmt)
+	require.NoError(s.T(), err)
+
+	createdCmt.Body = "revision body updated"
+	_, err = s.db.UpdateComment(ctx, createdCmt)
+	require.NoError(s.T(), err)
+
+	createdCmt.Body = "revision body final"
+	createdCmt.Version = 2
+	_, err = s.db.UpdateComment(ctx, createdCmt)
+	require.NoError(s.T(), err)
+
+	revisions, err := s.db.ListRevisions(ctx, cmt.ID)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), revisions, 2)
+	assert.Equal(s.T(), 1, revisions[0].Number)
+	assert.Equal(s.T(), "revision body initial", revisions[0].Body)
+	assert.Equal(s.T(), "editor", revisions[0].EditedBy)
+	assert.Equal(s.T(), 2, revisions[1].Number)
+	assert.Equal(s.T(), "revision body updated", revisions[1].Body)
+}
+
+func (s *CommentTestSuite) TestModerateComment() {
+	ctx := tenantContext()
+	cmt := comment.Comment{
+		ID:     s.getUUID(),
+		Slug:   "moderation-slug",
+		Body:   "moderation body",
+		Author: "moderation author",
+		Status: comment.StatusPending,
+	}
+	_, err := s.db.CreateComment(ctx, cmt)
+	require.NoError(s.T(), err)
+
+	queue, err := s.db.ListComments(ctx, comment.ListParams{Status: comment.StatusPending, Limit: 10})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), queue, 1)
+	assert.Equal(s.T(), cmt.ID, queue[0].ID)
+
+	err = s.db.ModerateComment(ctx, comment.Moderation{
+		CommentID: cmt.ID,
+		From:      comment.StatusPending,
+		To:        comment.StatusApproved,
+		Reason:    "fine",
+		Moderator: "moderator",
+	})
+	require.NoError(s.T(), err)
+
+	fetchedCmt, err := s.db.GetComment(ctx, cmt.ID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), comment.StatusApproved, fetchedCmt.Status)
+	assert.Equal(s.T(), 2, fetchedCmt.Version)
+
+	// The decision was based on a stale status.
+	err = s.db.ModerateComment(ctx, comment.Moderation{
+		CommentID: cmt.ID,
+		From:      comment.StatusPending,
+		To:        comment.StatusRejected,
+	})
+	require.ErrorIs(s.T(), err, comment.ErrVersionConflict)
+
+	var reasons []string
+	err = s.db.Client.SelectContext(
+		ctx,
+		&reasons,
+		"SELECT reason FROM comment_moderations WHERE comment_id = $1",
+		cmt.ID,
+	)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{"fine"}, reasons)
+}
+
+func (s *CommentTestSuite) TestSearch() {
+	ctx := tenantContext()
+	bodies := []string{
+		"The quick brown fox jumps over the lazy dog",
+		"A fox, a fox, a fox everywhere",
+		"Nothing to see here",
+	}
+	for _, body := range bodies {
+		_, err := s.db.CreateComment(ctx, comment.Comment{
+			ID:     s.getUUID(),
+			Slug:   "search-slug",
+			Body:   body,
+			Author: "search author",
+		})
+		require.NoError(s.T(), err)
+	}
+
+	results, err := s.db.Search(ctx, comment.SearchParams{Query: "foxes", Slug: "search-slug", Limit: 10})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), results, 2)
+	assert.Equal(s.T(), bodies[1], results[0].Body, "more matches rank higher")
+	assert.GreaterOrEqual(s.T(), results[0].Rank, results[1].Rank)
+	assert.Contains(s.T(), results[0].Snippet, "<mark>fox</mark>")
+
+	results, err = s.db.Search(ctx, comment.SearchParams{Query: "fox", Limit: 10, Offset: 1})
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), results, 1)
+
+	results, err = s.db.Search(ctx, comment.SearchParams{Query: "fox", Author: "someone else", Limit: 10})
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), results)
+
+	// The body is escaped, so only the highlighting is markup.
+	_, err = s.db.CreateComment(ctx, comment.Comment{
+		ID:     s.getUUID(),
+		Slug:   "search-markup-slug",
+		Body:   `<script>alert("badger")</script> & <img src=x onerror=alert(1)> badger`,
+		Author: "search author",
+	})
+	require.NoError(s.T(), err)
+	results, err = s.db.Search(ctx, comment.SearchParams{Query: "badger", Slug: "search-markup-slug", Limit: 10})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), results, 1)
+	assert.NotContains(s.T(), results[0].Snippet, "<script>")
+	assert.NotContains(s.T(), results[0].Snippet, "<img")
+	assert.Contains(s.T(), results[0].Snippet, "&lt;script&gt;")
+	assert.Contains(s.T(), results[0].Snippet, "<mark>badger</mark>")
+}
+
+func (s *CommentTestSuite) TestReactions() {
+	ctx := tenantContext()
+	first, err := s.db.CreateComment(ctx, comment.Comment{ID: s.getUUID(), Slug: "reaction-slug", Body: "first"})
+	require.NoError(s.T(), err)
+	second, err := s.db.CreateComment(ctx, comment.Comment{ID: s.getUUID(), Slug: "reaction-slug", Body: "second"})
+	require.NoError(s.T(), err)
+
+	reactions := []comment.Reaction{
+		{CommentID: first.ID, UserID: "alice", Kind: comment.ReactionLike},
+		{CommentID: first.ID, UserID: "alice", Kind: comment.ReactionLike},
+		{CommentID: first.ID, UserID: "bob", Kind: comment.ReactionLike},
+		{CommentID: first.ID, UserID: "bob", Kind: comment.ReactionSad},
+	}
+	for _, r := range reactions {
+		require.NoError(s.T(), s.db.AddReaction(ctx, r))
+	}
+	require.NoError(s.T(), s.db.RemoveReaction(ctx, comment.Reaction{
+		CommentID: first.ID, UserID: "bob", Kind: comment.ReactionSad,
+	}))
+
+	summaries, err := s.db.SummarizeReactions(ctx, []string{first.ID, second.ID}, "alice")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), []comment.ReactionSummary{
+		{Kind: comment.ReactionLike, Count: 2, ReactedByMe: true},
+	}, summaries[first.ID])
+	assert.Empty(s.T(), summaries[second.ID])
+}
+
+func (s *CommentTestSuite) TestTenantIsolation() {
+	ctx := tenantContext()
+	other := comment.WithTenant(context.Background(), "other-tenant")
+
+	cmt, err := s.db.CreateComment(ctx, comment.Comment{
+		ID:     s.getUUID(),
+		Slug:   "tenant-slug",
+		Body:   "tenant body",
+		Author: "tenant author",
+	})
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), comment.DefaultTenant, cmt.TenantID)
+	like := comment.Reaction{CommentID: cmt.ID, UserID: "alice", Kind: comment.ReactionLike}
+	require.NoError(s.T(), s.db.AddReaction(ctx, like))
+
+	_, err = s.db.GetComment(other, cmt.ID)
+	require.ErrorIs(s.T(), err, comment.ErrCommentNotFound)
+
+	comments, err := s.db.ListComments(other, comment.ListParams{Slug: "tenant-slug", Limit: 10})
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), comments)
+
+	_, err = s.db.UpdateComment(other, cmt)
+	require.ErrorIs(s.T(), err, comment.ErrCommentNotFound)
+	require.ErrorIs(s.T(), s.db.DeleteComment(other, cmt.ID, cmt.Version), comment.ErrCommentNotFound)
+
+	summaries, err := s.db.SummarizeReactions(other, []string{cmt.ID}, "alice")
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), summaries)
+	require.Error(s.T(), s.db.AddReaction(other, like), "the policy rejects rows for invisible comments")
+
+	events, err := s.db.EventsAfter(other, "tenant-slug", uuid.Nil.String(), 10)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), events)
+	events, err = s.db.EventsAfter(ctx, "tenant-slug", uuid.Nil.String(), 10)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), events, 1)
+	assert.Equal(s.T(), comment.DefaultTenant, events[0].TenantID)
+
+	_, err = s.db.GetComment(context.Background(), cmt.ID)
+	require.ErrorIs(s.T(), err, comment.ErrTenantRequired)
+
+	// The policies hold even for a query that does not filter by tenant.
+	tx, err := s.db.Client.BeginTxx(ctx, nil)
+	require.NoError(s.T(), err)
+	defer func() { _ = tx.Rollback() }()
+	_, err = tx.ExecContext(ctx, "SELECT set_config('role', 'comments_tenant', true)")
+	require.NoError(s.T(), err)
+	_, err = tx.ExecContext(ctx, "SELECT set_config('app.tenant_id', 'other-tenant', true)")
+	require.NoError(s.T(), err)
+	var n int
+	require.NoError(s.T(), tx.GetContext(ctx, &n, "SELECT count(*) FROM comments"))
+	assert.Zero(s.T(), n)
+	require.NoError(s.T(), tx.GetContext(ctx, &n, "SELECT count(*) FROM comment_reactions"))
+	assert.Zero(s.T(), n)
+}
+
+func (s *CommentTestSuite) TestExportImport() {
+	ctx := tenantContext()
+	other := comment.WithTenant(context.Background(), "import-tenant")
+
+	root, err := s.db.CreateComment(ctx, comment.Comment{
+		ID:     s.getUUID(),
+		Slug:   "export-slug",
+		Body:   "root body",
+		Author: "root author",
+	})
+	require.NoError(s.T(), err)
+	reply, err := s.db.CreateComment(ctx, comment.Comment{
+		ID:       s.getUUID(),
+		ParentID: root.ID,
+		Slug:     "export-slug",
+		Body:     "reply body",
+		Author:   "reply author",
+	})
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.db.DeleteComment(ctx, reply.ID, reply.Version))
+
+	var exported []comment.Comment
+	err = s.db.ExportComments(ctx, func(c comment.Comment) error {
+		exported = append(exported, c)
+		return nil
+	})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), exported, 2)
+	assert.Equal(s.T(), root.ID, exported[0].ID)
+	assert.Equal(s.T(), reply.ID, exported[1].ID)
+	assert.NotNil(s.T(), exported[1].DeletedAt)
+
+	// The IDs are taken, so the comments can only be imported once.
+	_, err = s.db.Client.ExecContext(context.Background(), "DELETE FROM comments")
+	require.NoError(s.T(), err)
+
+	imported, err := s.db.ImportComments(other, exported)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, imported)
+	imported, err = s.db.ImportComments(other, exported)
+	require.NoError(s.T(), err)
+	assert.Zero(s.T(), imported)
+
+	got, err := s.db.GetCommentIncludingDeleted(other, reply.ID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "import-tenant", got.TenantID)
+	assert.Equal(s.T(), root.ID, got.ParentID)
+	assert.Equal(s.T(), exported[1].Version, got.Version)
+	assert.WithinDuration(s.T(), exported[1].CreatedAt, got.CreatedAt, time.Microsecond)
+	assert.NotNil(s.T(), got.DeletedAt)
+
+	events, err := s.db.EventsAfter(other, "export-slug", uuid.Nil.String(), 10)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), events, "imported comments are not new")
+}
+
+func (s *CommentTestSuite) TestMigrations() {
+	list, err := s.db.Migrations()
+	require.NoError(s.T(), err)
+	require.NotEmpty(s.T(), list)
+	assert.Equal(s.T(), "create_comments_table", list[0].Name)
+	latest := list[len(list)-1].Version
+
+	status, err := s.db.MigrationStatus()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), db.MigrationStatus{Version: latest, Latest: latest}, status)
+
+	require.NoError(s.T(), s.db.MigrateDown(2))
+	status, err = s.db.MigrationStatus()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), latest-2, status.Version)
+	assert.Equal(s.T(), list[len(list)-2:], status.Pending)
+	schema, err := s.db.SchemaStatus(context.Background())
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), status, schema, "the readiness probe reads the same status")
+
+	require.NoError(s.T(), s.db.MigrateTo(latest-1))
+	status, err = s.db.MigrationStatus()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), latest-1, status.Version)
+
+	require.NoError(s.T(), s.db.Migrate())
+	require.NoError(s.T(), s.db.Force(int(latest)))
+	status, err = s.db.CheckSchema()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), db.MigrationStatus{Version: latest, Latest: latest}, status)
+
+	require.Error(s.T(), s.db.MigrateDown(0))
+}
+
+func (s *CommentTestSuite) TestCheckSchema() {
+	status, err := s.db.MigrationStatus()
+	require.NoError(s.T(), err)
+	defer func() { require.NoError(s.T(), s.db.Force(int(status.Latest))) }()
+
+	_, err = s.db.Client.Exec("UPDATE schema_migrations SET dirty = true")
+	require.NoError(s.T(), err)
+	_, err = s.db.CheckSchema()
+	require.ErrorIs(s.T(), err, db.ErrSchemaDirty)
+	require.ErrorIs(s.T(), s.db.Migrate(), db.ErrSchemaDirty)
+	schema, err := s.db.SchemaStatus(context.Background())
+	require.NoError(s.T(), err)
+	assert.True(s.T(), schema.Dirty)
+
+	require.NoError(s.T(), s.db.Force(int(status.Latest)+1))
+	_, err = s.db.CheckSchema()
+	require.ErrorIs(s.T(), err, db.ErrSchemaTooNew)
+	require.ErrorIs(s.T(), s.db.Migrate(), db.ErrSchemaTooNew)
+	require.ErrorIs(s.T(), s.db.MigrateDown(1), db.ErrSchemaTooNew)
+}
+
+func (s *CommentTestSuite) TestWebhookDeliveries() {
+	ctx := tenantContext()
+
+	wh, err := s.db.CreateWebhook(ctx, webhook.Webhook{
+		ID:     s.getUUID(),
+		URL:    "https://example.com/hook",
+		Secret: "s3cret",
+		Events: []comment.EventType{comment.CommentCreated},
+		Active: true,
+	})
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), []comment.EventType{comment.CommentCreated}, wh.Events)
+
+	_, err = s.db.GetWebhook(ctx, s.getUUID())
+	require.ErrorIs(s.T(), err, webhook.ErrWebhookNotFound)
+
+	d := webhook.Delivery{
+		ID:        s.getUUID(),
+		WebhookID: wh.ID,
+		EventID:   s.getUUID(),
+		EventType: comment.CommentCreated,
+		Payload:   []byte(`{"type":"comment.created"}`),
+		Status:    webhook.DeliveryPending,
+	}
+	require.NoError(s.T(), s.db.CreateDeliveries(ctx, []webhook.Delivery{d}))
+	// Queuing the same event again is a no-op.
+	dup := d
+	dup.ID = s.getUUID()
+	require.NoError(s.T(), s.db.CreateDeliveries(ctx, []webhook.Delivery{dup}))
+
+	jobs, err := s.db.ClaimDeliveries(ctx, 10, time.Minute)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), jobs, 1)
+	assert.Equal(s.T(), d.ID, jobs[0].Delivery.ID)
+	assert.Equal(s.T(), wh.URL, jobs[0].URL)
+	assert.Equal(s.T(), "s3cret", jobs[0].Secret)
+	assert.JSONEq(s.T(), string(d.Payload), string(jobs[0].Delivery.Payload))
+
+	// The lease hides the claimed delivery from other workers.
+	jobs, err = s.db.ClaimDeliveries(ctx, 10, time.Minute)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), jobs)
+
+	delivered := time.Now().UTC()
+	d.Status = webhook.DeliverySucceeded
+	d.Attempts = 1
+	d.ResponseStatus = 200
+	d.DeliveredAt = &delivered
+	require.NoError(s.T(), s.db.UpdateDelivery(ctx, d))
+
+	deliveries, err := s.db.ListDeliveries(ctx, wh.ID, 10)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), deliveries, 1)
+	assert.Equal(s.T(), webhook.DeliverySucceeded, deliveries[0].Status)
+	assert.Equal(s.T(), 1, deliveries[0].Attempts)
+	assert.Equal(s.T(), 200, deliveries[0].ResponseStatus)
+	assert.NotNil(s.T(), deliveries[0].DeliveredAt)
+
+	require.NoError(s.T(), s.db.DeleteWebhook(ctx, wh.ID))
+	require.ErrorIs(s.T(), s.db.DeleteWebhook(ctx, wh.ID), webhook.ErrWebhookNotFound)
+}
+
+func (s *CommentTestSuite) TestWebhookTenants() {
+	ctx := tenantContext()
+	other := comment.WithTenant(context.Background(), "webhook-tenant")
+
+	wh, err := s.db.CreateWebhook(ctx, webhook.Webhook{ID: s.getUUID(), URL: "https://example.com/own", Active: true})
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.db.CreateDeliveries(ctx, []webhook.Delivery{{
+		ID:        s.getUUID(),
+		WebhookID: wh.ID,
+		EventID:   s.getUUID(),
+		EventType: comment.CommentCreated,
+		Payload:   []byte(`{}`),
+		Status:    webhook.DeliveryPending,
+	}}))
+
+	// Another tenant sees neither the webhook nor its deliveries.
+	webhooks, err := s.db.ListWebhooks(other)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), webhooks)
+
+	_, err = s.db.GetWebhook(other, wh.ID)
+	require.ErrorIs(s.T(), err, webhook.ErrWebhookNotFound)
+	require.ErrorIs(s.T(), s.db.DeleteWebhook(other, wh.ID), webhook.ErrWebhookNotFound)
+
+	deliveries, err := s.db.ListDeliveries(other, wh.ID, 10)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), deliveries)
+
+	_, err = s.db.ListWebhooks(context.Background())
+	require.ErrorIs(s.T(), err, comment.ErrTenantRequired)
+
+	require.NoError(s.T(), s.db.DeleteWebhook(ctx, wh.ID))
+}
+
+// recordingSink collects relayed events and fails once failAfter events have
+// been accepted, if set.
+type recordingSink struct {
+	mu        sync.Mutex
+	events    []comment.Event
+	failAfter int
+}
+
+func (rs *recordingSink) Publish(_ context.Context, e comment.Event) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.failAfter > 0 && len(rs.events) >= rs.failAfter {
+		return errors.New("sink unavailable")
+	}
+	rs.events = append(rs.events, e)
+	return nil
+}
+
+func (s *CommentTestSuite) TestOutbox() {
+	ctx := comment.WithActor(tenantContext(), comment.Actor{ID: "outbox-actor"})
+	cmt, err := s.db.CreateComment(ctx, comment.Comment{
+		ID:     s.getUUID(),
+		Slug:   "outbox-slug",
+		Body:   "outbox body",
+		Author: "outbox author",
+	})
+	require.NoError(s.T(), err)
+
+	cmt.Body = "outbox body updated"
+	updated, err := s.db.UpdateComment(ctx, cmt)
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.db.DeleteComment(ctx, cmt.ID, updated.Version))
+
+	// A failed write records no event.
+	_, err = s.db.UpdateComment(ctx, cmt)
 	require.Error(s.T(), err)
-	assert.Contains(s.T(), err.Error(), "failed to scan comment row")
+
+	sink := &recordingSink{failAfter: 2}
+	relay := db.NewOutboxRelay(s.db, sink, slog.Default())
+
+	n, err := relay.RelayBatch(ctx)
+	require.Error(s.T(), err, "the failing sink stops the batch")
+	assert.Equal(s.T(), 2, n)
+
+	sink.failAfter = 0
+	n, err = relay.RelayBatch(ctx)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 1, n)
+
+	n, err = relay.RelayBatch(ctx)
+	require.NoError(s.T(), err)
+	assert.Zero(s.T(), n)
+
+	require.Len(s.T(), sink.events, 3)
+	assert.Equal(s.T(), comment.CommentCreated, sink.events[0].Type)
+	assert.Nil(s.T(), sink.events[0].Before)
+	assert.Equal(s.T(), "outbox body", sink.events[0].After.Body)
+	assert.Equal(s.T(), comment.CommentUpdated, sink.events[1].Type)
+	assert.Equal(s.T(), "outbox body", sink.events[1].Before.Body)
+	assert.Equal(s.T(), "outbox body updated", sink.events[1].After.Body)
+	assert.Equal(s.T(), comment.CommentDeleted, sink.events[2].Type)
+	assert.Nil(s.T(), sink.events[2].After)
+	for _, e := range sink.events {
+		assert.Equal(s.T(), cmt.ID, e.CommentID)
+		assert.Equal(s.T(), "outbox-actor", e.Actor)
+	}
+}
+
+func (s *CommentTestSuite) TestOutbox_ConcurrentRelays() {
+	ctx := tenantContext()
+	const comments = 50
+	for i := range comments {
+		_, err := s.db.CreateComment(ctx, comment.Comment{
+			ID:     s.getUUID(),
+			Slug:   "outbox-concurrent-slug",
+			Body:   fmt.Sprintf("body %d", i),
+			Author: "outbox author",
+		})
+		require.NoError(s.T(), err)
+	}
+
+	sink := &recordingSink{}
+	var wg sync.WaitGroup
+	for range 4 {
+		relay := db.NewOutboxRelay(s.db, sink, slog.Default())
+		relay.BatchSize = 5
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				n, err := relay.RelayBatch(ctx)
+				if err != nil || n == 0 {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	seen := map[string]bool{}
+	for _, e := range sink.events {
+		assert.False(s.T(), seen[e.ID], "event %s relayed twice", e.ID)
+		seen[e.ID] = true
+	}
+	assert.Len(s.T(), seen, comments)
+}
+
+func (s *CommentTestSuite) TestOutbox_DeadEvents() {
+	ctx := tenantContext()
+	const slug = "outbox-dead-slug"
+
+	// A payload that does not decode does not hold up the events behind it.
+	poisonID := s.getUUID()
+	_, err := s.db.Client.ExecContext(
+		ctx,
+		"INSERT INTO outbox (event_id, tenant_id, slug, event_type, comment_id, payload) "+
+			`VALUES ($1, $2, $3, 'comment.created', $4, '{"id": 1}')`,
+		poisonID,
+		comment.DefaultTenant,
+		slug,
+		s.getUUID(),
+	)
+	require.NoError(s.T(), err)
+	cmt, err := s.db.CreateComment(ctx, comment.Comment{ID: s.getUUID(), Slug: slug, Body: "body", Author: "author"})
+	require.NoError(s.T(), err)
+
+	sink := &recordingSink{}
+	relay := db.NewOutboxRelay(s.db, sink, slog.Default())
+	relay.MaxAttempts = 2
+	n, err := relay.RelayBatch(ctx)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 1, n)
+	require.Len(s.T(), sink.events, 1)
+	assert.Equal(s.T(), cmt.ID, sink.events[0].CommentID)
+
+	var dead bool
+	err = s.db.Client.GetContext(ctx, &dead, "SELECT dead_at IS NOT NULL FROM outbox WHERE event_id = $1", poisonID)
+	require.NoError(s.T(), err)
+	assert.True(s.T(), dead)
+
+	// An event the sink keeps failing is given up after MaxAttempts.
+	_, err = s.db.CreateComment(ctx, comment.Comment{ID: s.getUUID(), Slug: slug, Body: "failing", Author: "author"})
+	require.NoError(s.T(), err)
+	sink.failAfter = len(sink.events)
+
+	n, err = relay.RelayBatch(ctx)
+	require.Error(s.T(), err, "the first failure is retried")
+	assert.Zero(s.T(), n)
+	n, err = relay.RelayBatch(ctx)
+	require.NoError(s.T(), err, "the second failure is the last")
+	assert.Zero(s.T(), n)
+
+	var attempts int
+	err = s.db.Client.GetContext(
+		ctx,
+		&attempts,
+		"SELECT attempts FROM outbox WHERE slug = $1 AND dead_at IS NOT NULL AND last_error = 'sink unavailable'",
+		slug,
+	)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, attempts)
+
+	n, err = relay.RelayBatch(ctx)
+	require.NoError(s.T(), err)
+	assert.Zero(s.T(), n)
+}
+
+func (s *CommentTestSuite) TestPruneOutbox() {
+	ctx := tenantContext()
+	const slug = "outbox-prune-slug"
+	old, err := s.db.CreateComment(ctx, comment.Comment{ID: s.getUUID(), Slug: slug, Body: "old", Author: "pruner"})
+	require.NoError(s.T(), err)
+
+	relay := db.NewOutboxRelay(s.db, &recordingSink{}, slog.Default())
+	for {
+		n, relayErr := relay.RelayBatch(ctx)
+		require.NoError(s.T(), relayErr)
+		if n == 0 {
+			break
+		}
+	}
+	_, err = s.db.Client.ExecContext(
+		ctx, "UPDATE outbox SET delivered_at = now() - interval '2 hours' WHERE slug = $1", slug,
+	)
+	require.NoError(s.T(), err)
+
+	// Undelivered events are kept, however old.
+	pending, err := s.db.CreateComment(ctx, comment.Comment{ID: s.getUUID(), Slug: slug, Body: "new", Author: "pruner"})
+	require.NoError(s.T(), err)
+	_, err = s.db.Client.ExecContext(
+		ctx, "UPDATE outbox SET created_at = now() - interval '2 hours' WHERE comment_id = $1", pending.ID,
+	)
+	require.NoError(s.T(), err)
+
+	n, err := s.db.PruneOutbox(ctx, time.Hour)
+	require.NoError(s.T(), err)
+	assert.Positive(s.T(), n)
+
+	events, err := s.db.EventsAfter(ctx, slug, "00000000-0000-0000-0000-000000000000", 10)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), events, 1)
+	assert.Equal(s.T(), pending.ID, events[0].CommentID)
+	assert.NotEqual(s.T(), old.ID, events[0].CommentID)
+}
+
+func (s *CommentTestSuite) TestListener() {
+	ctx, cancel := context.WithCancel(tenantContext())
+	defer cancel()
+
+	sink := &recordingSink{}
+	listener := db.NewListener(s.db, sink, slog.Default())
+	listener.MinReconnect = 10 * time.Millisecond
+	go func() {
+		assert.NoError(s.T(), listener.Run(ctx))
+	}()
+
+	listening := func() bool {
+		var n int
+		err := s.db.Client.GetContext(ctx, &n, "SELECT count(*) FROM pg_stat_activity WHERE query LIKE 'LISTEN%'")
+		return err == nil && n > 0
+	}
+	require.Eventually(s.T(), listening, 5*time.Second, 10*time.Millisecond)
+
+	received := func(n int) func() bool {
+		return func() bool {
+			sink.mu.Lock()
+			defer sink.mu.Unlock()
+			return len(sink.events) == n
+		}
+	}
+
+	first, err := s.db.CreateComment(ctx, comment.Comment{
+		ID:     s.getUUID(),
+		Slug:   "listener-slug",
+		Body:   "announced",
+		Author: "listener author",
+	})
+	require.NoError(s.T(), err)
+	require.Eventually(s.T(), received(1), 5*time.Second, 10*time.Millisecond)
+	assert.Equal(s.T(), first.ID, sink.events[0].CommentID)
+
+	// Events recorded while the connection is down are caught up on after the
+	// listener reconnects.
+	_, err = s.db.Client.ExecContext(
+		ctx,
+		"SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE query LIKE 'LISTEN%'",
+	)
+	require.NoError(s.T(), err)
+
+	second, err := s.db.CreateComment(ctx, comment.Comment{
+		ID:     s.getUUID(),
+		Slug:   "listener-slug",
+		Body:   "missed",
+		Author: "listener author",
+	})
+	require.NoError(s.T(), err)
+	require.Eventually(s.T(), received(2), 5*time.Second, 10*time.Millisecond)
+	assert.Equal(s.T(), second.ID, sink.events[1].CommentID)
+
+	// So are events that commit after newer ones were seen, as long as their
+	// transaction started within the catch-up window.
+	_, err = s.db.Client.ExecContext(
+		ctx,
+		"SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE query LIKE 'LISTEN%'",
+	)
+	require.NoError(s.T(), err)
+
+	late := comment.Event{ID: s.getUUID(), Type: comment.CommentCreated, CommentID: s.getUUID()}
+	payload, err := json.Marshal(late)
+	require.NoError(s.T(), err)
+	_, err = s.db.Client.ExecContext(
+		ctx,
+		"INSERT INTO outbox (event_id, tenant_id, slug, event_type, comment_id, payload, created_at) "+
+			"SELECT $1, $2, 'listener-slug', $3, $4, $5, created_at - interval '1 millisecond' "+
+			"FROM outbox WHERE comment_id = $6",
+		late.ID,
+		comment.DefaultTenant,
+		string(late.Type),
+		late.CommentID,
+		string(payload),
+		second.ID,
+	)
+	require.NoError(s.T(), err)
+	require.Eventually(s.T(), received(3), 5*time.Second, 10*time.Millisecond)
+	assert.Equal(s.T(), late.ID, sink.events[2].ID)
 }
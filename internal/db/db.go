@@ -3,38 +3,38 @@ package db
 import (
 	"context"
 	"fmt"
+	"io/fs"
 	"log/slog"
-	"os"
 
+	"github.com/azdanov/go-rest-api/internal/config"
 	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type Database struct {
 	Client *sqlx.DB
 	logger *slog.Logger
+	// connStr is kept for connections outside the pool, such as the one a
+	// Listener holds.
+	connStr    string
+	migrations fs.FS
+	// queryDuration is exported through Collectors.
+	queryDuration *prometheus.HistogramVec
 }
 
-func CreateConnectionString() string {
-	return fmt.Sprintf(
-		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_PORT"),
-		os.Getenv("DB_USERNAME"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_NAME"),
-		os.Getenv("DB_SSL_MODE"),
-	)
-}
-
-func NewDatabase(logger *slog.Logger, connStr string) (*Database, error) {
+func NewDatabase(logger *slog.Logger, cfg config.Database) (*Database, error) {
+	connStr := cfg.ConnectionString()
 	db, err := sqlx.Connect("postgres", connStr)
 	if err != nil {
 		return &Database{}, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
 	return &Database{
-		Client: db,
-		logger: logger,
+		Client:        db,
+		logger:        logger,
+		connStr:       connStr,
+		migrations:    MigrationFiles(cfg.MigrationsDir),
+		queryDuration: newQueryDuration(),
 	}, nil
 }
 
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/lib/pq"
+)
+
+// translateError maps driver level errors onto the comment package's
+// sentinels: a missing row becomes ErrCommentNotFound and errors that
+// indicate the database cannot be reached right now are marked with
+// ErrUnavailable. Everything else is returned unchanged.
+func translateError(err error) error {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return comment.ErrCommentNotFound
+	case isTransient(err):
+		return fmt.Errorf("%w: %w", comment.ErrUnavailable, err)
+	default:
+		return err
+	}
+}
+
+func isTransient(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", "53": // connection_exception, insufficient_resources
+			return true
+		}
+		switch pqErr.Code {
+		case "57P01", "57P02", "57P03": // admin_shutdown, crash_shutdown, cannot_connect_now
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,36 @@
+package db
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// poolName labels the connection pool statistics. It names the pool, not the
+// database it connects to, which may be set through a URL.
+const poolName = "comments"
+
+func newQueryDuration() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Duration of database operations, including the wait for a connection.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+}
+
+// Collectors returns the metrics of the database: the connection pool
+// statistics and the duration of its operations.
+func (d *Database) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		collectors.NewDBStatsCollector(d.Client.DB, poolName),
+		d.queryDuration,
+	}
+}
+
+// observe times the operation until the returned timer is stopped. It is used
+// as defer d.observe("name").ObserveDuration().
+func (d *Database) observe(operation string) *prometheus.Timer {
+	if d.queryDuration == nil {
+		return prometheus.NewTimer(nil)
+	}
+	return prometheus.NewTimer(d.queryDuration.WithLabelValues(operation))
+}
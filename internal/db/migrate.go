@@ -1,25 +1,143 @@
 package db
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"io/fs"
+	"os"
 
+	"github.com/azdanov/go-rest-api/migrations"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/lib/pq"
 )
 
-func (db *Database) Migrate(path string) error {
-	db.logger.Info("migrating database...")
+// migrationsTable is where golang-migrate records the schema version.
+const migrationsTable = "schema_migrations"
+
+var (
+	ErrSchemaDirty  = errors.New("database schema is dirty")
+	ErrSchemaTooNew = errors.New("database schema is newer than the binary")
+)
+
+// MigrationStatus compares the schema version recorded in the database with
+// the migrations the binary knows.
+type MigrationStatus struct {
+	// Version is the last applied migration, or zero if none was applied.
+	Version uint
+	// Dirty is set when a migration failed halfway. The schema has to be
+	// repaired by hand and the version forced before migrating again.
+	Dirty bool
+	// Latest is the highest migration the binary knows.
+	Latest uint
+	// Pending lists the known migrations that are not applied yet.
+	Pending []migrations.Migration
+}
+
+// Check reports a schema that the binary cannot safely work with: a dirty one,
+// or one that a newer binary has migrated beyond the migrations it knows.
+func (s MigrationStatus) Check() error {
+	switch {
+	case s.Dirty:
+		return fmt.Errorf(
+			"%w: migration %d failed, repair the schema and force its version before migrating again",
+			ErrSchemaDirty, s.Version,
+		)
+	case s.Version > s.Latest:
+		return fmt.Errorf(
+			"%w: schema version %d, the latest migration the binary knows is %d",
+			ErrSchemaTooNew, s.Version, s.Latest,
+		)
+	default:
+		return nil
+	}
+}
+
+// MigrationFiles returns the migrations in dir, or the ones embedded in the
+// binary if dir is empty. Loading them from a directory is meant for working
+// on new migrations without rebuilding.
+func MigrationFiles(dir string) fs.FS {
+	if dir == "" {
+		return migrations.FS
+	}
+	return os.DirFS(dir)
+}
+
+// Migrations returns the migrations the database is migrated with.
+func (db *Database) Migrations() ([]migrations.Migration, error) {
+	return migrations.List(db.migrations)
+}
+
+// migrator returns a migration instance for the migrations of db. It holds a
+// connection of the pool until it is closed.
+func (db *Database) migrator() (*migrate.Migrate, error) {
+	src, err := iofs.New(db.migrations, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	ctx := context.Background()
+	conn, err := db.Client.Conn(ctx)
+	if err != nil {
+		_ = src.Close()
+		return nil, fmt.Errorf("failed to get connection: %w", translateError(err))
+	}
+
+	// Unlike WithInstance, WithConnection leaves the pool open when the
+	// instance is closed.
+	d, err := postgres.WithConnection(ctx, conn, &postgres.Config{})
+	if err != nil {
+		_ = src.Close()
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to create postgres driver: %w", err)
+	}
 
-	d, err := postgres.WithInstance(db.Client.DB, &postgres.Config{})
+	m, err := migrate.NewWithInstance("iofs", src, "postgres", d)
 	if err != nil {
-		return fmt.Errorf("failed to create postgres driver: %w", err)
+		_ = src.Close()
+		_ = d.Close()
+		return nil, fmt.Errorf("failed to create new migration instance: %w", err)
 	}
 
-	m, err := migrate.NewWithDatabaseInstance("file://"+path, "postgres", d)
+	return m, nil
+}
+
+// closeMigrator returns the connection of m to the pool.
+func closeMigrator(m *migrate.Migrate) {
+	_, _ = m.Close()
+}
+
+// checkedMigrator is migrator for changing the schema, which is refused if
+// the schema fails MigrationStatus.Check.
+func (db *Database) checkedMigrator() (*migrate.Migrate, error) {
+	m, err := db.migrator()
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := db.status(m)
+	if err == nil {
+		err = status.Check()
+	}
+	if err != nil {
+		closeMigrator(m)
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (db *Database) Migrate() error {
+	db.logger.Info("migrating database...")
+
+	m, err := db.checkedMigrator()
 	if err != nil {
-		return fmt.Errorf("failed to create new migration instance: %w", err)
+		return err
 	}
+	defer closeMigrator(m)
 
 	if err = m.Up(); err != nil {
 		if errors.Is(err, migrate.ErrNoChange) {
@@ -31,3 +149,122 @@ func (db *Database) Migrate(path string) error {
 
 	return nil
 }
+
+// MigrateDown rolls back the given number of applied migrations.
+func (db *Database) MigrateDown(steps int) error {
+	if steps <= 0 {
+		return fmt.Errorf("invalid number of steps %d: must be positive", steps)
+	}
+
+	m, err := db.checkedMigrator()
+	if err != nil {
+		return err
+	}
+	defer closeMigrator(m)
+
+	if err = m.Steps(-steps); err != nil {
+		return fmt.Errorf("failed to roll back migrations: %w", err)
+	}
+
+	return nil
+}
+
+// MigrateTo applies or rolls back migrations until the schema is at version.
+func (db *Database) MigrateTo(version uint) error {
+	m, err := db.checkedMigrator()
+	if err != nil {
+		return err
+	}
+	defer closeMigrator(m)
+
+	if err = m.Migrate(version); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("failed to migrate to version %d: %w", version, err)
+	}
+
+	return nil
+}
+
+// Force records version as the current schema version and clears the dirty
+// flag without running any migration. Use it after repairing a failed
+// migration by hand; -1 records that no migration is applied.
+func (db *Database) Force(version int) error {
+	m, err := db.migrator()
+	if err != nil {
+		return err
+	}
+	defer closeMigrator(m)
+
+	if err = m.Force(version); err != nil {
+		return fmt.Errorf("failed to force version %d: %w", version, err)
+	}
+
+	return nil
+}
+
+func (db *Database) MigrationStatus() (MigrationStatus, error) {
+	m, err := db.migrator()
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+	defer closeMigrator(m)
+
+	return db.status(m)
+}
+
+// CheckSchema is run before serving. It returns the migration status, and an
+// error if the schema fails MigrationStatus.Check.
+func (db *Database) CheckSchema() (MigrationStatus, error) {
+	status, err := db.MigrationStatus()
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+	return status, status.Check()
+}
+
+// SchemaStatus is MigrationStatus for frequent checks such as the readiness
+// probe. It reads the version table with ctx instead of going through the
+// migrator, which waits for the migration lock while a migration runs.
+func (db *Database) SchemaStatus(ctx context.Context) (MigrationStatus, error) {
+	var row struct {
+		Version uint
+		Dirty   bool
+	}
+	err := db.Client.GetContext(ctx, &row, "SELECT version, dirty FROM "+migrationsTable+" LIMIT 1")
+	var pqErr *pq.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// No migration was applied yet.
+	case errors.As(err, &pqErr) && pqErr.Code == "42P01": // undefined_table
+		// Nor was the version table created.
+	case err != nil:
+		return MigrationStatus{}, fmt.Errorf("failed to read schema version: %w", translateError(err))
+	}
+
+	return db.statusAt(row.Version, row.Dirty)
+}
+
+func (db *Database) status(m *migrate.Migrate) (MigrationStatus, error) {
+	version, dirty, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return MigrationStatus{}, fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	return db.statusAt(version, dirty)
+}
+
+// statusAt compares the schema version with the known migrations.
+func (db *Database) statusAt(version uint, dirty bool) (MigrationStatus, error) {
+	known, err := db.Migrations()
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+
+	status := MigrationStatus{Version: version, Dirty: dirty}
+	for _, k := range known {
+		status.Latest = max(status.Latest, k.Version)
+		if k.Version > version {
+			status.Pending = append(status.Pending, k)
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/jmoiron/sqlx"
+)
+
+// ModerateComment changes the status of a comment, records the decision in
+// the moderation log and records a CommentUpdated event in the outbox, all in
+// a single transaction. The status change only applies if the comment still
+// has the status the decision was based on.
+func (d *Database) ModerateComment(ctx context.Context, m comment.Moderation) error {
+	defer d.observe("moderate_comment").ObserveDuration()
+	return d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		old, err := lockComment(ctx, tx, m.CommentID, false)
+		if err != nil {
+			return err
+		}
+		// The comment was moderated concurrently.
+		if comment.Status(old.Status) != m.From {
+			return comment.ErrVersionConflict
+		}
+
+		var cr CommentRow
+		err = tx.GetContext(
+			ctx,
+			&cr,
+			"UPDATE comments SET status = $1, updated_at = now(), version = version + 1 "+
+				"WHERE id = $2 RETURNING "+commentColumns,
+			string(m.To),
+			m.CommentID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update comment status: %w", translateError(err))
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO comment_moderations (comment_id, from_status, to_status, reason, moderator)
+			VALUES ($1, $2, $3, $4, $5)`,
+			m.CommentID,
+			string(m.From),
+			string(m.To),
+			sql.NullString{String: m.Reason, Valid: m.Reason != ""},
+			sql.NullString{String: m.Moderator, Valid: m.Moderator != ""},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert comment moderation: %w", translateError(err))
+		}
+
+		before, moderated := convertRowToComment(old), convertRowToComment(cr)
+		return insertOutbox(ctx, tx, comment.CommentUpdated, &before, &moderated)
+	})
+}
@@ -0,0 +1,243 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// eventsChannel is the channel comment events are announced on.
+const eventsChannel = "comment_events"
+
+const (
+	defaultMinReconnect = 100 * time.Millisecond
+	defaultMaxReconnect = 30 * time.Second
+	// listenerPingInterval is how long the listener waits for a notification
+	// before it checks that its connection is still alive.
+	listenerPingInterval = 90 * time.Second
+	catchUpPageSize      = 100
+	// defaultCatchUpWindow is how long the transaction of a write may take.
+	defaultCatchUpWindow = time.Minute
+)
+
+// notifyEvent announces the event with the given ID to every Listener. Postgres
+// delivers the notification when tx commits and drops it if tx rolls back.
+// Only the ID is sent, because notification payloads are limited to 8000
+// bytes; listeners read the event itself from the outbox.
+func notifyEvent(ctx context.Context, tx *sqlx.Tx, id string) error {
+	if _, err := tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", eventsChannel, id); err != nil {
+		return fmt.Errorf("failed to notify event: %w", translateError(err))
+	}
+	return nil
+}
+
+type OutboxEventRow struct {
+	ID        int64
+	EventID   string `db:"event_id"`
+	Payload   []byte
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// Listener receives the events announced by every replica and hands them to a
+// sink, usually the in-process dispatcher that feeds live streams. Where the
+// OutboxRelay delivers every event once across all replicas, a Listener on
+// each replica sees all of them. After its connection is lost, the Listener
+// reconnects and catches up from the outbox.
+//
+// Events are recorded with the start time of their transaction, and
+// transactions commit in any order, so an event may be committed after a
+// newer one was seen. Catching up therefore starts CatchUpWindow before the
+// newest event seen and skips the events seen already. An event whose
+// transaction took longer than CatchUpWindow may be missed.
+type Listener struct {
+	db     *Database
+	sink   comment.EventPublisher
+	logger *slog.Logger
+
+	MinReconnect  time.Duration
+	MaxReconnect  time.Duration
+	CatchUpWindow time.Duration
+}
+
+func NewListener(d *Database, sink comment.EventPublisher, logger *slog.Logger) *Listener {
+	return &Listener{
+		db:            d,
+		sink:          sink,
+		logger:        logger,
+		MinReconnect:  defaultMinReconnect,
+		MaxReconnect:  defaultMaxReconnect,
+		CatchUpWindow: defaultCatchUpWindow,
+	}
+}
+
+// seenEvents remembers the events delivered within the catch-up window, so
+// that catching up does not deliver them again.
+type seenEvents struct {
+	ids map[string]time.Time
+	// since is when the listener started, which catching up never goes back
+	// beyond, and latest is when the newest event seen was recorded.
+	since  time.Time
+	latest time.Time
+}
+
+// add records the event and reports whether it is new.
+func (s *seenEvents) add(id string, recorded time.Time) bool {
+	if _, ok := s.ids[id]; ok {
+		return false
+	}
+	s.ids[id] = recorded
+	s.latest = later(s.latest, recorded)
+	return true
+}
+
+// forget drops the events recorded before t.
+func (s *seenEvents) forget(t time.Time) {
+	for id, recorded := range s.ids {
+		if recorded.Before(t) {
+			delete(s.ids, id)
+		}
+	}
+}
+
+func later(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// Run listens until ctx is done. Only events announced after Run starts are
+// delivered.
+func (l *Listener) Run(ctx context.Context) error {
+	var start time.Time
+	if err := l.db.Client.GetContext(ctx, &start, "SELECT now()"); err != nil {
+		return fmt.Errorf("failed to read database time: %w", translateError(err))
+	}
+	seen := &seenEvents{ids: map[string]time.Time{}, since: start, latest: start}
+
+	pl := pq.NewListener(l.db.connStr, l.MinReconnect, l.MaxReconnect, func(ev pq.ListenerEventType, err error) {
+		switch ev {
+		case pq.ListenerEventConnected:
+			l.logger.InfoContext(ctx, "listening for comment events")
+		case pq.ListenerEventDisconnected:
+			l.logger.WarnContext(ctx, "lost comment events connection", slog.Any("error", err))
+		case pq.ListenerEventReconnected:
+			l.logger.InfoContext(ctx, "reconnected comment events connection")
+		case pq.ListenerEventConnectionAttemptFailed:
+			l.logger.WarnContext(ctx, "failed to connect for comment events", slog.Any("error", err))
+		}
+	})
+	defer func() { _ = pl.Close() }()
+
+	if err := pl.Listen(eventsChannel); err != nil {
+		return fmt.Errorf("failed to listen for comment events: %w", err)
+	}
+
+	for {
+		select {
+		case n := <-pl.Notify:
+			// A nil notification follows a reconnect; anything announced while
+			// the connection was down is read from the outbox.
+			if n == nil {
+				l.catchUp(ctx, seen)
+				continue
+			}
+			l.deliver(ctx, n.Extra, seen)
+		case <-time.After(listenerPingInterval):
+			seen.forget(seen.latest.Add(-l.CatchUpWindow))
+			if err := pl.Ping(); err != nil {
+				l.logger.WarnContext(ctx, "failed to ping comment events connection", slog.Any("error", err))
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// deliver reads the announced event from the outbox and hands it to the sink,
+// unless it was seen already.
+func (l *Listener) deliver(ctx context.Context, id string, seen *seenEvents) {
+	var row OutboxEventRow
+	err := l.db.Client.GetContext(
+		ctx,
+		&row,
+		"SELECT id, event_id, payload, created_at FROM outbox WHERE event_id = $1",
+		id,
+	)
+	if err != nil {
+		l.logger.ErrorContext(
+			ctx,
+			"failed to read announced event",
+			slog.String("event_id", id),
+			slog.Any("error", err),
+		)
+		return
+	}
+
+	l.publishRow(ctx, row, seen)
+}
+
+// catchUp delivers the events recorded since CatchUpWindow before the newest
+// event seen that were not seen yet.
+func (l *Listener) catchUp(ctx context.Context, seen *seenEvents) {
+	after := OutboxEventRow{CreatedAt: later(seen.since, seen.latest.Add(-l.CatchUpWindow))}
+	for {
+		var rows []OutboxEventRow
+		err := l.db.Client.SelectContext(
+			ctx,
+			&rows,
+			`SELECT id, event_id, payload, created_at FROM outbox
+			WHERE (created_at, id) > ($1, $2)
+			ORDER BY created_at, id
+			LIMIT $3`,
+			after.CreatedAt,
+			after.ID,
+			catchUpPageSize,
+		)
+		if err != nil {
+			l.logger.ErrorContext(ctx, "failed to catch up on events", slog.Any("error", err))
+			return
+		}
+
+		for _, row := range rows {
+			l.publishRow(ctx, row, seen)
+			after = row
+		}
+
+		if len(rows) < catchUpPageSize {
+			seen.forget(seen.latest.Add(-l.CatchUpWindow))
+			return
+		}
+	}
+}
+
+// publishRow hands the event in row to the sink if it was not seen yet.
+func (l *Listener) publishRow(ctx context.Context, row OutboxEventRow, seen *seenEvents) {
+	if !seen.add(row.EventID, row.CreatedAt) {
+		return
+	}
+
+	var e comment.Event
+	if err := json.Unmarshal(row.Payload, &e); err != nil {
+		l.logger.ErrorContext(
+			ctx,
+			"failed to decode event",
+			slog.String("event_id", row.EventID),
+			slog.Any("error", err),
+		)
+		return
+	}
+	l.publish(ctx, e)
+}
+
+func (l *Listener) publish(ctx context.Context, e comment.Event) {
+	if err := l.sink.Publish(ctx, e); err != nil {
+		l.logger.ErrorContext(ctx, "failed to publish event", slog.String("event_id", e.ID), slog.Any("error", err))
+	}
+}
@@ -0,0 +1,291 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultRelayInterval  = time.Second
+	defaultRelayBatchSize = 100
+	// defaultRelayMaxAttempts bounds how long an event that the sink keeps
+	// failing holds up the events behind it.
+	defaultRelayMaxAttempts = 10
+	defaultPruneInterval    = time.Hour
+	// defaultOutboxRetention is how long stream clients can catch up.
+	defaultOutboxRetention = 7 * 24 * time.Hour
+)
+
+type OutboxRow struct {
+	ID      int64
+	Payload []byte
+}
+
+// insertOutbox records the event describing a change made in tx, so that the
+// event is stored if and only if the change is.
+func insertOutbox(ctx context.Context, tx *sqlx.Tx, t comment.EventType, before, after *comment.Comment) error {
+	e, err := comment.NewEvent(ctx, t, before, after)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO outbox (event_id, tenant_id, slug, event_type, comment_id, payload) "+
+			"VALUES ($1, $2, $3, $4, $5, $6)",
+		e.ID,
+		e.TenantID,
+		cmp.Or(after, before).Slug,
+		string(e.Type),
+		e.CommentID,
+		string(payload),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert outbox event: %w", translateError(err))
+	}
+
+	return notifyEvent(ctx, tx, e.ID)
+}
+
+// EventsAfter reads past events from the outbox, which doubles as the event
+// log that stream clients catch up from. The outbox is shared by all tenants,
+// since the relay reads it on their behalf, so the tenant is filtered for
+// explicitly. Events older than the retention of the relay are gone.
+func (d *Database) EventsAfter(ctx context.Context, slug, id string, limit int) ([]comment.Event, error) {
+	defer d.observe("events_after").ObserveDuration()
+
+	tenant, ok := comment.TenantFromContext(ctx)
+	if !ok {
+		return nil, comment.ErrTenantRequired
+	}
+
+	var payloads [][]byte
+	err := d.Client.SelectContext(
+		ctx,
+		&payloads,
+		`SELECT payload FROM outbox
+		WHERE tenant_id = $1 AND slug = $2 AND event_id > $3
+		ORDER BY event_id
+		LIMIT $4`,
+		tenant,
+		slug,
+		id,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events: %w", translateError(err))
+	}
+
+	events := make([]comment.Event, 0, len(payloads))
+	for _, payload := range payloads {
+		var e comment.Event
+		if err = json.Unmarshal(payload, &e); err != nil {
+			return nil, fmt.Errorf("failed to decode event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// OutboxRelay hands the events recorded in the outbox to a sink and marks
+// them delivered once the sink has handled them. The sink must be synchronous,
+// such as a comment.Dispatcher from NewDispatcher: an event it only queues is
+// lost if the process stops before handling it. Any number of relays may run
+// against the same database: each batch is locked with SKIP LOCKED, so relays
+// never pick up the same event at the same time. Events are delivered at
+// least once, in order within a batch; a sink that fails stops the batch and
+// the rest is retried on the next poll. Until then the failing event holds up
+// the events of every tenant behind it, so after MaxAttempts failures, or at
+// once if its payload cannot be decoded, it is set aside as dead together
+// with its last error.
+type OutboxRelay struct {
+	db     *Database
+	sink   comment.EventPublisher
+	logger *slog.Logger
+
+	// Interval is how often the outbox is polled when it is empty.
+	Interval time.Duration
+	// BatchSize is the number of events locked per poll.
+	BatchSize int
+	// MaxAttempts is the number of times the sink may fail an event before
+	// it is dead.
+	MaxAttempts int
+	// Retention is how long delivered events are kept for stream clients to
+	// catch up from. Older ones are pruned every PruneInterval.
+	Retention     time.Duration
+	PruneInterval time.Duration
+}
+
+func NewOutboxRelay(d *Database, sink comment.EventPublisher, logger *slog.Logger) *OutboxRelay {
+	return &OutboxRelay{
+		db:            d,
+		sink:          sink,
+		logger:        logger,
+		Interval:      defaultRelayInterval,
+		BatchSize:     defaultRelayBatchSize,
+		MaxAttempts:   defaultRelayMaxAttempts,
+		Retention:     defaultOutboxRetention,
+		PruneInterval: defaultPruneInterval,
+	}
+}
+
+// Run relays events and prunes old ones until ctx is done. A full batch is
+// followed immediately by the next one. A batch that has begun is finished
+// even if ctx is cancelled meanwhile, so that its events are marked delivered
+// rather than handed to the sink again after a restart.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	var pruned time.Time
+	for {
+		if time.Since(pruned) >= r.PruneInterval {
+			if _, err := r.db.PruneOutbox(ctx, r.Retention); err != nil {
+				r.logger.ErrorContext(ctx, "failed to prune outbox", slog.Any("error", err))
+			}
+			pruned = time.Now()
+		}
+
+		n, err := r.RelayBatch(context.WithoutCancel(ctx))
+		if err != nil {
+			r.logger.ErrorContext(ctx, "failed to relay outbox events", slog.Any("error", err))
+		}
+		if n == r.BatchSize && ctx.Err() == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.Interval):
+		}
+	}
+}
+
+// RelayBatch delivers one batch of pending events and returns how many were
+// delivered. An event counts as delivered when the sink returns nil for it.
+func (r *OutboxRelay) RelayBatch(ctx context.Context) (int, error) {
+	tx, err := r.db.Client.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", translateError(err))
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var rows []OutboxRow
+	err = tx.SelectContext(
+		ctx,
+		&rows,
+		"SELECT id, payload FROM outbox WHERE delivered_at IS NULL AND dead_at IS NULL "+
+			"ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED",
+		r.BatchSize,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock outbox events: %w", translateError(err))
+	}
+
+	delivered := make([]int64, 0, len(rows))
+	var sinkErr error
+	for _, row := range rows {
+		var e comment.Event
+		if err = json.Unmarshal(row.Payload, &e); err != nil {
+			// A payload that cannot be decoded never will be.
+			cause := fmt.Errorf("failed to decode event: %w", err)
+			if _, err = r.recordFailure(ctx, tx, row.ID, cause, true); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if sinkErr = r.sink.Publish(ctx, e); sinkErr != nil {
+			dead, failErr := r.recordFailure(ctx, tx, row.ID, sinkErr, false)
+			if failErr != nil {
+				return 0, failErr
+			}
+			if !dead {
+				break
+			}
+			sinkErr = nil
+			continue
+		}
+		delivered = append(delivered, row.ID)
+	}
+
+	if len(delivered) > 0 {
+		_, err = tx.ExecContext(
+			ctx,
+			"UPDATE outbox SET delivered_at = now() WHERE id = ANY($1)",
+			pq.Array(delivered),
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to mark outbox events delivered: %w", translateError(err))
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", translateError(err))
+	}
+
+	if sinkErr != nil {
+		return len(delivered), fmt.Errorf("failed to publish outbox event: %w", sinkErr)
+	}
+	return len(delivered), nil
+}
+
+// recordFailure counts a failed attempt to relay the event with the given ID
+// and reports whether the event is dead, which it is once MaxAttempts is
+// reached or if final is set.
+func (r *OutboxRelay) recordFailure(ctx context.Context, tx *sqlx.Tx, id int64, cause error, final bool) (bool, error) {
+	var dead bool
+	err := tx.GetContext(
+		ctx,
+		&dead,
+		`UPDATE outbox
+		SET attempts = attempts + 1, last_error = $2,
+			dead_at = CASE WHEN $3 OR attempts + 1 >= $4 THEN now() END
+		WHERE id = $1
+		RETURNING dead_at IS NOT NULL`,
+		id,
+		cause.Error(),
+		final,
+		r.MaxAttempts,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to record outbox event failure: %w", translateError(err))
+	}
+
+	if dead {
+		r.logger.ErrorContext(ctx, "gave up relaying outbox event", slog.Int64("id", id), slog.Any("error", cause))
+	}
+	return dead, nil
+}
+
+// PruneOutbox deletes the events that were delivered longer than retention
+// ago and returns how many it deleted. Undelivered events are kept however
+// old they are, and so are dead ones, for an operator to look into.
+func (d *Database) PruneOutbox(ctx context.Context, retention time.Duration) (int64, error) {
+	defer d.observe("prune_outbox").ObserveDuration()
+
+	res, err := d.Client.ExecContext(
+		ctx,
+		"DELETE FROM outbox WHERE delivered_at < now() - $1 * interval '1 millisecond'",
+		retention.Milliseconds(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune outbox: %w", translateError(err))
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune outbox: %w", translateError(err))
+	}
+	return n, nil
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type ReactionSummaryRow struct {
+	CommentID   string `db:"comment_id"`
+	Kind        string
+	Count       int
+	ReactedByMe bool `db:"reacted_by_me"`
+}
+
+func (d *Database) AddReaction(ctx context.Context, r comment.Reaction) error {
+	defer d.observe("add_reaction").ObserveDuration()
+	return d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO comment_reactions (comment_id, user_id, kind) VALUES ($1, $2, $3)
+			ON CONFLICT (comment_id, user_id, kind) DO NOTHING`,
+			r.CommentID,
+			r.UserID,
+			string(r.Kind),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to add reaction: %w", translateError(err))
+		}
+		return nil
+	})
+}
+
+func (d *Database) RemoveReaction(ctx context.Context, r comment.Reaction) error {
+	defer d.observe("remove_reaction").ObserveDuration()
+	return d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(
+			ctx,
+			"DELETE FROM comment_reactions WHERE comment_id = $1 AND user_id = $2 AND kind = $3",
+			r.CommentID,
+			r.UserID,
+			string(r.Kind),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to remove reaction: %w", translateError(err))
+		}
+		return nil
+	})
+}
+
+// SummarizeReactions counts the reactions of all given comments in one
+// grouped query.
+func (d *Database) SummarizeReactions(
+	ctx context.Context,
+	commentIDs []string,
+	userID string,
+) (map[string][]comment.ReactionSummary, error) {
+	defer d.observe("summarize_reactions").ObserveDuration()
+
+	var rows []ReactionSummaryRow
+	err := d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		err := tx.SelectContext(
+			ctx,
+			&rows,
+			`SELECT comment_id, kind, count(*) AS count, bool_or(user_id = $2) AS reacted_by_me
+			FROM comment_reactions
+			WHERE comment_id = ANY($1::uuid[])
+			GROUP BY comment_id, kind
+			ORDER BY comment_id, kind`,
+			pq.Array(commentIDs),
+			userID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to summarize reactions: %w", translateError(err))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make(map[string][]comment.ReactionSummary, len(commentIDs))
+	for _, rr := range rows {
+		summaries[rr.CommentID] = append(summaries[rr.CommentID], comment.ReactionSummary{
+			Kind:        comment.ReactionKind(rr.Kind),
+			Count:       rr.Count,
+			ReactedByMe: rr.ReactedByMe,
+		})
+	}
+
+	return summaries, nil
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/jmoiron/sqlx"
+)
+
+type RevisionRow struct {
+	CommentID string `db:"comment_id"`
+	Revision  int
+	Slug      sql.NullString
+	Body      sql.NullString
+	Author    sql.NullString
+	EditedBy  sql.NullString `db:"edited_by"`
+	EditedAt  time.Time      `db:"edited_at"`
+}
+
+func convertRowToRevision(rr RevisionRow) comment.Revision {
+	return comment.Revision{
+		CommentID: rr.CommentID,
+		Number:    rr.Revision,
+		Slug:      rr.Slug.String,
+		Body:      rr.Body.String,
+		Author:    rr.Author.String,
+		EditedBy:  rr.EditedBy.String,
+		EditedAt:  rr.EditedAt,
+	}
+}
+
+func editorFromContext(ctx context.Context) sql.NullString {
+	actor, ok := comment.ActorFromContext(ctx)
+	return sql.NullString{String: actor.ID, Valid: ok && actor.ID != ""}
+}
+
+// insertRevision stores old as the next revision of its comment. The caller
+// must hold a row lock on the comment so that revision numbers stay dense.
+func insertRevision(ctx context.Context, tx *sqlx.Tx, old CommentRow, editedBy sql.NullString) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO comment_revisions (comment_id, revision, slug, body, author, edited_by)
+		SELECT $1, COALESCE(MAX(revision), 0) + 1, $2, $3, $4, $5
+		FROM comment_revisions
+		WHERE comment_id = $1`,
+		old.ID,
+		old.Slug,
+		old.Body,
+		old.Author,
+		editedBy,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert comment revision: %w", translateError(err))
+	}
+
+	return nil
+}
+
+func (d *Database) ListRevisions(ctx context.Context, commentID string) ([]comment.Revision, error) {
+	defer d.observe("list_revisions").ObserveDuration()
+
+	var rows []RevisionRow
+	err := d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		err := tx.SelectContext(
+			ctx,
+			&rows,
+			`SELECT comment_id, revision, slug, body, author, edited_by, edited_at
+			FROM comment_revisions
+			WHERE comment_id = $1
+			ORDER BY revision`,
+			commentID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to list comment revisions: %w", translateError(err))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([]comment.Revision, 0, len(rows))
+	for _, rr := range rows {
+		revisions = append(revisions, convertRowToRevision(rr))
+	}
+
+	return revisions, nil
+}
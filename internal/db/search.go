@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// searchConfig is the text search configuration used by the body_tsv column.
+	searchConfig = "english"
+	// headlineOptions configures the snippets returned with search results.
+	headlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxFragments=2"
+	// escapedBody is the body with HTML escaped, so that the <mark> tags are
+	// the only markup in a snippet.
+	escapedBody = "replace(replace(replace(coalesce(body, ''), '&', '&amp;'), '<', '&lt;'), '>', '&gt;')"
+)
+
+type SearchRow struct {
+	CommentRow
+
+	Rank    float64
+	Snippet string
+}
+
+// Search matches the web search style query against the body_tsv column and
+// returns the results ordered by rank, best match first.
+func (d *Database) Search(ctx context.Context, p comment.SearchParams) ([]comment.SearchResult, error) {
+	defer d.observe("search").ObserveDuration()
+
+	var c conditions
+	query := c.arg(p.Query)
+	c.where("body_tsv @@ q")
+	c.where("deleted_at IS NULL")
+	if p.Slug != "" {
+		c.where("slug = " + c.arg(p.Slug))
+	}
+	if p.Author != "" {
+		c.where("author = " + c.arg(p.Author))
+	}
+	if p.Status != "" {
+		c.where("status = " + c.arg(string(p.Status)))
+	}
+
+	stmt := "SELECT " + commentColumns + ", ts_rank(body_tsv, q) AS rank, " +
+		"ts_headline('" + searchConfig + "', " + escapedBody + ", q, '" + headlineOptions + "') AS snippet " +
+		"FROM comments, websearch_to_tsquery('" + searchConfig + "', " + query + ") AS q" + c.String() +
+		" ORDER BY rank DESC, id DESC LIMIT " + c.arg(p.Limit) + " OFFSET " + c.arg(p.Offset)
+
+	var rows []SearchRow
+	err := d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		if err := tx.SelectContext(ctx, &rows, stmt, c.args...); err != nil {
+			return fmt.Errorf("failed to search comments: %w", translateError(err))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]comment.SearchResult, 0, len(rows))
+	for _, sr := range rows {
+		results = append(results, comment.SearchResult{
+			Comment: convertRowToComment(sr.CommentRow),
+			Rank:    sr.Rank,
+			Snippet: sr.Snippet,
+		})
+	}
+
+	return results, nil
+}
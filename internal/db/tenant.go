@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/jmoiron/sqlx"
+)
+
+// tenantRole is the role that tenant transactions run as. It owns no tables
+// and is not a superuser, so the row-level security policies of the comment
+// tables always apply to it, whichever role the pool connects as.
+const tenantRole = "comments_tenant"
+
+// inTenant runs fn in a transaction scoped to the tenant in ctx and commits it
+// if fn succeeds. The row-level security policies only let the transaction see
+// and write rows of that tenant, so a query that forgets to filter by tenant
+// still cannot touch another tenant's comments.
+func (d *Database) inTenant(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	tenant, ok := comment.TenantFromContext(ctx)
+	if !ok {
+		return comment.ErrTenantRequired
+	}
+
+	tx, err := d.Client.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", translateError(err))
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Both settings are local to the transaction, so they are gone when the
+	// connection returns to the pool.
+	_, err = tx.ExecContext(
+		ctx,
+		"SELECT set_config('role', $1, true), set_config('app.tenant_id', $2, true)",
+		tenantRole,
+		tenant,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to scope transaction to tenant: %w", translateError(err))
+	}
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", translateError(err))
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/jmoiron/sqlx"
+)
+
+// ExportComments calls fn for every comment of the tenant in ctx, deleted ones
+// included, with parents before their replies. The comments are read in a
+// single transaction, so they form a consistent snapshot.
+func (d *Database) ExportComments(ctx context.Context, fn func(comment.Comment) error) error {
+	defer d.observe("export_comments").ObserveDuration()
+	return d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		rows, err := tx.QueryxContext(ctx, "SELECT "+commentColumns+" FROM comments ORDER BY created_at, id")
+		if err != nil {
+			return fmt.Errorf("failed to export comments: %w", translateError(err))
+		}
+		defer func() { _ = rows.Close() }()
+
+		for rows.Next() {
+			var cr CommentRow
+			if err = rows.StructScan(&cr); err != nil {
+				return fmt.Errorf("failed to scan comment row: %w", translateError(err))
+			}
+			if err = fn(convertRowToComment(cr)); err != nil {
+				return err
+			}
+		}
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("failed to export comments: %w", translateError(err))
+		}
+		return nil
+	})
+}
+
+// importDefaults fills in what an exported comment always has, so that
+// hand-written import files can leave it out.
+func importDefaults(c comment.Comment, now time.Time) comment.Comment {
+	if c.Status == "" {
+		c.Status = comment.StatusApproved
+	}
+	if c.Version == 0 {
+		c.Version = 1
+	}
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = now
+	}
+	if c.UpdatedAt.IsZero() {
+		c.UpdatedAt = c.CreatedAt
+	}
+	return c
+}
+
+// ImportComments inserts comments into the tenant in ctx as they are, keeping
+// their IDs, timestamps, versions and statuses, and returns how many were
+// inserted. Comments whose ID already exists are skipped, so an import can be
+// repeated. Parents have to come before their replies. Everything is imported
+// in a single transaction and no events are recorded, since the comments are
+// not new.
+func (d *Database) ImportComments(ctx context.Context, comments []comment.Comment) (int, error) {
+	defer d.observe("import_comments").ObserveDuration()
+
+	var imported int
+	now := time.Now()
+	err := d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		for _, c := range comments {
+			c = importDefaults(c, now)
+			cr := convertCommentToRow(c)
+			cr.CreatedAt = c.CreatedAt
+			cr.UpdatedAt = c.UpdatedAt
+			if c.DeletedAt != nil {
+				cr.DeletedAt.Time, cr.DeletedAt.Valid = *c.DeletedAt, true
+			}
+
+			query, args, err := tx.BindNamed(
+				"INSERT INTO comments (id, tenant_id, parent_id, slug, body, author, "+
+					"created_at, updated_at, deleted_at, version, status) "+
+					"VALUES (:id, current_setting('app.tenant_id'), :parent_id, :slug, :body, :author, "+
+					":created_at, :updated_at, :deleted_at, :version, :status) "+
+					"ON CONFLICT (id) DO NOTHING",
+				cr,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to bind insert query: %w", err)
+			}
+
+			res, err := tx.ExecContext(ctx, query, args...)
+			if err != nil {
+				return fmt.Errorf("failed to import comment %s: %w", c.ID, translateError(err))
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("failed to import comment %s: %w", c.ID, err)
+			}
+			imported += int(n)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return imported, nil
+}
@@ -0,0 +1,360 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/azdanov/go-rest-api/internal/webhook"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	webhookColumns  = "id, url, secret, events, active, created_at, updated_at"
+	deliveryColumns = "id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at, " +
+		"last_error, response_status, created_at, delivered_at"
+)
+
+type WebhookRow struct {
+	ID        string
+	URL       string
+	Secret    string
+	Events    pq.StringArray
+	Active    bool
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+type DeliveryRow struct {
+	ID             string
+	WebhookID      string `db:"webhook_id"`
+	EventID        string `db:"event_id"`
+	EventType      string `db:"event_type"`
+	Payload        []byte
+	Status         string
+	Attempts       int
+	NextAttemptAt  time.Time      `db:"next_attempt_at"`
+	LastError      sql.NullString `db:"last_error"`
+	ResponseStatus sql.NullInt32  `db:"response_status"`
+	CreatedAt      time.Time      `db:"created_at"`
+	DeliveredAt    sql.NullTime   `db:"delivered_at"`
+}
+
+type JobRow struct {
+	DeliveryRow
+
+	URL    string
+	Secret string
+}
+
+func convertRowToWebhook(wr WebhookRow) webhook.Webhook {
+	events := make([]comment.EventType, 0, len(wr.Events))
+	for _, e := range wr.Events {
+		events = append(events, comment.EventType(e))
+	}
+	return webhook.Webhook{
+		ID:        wr.ID,
+		URL:       wr.URL,
+		Secret:    wr.Secret,
+		Events:    events,
+		Active:    wr.Active,
+		CreatedAt: wr.CreatedAt,
+		UpdatedAt: wr.UpdatedAt,
+	}
+}
+
+func eventsArray(events []comment.EventType) pq.StringArray {
+	arr := make(pq.StringArray, 0, len(events))
+	for _, e := range events {
+		arr = append(arr, string(e))
+	}
+	return arr
+}
+
+func convertRowToDelivery(dr DeliveryRow) webhook.Delivery {
+	d := webhook.Delivery{
+		ID:             dr.ID,
+		WebhookID:      dr.WebhookID,
+		EventID:        dr.EventID,
+		EventType:      comment.EventType(dr.EventType),
+		Payload:        dr.Payload,
+		Status:         webhook.DeliveryStatus(dr.Status),
+		Attempts:       dr.Attempts,
+		NextAttemptAt:  dr.NextAttemptAt,
+		LastError:      dr.LastError.String,
+		ResponseStatus: int(dr.ResponseStatus.Int32),
+		CreatedAt:      dr.CreatedAt,
+	}
+	if dr.DeliveredAt.Valid {
+		d.DeliveredAt = &dr.DeliveredAt.Time
+	}
+	return d
+}
+
+// translateWebhookError is translateError for queries on the webhooks table.
+func translateWebhookError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return webhook.ErrWebhookNotFound
+	}
+	return translateError(err)
+}
+
+// CreateWebhook registers the webhook for the tenant in ctx. Webhooks, like
+// comments, are only visible to their tenant.
+func (d *Database) CreateWebhook(ctx context.Context, w webhook.Webhook) (webhook.Webhook, error) {
+	defer d.observe("create_webhook").ObserveDuration()
+
+	var wr WebhookRow
+	err := d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		err := tx.GetContext(
+			ctx,
+			&wr,
+			"INSERT INTO webhooks (id, tenant_id, url, secret, events, active) "+
+				"VALUES ($1, current_setting('app.tenant_id'), $2, $3, $4, $5) RETURNING "+webhookColumns,
+			w.ID,
+			w.URL,
+			w.Secret,
+			eventsArray(w.Events),
+			w.Active,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert webhook: %w", translateError(err))
+		}
+		return nil
+	})
+	if err != nil {
+		return webhook.Webhook{}, err
+	}
+
+	return convertRowToWebhook(wr), nil
+}
+
+func (d *Database) GetWebhook(ctx context.Context, id string) (webhook.Webhook, error) {
+	defer d.observe("get_webhook").ObserveDuration()
+
+	var wr WebhookRow
+	err := d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		if err := tx.GetContext(ctx, &wr, "SELECT "+webhookColumns+" FROM webhooks WHERE id = $1", id); err != nil {
+			return fmt.Errorf("failed to get webhook: %w", translateWebhookError(err))
+		}
+		return nil
+	})
+	if err != nil {
+		return webhook.Webhook{}, err
+	}
+
+	return convertRowToWebhook(wr), nil
+}
+
+func (d *Database) ListWebhooks(ctx context.Context) ([]webhook.Webhook, error) {
+	defer d.observe("list_webhooks").ObserveDuration()
+
+	var rows []WebhookRow
+	err := d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		if err := tx.SelectContext(ctx, &rows, "SELECT "+webhookColumns+" FROM webhooks ORDER BY id"); err != nil {
+			return fmt.Errorf("failed to list webhooks: %w", translateError(err))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := make([]webhook.Webhook, 0, len(rows))
+	for _, wr := range rows {
+		webhooks = append(webhooks, convertRowToWebhook(wr))
+	}
+	return webhooks, nil
+}
+
+func (d *Database) UpdateWebhook(ctx context.Context, w webhook.Webhook) (webhook.Webhook, error) {
+	defer d.observe("update_webhook").ObserveDuration()
+
+	var wr WebhookRow
+	err := d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		err := tx.GetContext(
+			ctx,
+			&wr,
+			"UPDATE webhooks SET url = $2, events = $3, active = $4, updated_at = now() WHERE id = $1 RETURNING "+
+				webhookColumns,
+			w.ID,
+			w.URL,
+			eventsArray(w.Events),
+			w.Active,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update webhook: %w", translateWebhookError(err))
+		}
+		return nil
+	})
+	if err != nil {
+		return webhook.Webhook{}, err
+	}
+
+	return convertRowToWebhook(wr), nil
+}
+
+func (d *Database) DeleteWebhook(ctx context.Context, id string) error {
+	defer d.observe("delete_webhook").ObserveDuration()
+
+	return d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, "DELETE FROM webhooks WHERE id = $1", id)
+		if err != nil {
+			return fmt.Errorf("failed to delete webhook: %w", translateError(err))
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get affected rows: %w", translateError(err))
+		}
+		if n == 0 {
+			return webhook.ErrWebhookNotFound
+		}
+
+		return nil
+	})
+}
+
+// CreateDeliveries queues deliveries for webhooks of the tenant in ctx. A
+// delivery of an event that is already queued for the same webhook is
+// skipped, so redelivered events are harmless.
+func (d *Database) CreateDeliveries(ctx context.Context, deliveries []webhook.Delivery) error {
+	defer d.observe("create_deliveries").ObserveDuration()
+
+	return d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		for _, dl := range deliveries {
+			_, err := tx.ExecContext(
+				ctx,
+				`INSERT INTO webhook_deliveries (id, tenant_id, webhook_id, event_id, event_type, payload, status)
+				VALUES ($1, current_setting('app.tenant_id'), $2, $3, $4, $5, $6)
+				ON CONFLICT (webhook_id, event_id) DO NOTHING`,
+				dl.ID,
+				dl.WebhookID,
+				dl.EventID,
+				string(dl.EventType),
+				string(dl.Payload),
+				string(dl.Status),
+			)
+			if err != nil {
+				return fmt.Errorf("failed to insert delivery: %w", translateError(err))
+			}
+		}
+		return nil
+	})
+}
+
+// ClaimDeliveries picks due deliveries of active webhooks and pushes their
+// next attempt past the lease. SKIP LOCKED lets concurrent workers claim
+// disjoint batches, and the lease hands a delivery to another worker if the
+// one that claimed it never reports back. The worker serves all tenants, so
+// unlike the other webhook queries it does not run in a tenant transaction.
+func (d *Database) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]webhook.Job, error) {
+	defer d.observe("claim_deliveries").ObserveDuration()
+
+	var rows []JobRow
+	err := d.Client.SelectContext(
+		ctx,
+		&rows,
+		`WITH due AS (
+			SELECT wd.id
+			FROM webhook_deliveries wd
+			JOIN webhooks w ON w.id = wd.webhook_id
+			WHERE wd.status IN ('pending', 'retrying') AND wd.next_attempt_at <= now() AND w.active
+			ORDER BY wd.next_attempt_at
+			LIMIT $1
+			FOR UPDATE OF wd SKIP LOCKED
+		), claimed AS (
+			UPDATE webhook_deliveries wd
+			SET next_attempt_at = now() + $2 * interval '1 millisecond'
+			FROM due
+			WHERE wd.id = due.id
+			RETURNING wd.*
+		)
+		SELECT claimed.id, claimed.webhook_id, claimed.event_id, claimed.event_type, claimed.payload,
+			claimed.status, claimed.attempts, claimed.next_attempt_at, claimed.last_error,
+			claimed.response_status, claimed.created_at, claimed.delivered_at, w.url, w.secret
+		FROM claimed
+		JOIN webhooks w ON w.id = claimed.webhook_id
+		ORDER BY claimed.next_attempt_at, claimed.id`,
+		limit,
+		lease.Milliseconds(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim deliveries: %w", translateError(err))
+	}
+
+	jobs := make([]webhook.Job, 0, len(rows))
+	for _, jr := range rows {
+		jobs = append(jobs, webhook.Job{
+			Delivery: convertRowToDelivery(jr.DeliveryRow),
+			URL:      jr.URL,
+			Secret:   jr.Secret,
+		})
+	}
+	return jobs, nil
+}
+
+// UpdateDelivery records the outcome of a delivery attempt.
+func (d *Database) UpdateDelivery(ctx context.Context, dl webhook.Delivery) error {
+	defer d.observe("update_delivery").ObserveDuration()
+
+	_, err := d.Client.ExecContext(
+		ctx,
+		`UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, next_attempt_at = $4, last_error = $5, response_status = $6,
+			delivered_at = $7
+		WHERE id = $1`,
+		dl.ID,
+		string(dl.Status),
+		dl.Attempts,
+		dl.NextAttemptAt,
+		sql.NullString{String: dl.LastError, Valid: dl.LastError != ""},
+		sql.NullInt32{Int32: int32(dl.ResponseStatus), Valid: dl.ResponseStatus != 0}, //nolint:gosec // HTTP status
+		sql.NullTime{Time: derefTime(dl.DeliveredAt), Valid: dl.DeliveredAt != nil},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update delivery: %w", translateError(err))
+	}
+
+	return nil
+}
+
+func (d *Database) ListDeliveries(ctx context.Context, webhookID string, limit int) ([]webhook.Delivery, error) {
+	defer d.observe("list_deliveries").ObserveDuration()
+
+	var rows []DeliveryRow
+	err := d.inTenant(ctx, func(tx *sqlx.Tx) error {
+		err := tx.SelectContext(
+			ctx,
+			&rows,
+			"SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE webhook_id = $1 "+
+				"ORDER BY created_at DESC, id DESC LIMIT $2",
+			webhookID,
+			limit,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to list deliveries: %w", translateError(err))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]webhook.Delivery, 0, len(rows))
+	for _, dr := range rows {
+		deliveries = append(deliveries, convertRowToDelivery(dr))
+	}
+	return deliveries, nil
+}
+
+func derefTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
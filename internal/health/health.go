@@ -0,0 +1,128 @@
+// Package health runs the checks behind the health endpoints. Checks are
+// registered by name on a Registry and run concurrently, each with its own
+// timeout, and their results are collected in a Report.
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout bounds checks that are registered without a timeout.
+const DefaultTimeout = 2 * time.Second
+
+var ErrTimeout = errors.New("check timed out")
+
+// Func checks one dependency and returns an error if it is unhealthy. It
+// should give up when ctx is done.
+type Func func(ctx context.Context) error
+
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusFail Status = "fail"
+)
+
+// Result is the outcome of one check.
+type Result struct {
+	Name     string `json:"name"`
+	Status   Status `json:"status"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Report is the outcome of all checks of a registry. It passes if every check
+// passes, which is the case for a registry without checks.
+type Report struct {
+	Status Status   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+type check struct {
+	name    string
+	timeout time.Duration
+	fn      Func
+}
+
+// Registry holds named checks. The zero value is an empty registry and is safe
+// for concurrent use.
+type Registry struct {
+	mu     sync.RWMutex
+	checks []check
+}
+
+// Register adds a check, replacing any check with the same name. A timeout of
+// zero or less means DefaultTimeout.
+func (r *Registry) Register(name string, timeout time.Duration, fn Func) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := check{name: name, timeout: timeout, fn: fn}
+	for i := range r.checks {
+		if r.checks[i].name == name {
+			r.checks[i] = c
+			return
+		}
+	}
+	r.checks = append(r.checks, c)
+}
+
+// Run runs every check concurrently and reports their results in the order
+// they were registered. A check that outlives its timeout fails with
+// ErrTimeout, even if it ignores its context.
+func (r *Registry) Run(ctx context.Context) Report {
+	r.mu.RLock()
+	checks := make([]check, len(r.checks))
+	copy(checks, r.checks)
+	r.mu.RUnlock()
+
+	report := Report{Status: StatusPass, Checks: make([]Result, len(checks))}
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Checks[i] = c.run(ctx)
+		}()
+	}
+	wg.Wait()
+
+	for _, res := range report.Checks {
+		if res.Status != StatusPass {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+func (c check) run(ctx context.Context) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- c.fn(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = ErrTimeout
+		}
+	}
+
+	res := Result{Name: c.name, Status: StatusPass, Duration: time.Since(start).String()}
+	if err != nil {
+		res.Status = StatusFail
+		res.Error = err.Error()
+	}
+	return res
+}
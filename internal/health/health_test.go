@@ -0,0 +1,72 @@
+//go:build unit
+
+package health_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/azdanov/go-rest-api/internal/health"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pass(context.Context) error { return nil }
+
+func TestRegistry_Empty(t *testing.T) {
+	var r health.Registry
+
+	report := r.Run(context.Background())
+	assert.Equal(t, health.StatusPass, report.Status)
+	assert.Empty(t, report.Checks)
+}
+
+func TestRegistry_Run(t *testing.T) {
+	var r health.Registry
+	r.Register("database", 0, pass)
+	r.Register("cache", 0, func(context.Context) error { return errors.New("connection refused") })
+
+	report := r.Run(context.Background())
+	assert.Equal(t, health.StatusFail, report.Status)
+	require.Len(t, report.Checks, 2)
+	assert.Equal(t, "database", report.Checks[0].Name)
+	assert.Equal(t, health.StatusPass, report.Checks[0].Status)
+	assert.Empty(t, report.Checks[0].Error)
+	assert.Equal(t, "cache", report.Checks[1].Name)
+	assert.Equal(t, health.StatusFail, report.Checks[1].Status)
+	assert.Equal(t, "connection refused", report.Checks[1].Error)
+
+	// Registering a name again replaces its check.
+	r.Register("cache", 0, pass)
+	report = r.Run(context.Background())
+	assert.Equal(t, health.StatusPass, report.Status)
+	assert.Len(t, report.Checks, 2)
+}
+
+func TestRegistry_Timeout(t *testing.T) {
+	var r health.Registry
+	block := make(chan struct{})
+	defer close(block)
+	r.Register("stuck", 10*time.Millisecond, func(context.Context) error {
+		<-block // ignores its context
+		return nil
+	})
+	r.Register("slow", time.Second, func(ctx context.Context) error {
+		select {
+		case <-time.After(20 * time.Millisecond):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	start := time.Now()
+	report := r.Run(context.Background())
+	assert.Less(t, time.Since(start), time.Second, "the checks run concurrently")
+	assert.Equal(t, health.StatusFail, report.Status)
+	assert.Equal(t, health.StatusFail, report.Checks[0].Status)
+	assert.Equal(t, health.ErrTimeout.Error(), report.Checks[0].Error)
+	assert.Equal(t, health.StatusPass, report.Checks[1].Status)
+}
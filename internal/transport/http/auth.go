@@ -2,56 +2,187 @@ package http
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
-	"os"
+	"slices"
 	"strings"
 
+	"github.com/azdanov/go-rest-api/internal/comment"
 	jwt "github.com/golang-jwt/jwt/v5"
 )
 
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+)
+
+var (
+	errMissingAuthHeader = errors.New("missing Authorization header")
+	errInvalidAuthHeader = errors.New("invalid Authorization header format")
+	errMissingSubject    = errors.New("token has no subject")
+)
+
+// Claims are the JWT claims understood by the API.
+type Claims struct {
+	jwt.RegisteredClaims
+
+	Roles []string `json:"roles,omitempty"`
+	// Tenant scopes the token to one tenant. Tokens without it act on the
+	// tenant of the host they are used on, and only unprivileged ones are
+	// accepted on hosts mapped to a tenant.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+func (c *Claims) HasRole(role string) bool {
+	return slices.Contains(c.Roles, role)
+}
+
+// IsModerator reports whether the caller may act on comments written by
+// others. Admins are moderators as well.
+func (c *Claims) IsModerator() bool {
+	return c.HasRole(RoleModerator) || c.HasRole(RoleAdmin)
+}
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the verified claims that JWTAuth stored in the
+// request context.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
+
 func (h *Handler) JWTAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
-		if token == "" {
-			http.Error(w, "Missing Authorization header", http.StatusUnauthorized)
+		ctx, ok := h.authenticate(w, r)
+		if !ok {
 			return
 		}
+		next(w, r.WithContext(ctx))
+	}
+}
 
-		authHeaderParts := strings.Split(token, " ")
-		if len(authHeaderParts) != 2 || authHeaderParts[0] != "Bearer" {
-			http.Error(w, "Invalid Authorization header format", http.StatusUnauthorized)
+// AdminAuth is JWTAuth for endpoints that only admins may use.
+func (h *Handler) AdminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return h.JWTAuth(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := ClaimsFromContext(r.Context())
+		if !ok || !claims.HasRole(RoleAdmin) {
+			h.writeProblem(w, r, http.StatusForbidden, "insufficient permissions")
 			return
 		}
+		next(w, r)
+	})
+}
 
-		if isTokenInvalid(r.Context(), h.logger, authHeaderParts[1]) {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+// OptionalJWTAuth lets anonymous requests through, but authenticates the
+// request like JWTAuth as soon as it carries an Authorization header.
+func (h *Handler) OptionalJWTAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next(w, r)
 			return
 		}
 
-		next(w, r)
+		ctx, ok := h.authenticate(w, r)
+		if !ok {
+			return
+		}
+		next(w, r.WithContext(ctx))
 	}
 }
 
-func isTokenInvalid(ctx context.Context, logger *slog.Logger, t string) bool {
-	signingKey := []byte(getSigningKey(ctx, logger))
+// authenticate verifies the bearer token of r and returns a context carrying
+// its claims, the matching comment.Actor and the tenant the token is for. It
+// writes the error response itself and reports whether the request may
+// proceed.
+func (h *Handler) authenticate(w http.ResponseWriter, r *http.Request) (context.Context, bool) {
+	token, err := bearerToken(r)
+	if err != nil {
+		h.writeUnauthorized(w, r, err.Error())
+		return nil, false
+	}
 
-	token, err := jwt.Parse(t, func(_ *jwt.Token) (any, error) {
-		return signingKey, nil
+	claims, err := h.parseToken(r.Context(), token)
+	if err != nil {
+		h.writeUnauthorized(w, r, "invalid token")
+		return nil, false
+	}
+	if claims.Subject == "" {
+		h.writeUnauthorized(w, r, errMissingSubject.Error())
+		return nil, false
+	}
+	if !h.checkTenant(r, claims) {
+		h.writeProblem(w, r, http.StatusForbidden, errTenantMismatch.Error())
+		return nil, false
+	}
+
+	ctx := context.WithValue(r.Context(), claimsKey{}, claims)
+	ctx = comment.WithActor(ctx, comment.Actor{ID: claims.Subject, Moderator: claims.IsModerator()})
+	if claims.Tenant != "" {
+		ctx = comment.WithTenant(ctx, claims.Tenant)
+	}
+	return ctx, true
+}
+
+// requireRole checks that the caller holds the given role. It is used behind
+// OptionalJWTAuth, which has authenticated the request if it carries a token.
+// It writes the error response itself and reports whether the request may
+// proceed.
+func (h *Handler) requireRole(w http.ResponseWriter, r *http.Request, role string) bool {
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		h.writeUnauthorized(w, r, errMissingAuthHeader.Error())
+		return false
+	}
+
+	if !claims.HasRole(role) {
+		h.writeProblem(w, r, http.StatusForbidden, "insufficient permissions")
+		return false
+	}
+
+	return true
+}
+
+func (h *Handler) writeUnauthorized(w http.ResponseWriter, r *http.Request, detail string) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	h.writeProblem(w, r, http.StatusUnauthorized, detail)
+}
+
+func bearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", errMissingAuthHeader
+	}
+
+	authHeaderParts := strings.Split(header, " ")
+	if len(authHeaderParts) != 2 || authHeaderParts[0] != "Bearer" {
+		return "", errInvalidAuthHeader
+	}
+
+	return authHeaderParts[1], nil
+}
+
+func (h *Handler) parseToken(ctx context.Context, t string) (*Claims, error) {
+	var claims Claims
+	_, err := jwt.ParseWithClaims(t, &claims, func(_ *jwt.Token) (any, error) {
+		return h.signingKey, nil
 	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 	if err != nil {
-		logger.ErrorContext(ctx, "Failed to parse token", slog.Any("error", err))
-		return true
+		h.logger.ErrorContext(ctx, "Failed to parse token", slog.Any("error", err))
+		return nil, err
 	}
 
-	return !token.Valid
+	return &claims, nil
 }
 
-func getSigningKey(ctx context.Context, logger *slog.Logger) string {
-	key := os.Getenv("JWT_SIGNING_KEY")
-	if key == "" {
-		logger.WarnContext(ctx, "JWT_SIGNING_KEY environment variable is not set, using default key")
-		key = "default_secret" // Fallback key (should be avoided in production)
+// SignToken returns a token with the given claims that the API accepts when it
+// is configured with the same signing key.
+func SignToken(key []byte, claims Claims) (string, error) {
+	t, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
 	}
-	return key
+	return t, nil
 }
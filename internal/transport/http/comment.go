@@ -6,6 +6,8 @@ import (
 	"errors"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/azdanov/go-rest-api/internal/comment"
 	"github.com/google/uuid"
@@ -15,52 +17,69 @@ import (
 type CommentService interface {
 	CreateComment(context.Context, comment.Comment) (comment.Comment, error)
 	GetComment(context.Context, string) (comment.Comment, error)
+	GetCommentIncludingDeleted(context.Context, string) (comment.Comment, error)
+	ListComments(context.Context, comment.ListOptions) (comment.Page, error)
+	ListThreads(ctx context.Context, opts comment.ListOptions, maxDepth int) (comment.ThreadPage, error)
+	GetReplies(ctx context.Context, id string, maxDepth int) ([]comment.Thread, error)
 	UpdateComment(context.Context, comment.Comment) error
-	DeleteComment(context.Context, string) error
+	DeleteComment(ctx context.Context, id string, version int) error
+	RestoreComment(context.Context, string) error
+	ListRevisions(context.Context, string) ([]comment.Revision, error)
+	GetRevision(ctx context.Context, commentID string, number int) (comment.Revision, error)
+	ModerationQueue(context.Context, comment.ListOptions) (comment.Page, error)
+	Moderate(ctx context.Context, id string, to comment.Status, reason string) (comment.Comment, error)
+	Search(context.Context, comment.SearchOptions) (comment.SearchPage, error)
+	AddReaction(ctx context.Context, id string, kind comment.ReactionKind) error
+	RemoveReaction(ctx context.Context, id string, kind comment.ReactionKind) error
 }
 
+// PostCommentRequest carries no author: it is taken from the subject of the
+// verified token.
 type PostCommentRequest struct {
-	Slug   string `json:"slug"   validate:"required"`
-	Body   string `json:"body"   validate:"required"`
-	Author string `json:"author" validate:"required"`
+	ParentID string `json:"parent_id" validate:"omitempty,uuid"`
+	Slug     string `json:"slug"      validate:"required"`
+	Body     string `json:"body"      validate:"required,max=10000"`
 }
 
-func convertToComment(pcr PostCommentRequest) comment.Comment {
+func convertToComment(pcr PostCommentRequest, author string) comment.Comment {
 	return comment.Comment{
-		Slug:   pcr.Slug,
-		Body:   pcr.Body,
-		Author: pcr.Author,
+		ParentID: pcr.ParentID,
+		Slug:     pcr.Slug,
+		Body:     pcr.Body,
+		Author:   author,
 	}
 }
 
 func (h *Handler) PostComment(w http.ResponseWriter, r *http.Request) {
 	var pcr PostCommentRequest
 	if err := json.NewDecoder(r.Body).Decode(&pcr); err != nil {
-		h.logger.ErrorContext(r.Context(), "failed to decode request body", slog.Any("error", err))
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.writeDecodeProblem(w, r, err)
 		return
 	}
 
 	if err := h.validator.Struct(pcr); err != nil {
 		h.logger.ErrorContext(r.Context(), "validation failed", slog.Any("error", err))
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.writeValidationProblem(w, r, err)
 		return
 	}
 
-	comment := convertToComment(pcr)
-	comment, err := h.Service.CreateComment(r.Context(), comment)
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		h.writeUnauthorized(w, r, "missing token claims")
+		return
+	}
+
+	cmt, err := h.Service.CreateComment(r.Context(), convertToComment(pcr, claims.Subject))
 	if err != nil {
 		h.logger.ErrorContext(r.Context(), "failed to create comment", slog.Any("error", err))
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Location", "/api/v1/comments/"+comment.ID)
+	w.Header().Set("Location", "/api/v1/comments/"+cmt.ID)
 	w.WriteHeader(http.StatusCreated)
-	if err = json.NewEncoder(w).Encode(comment); err != nil {
+	if err = json.NewEncoder(w).Encode(cmt); err != nil {
 		h.logger.ErrorContext(r.Context(), "failed to encode response", slog.Any("error", err))
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
 	}
 }
 
@@ -68,47 +87,207 @@ func (h *Handler) GetComment(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	commentID := vars["id"]
 	if commentID == "" {
-		http.Error(w, "comment ID is required", http.StatusBadRequest)
+		h.writeProblem(w, r, http.StatusBadRequest, "comment ID is required")
 		return
 	}
 
 	if _, err := uuid.Parse(commentID); err != nil {
 		h.logger.ErrorContext(r.Context(), "invalid comment ID format", slog.Any("error", err))
-		http.Error(w, "invalid comment ID format", http.StatusBadRequest)
+		h.writeProblem(w, r, http.StatusBadRequest, "invalid comment ID format")
 		return
 	}
 
-	cmt, err := h.Service.GetComment(r.Context(), commentID)
+	includeDeleted, err := queryBool(r, "include_deleted")
 	if err != nil {
-		h.logger.ErrorContext(r.Context(), "failed to get comment", slog.Any("error", err))
-		if errors.Is(err, comment.ErrCommentNotFound) {
-			http.Error(w, "comment not found", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeProblem(w, r, http.StatusBadRequest, "invalid include_deleted")
+		return
+	}
+
+	var cmt comment.Comment
+	if includeDeleted {
+		if !h.requireRole(w, r, RoleAdmin) {
+			return
 		}
+		cmt, err = h.Service.GetCommentIncludingDeleted(r.Context(), commentID)
+	} else {
+		cmt, err = h.Service.GetComment(r.Context(), commentID)
+	}
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to get comment", slog.Any("error", err))
+		h.writeError(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", etag(cmt.Version))
 	if err = json.NewEncoder(w).Encode(cmt); err != nil {
 		h.logger.ErrorContext(r.Context(), "failed to encode response", slog.Any("error", err))
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) ListComments(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	opts := comment.ListOptions{
+		Slug:   query.Get("slug"),
+		Cursor: query.Get("cursor"),
+		Order:  comment.Order(query.Get("order")),
+	}
+	if opts.Slug == "" {
+		h.writeProblem(w, r, http.StatusBadRequest, "slug is required")
+		return
+	}
+
+	var err error
+	if opts.Limit, err = queryInt(r, "limit"); err != nil {
+		h.writeProblem(w, r, http.StatusBadRequest, "invalid limit")
+		return
+	}
+
+	tree, err := queryBool(r, "tree")
+	if err != nil {
+		h.writeProblem(w, r, http.StatusBadRequest, "invalid tree")
+		return
+	}
+
+	if opts.IncludeDeleted, err = queryBool(r, "include_deleted"); err != nil {
+		h.writeProblem(w, r, http.StatusBadRequest, "invalid include_deleted")
 		return
 	}
+	if opts.IncludeDeleted && !h.requireRole(w, r, RoleAdmin) {
+		return
+	}
+
+	var page any
+	if tree {
+		maxDepth, depthErr := queryInt(r, "max_depth")
+		if depthErr != nil {
+			h.writeProblem(w, r, http.StatusBadRequest, "invalid max_depth")
+			return
+		}
+		page, err = h.Service.ListThreads(r.Context(), opts, maxDepth)
+	} else {
+		page, err = h.Service.ListComments(r.Context(), opts)
+	}
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to list comments", slog.Any("error", err))
+		h.writeError(w, r, err)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(page); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to encode response", slog.Any("error", err))
+	}
 }
 
+func (h *Handler) GetReplies(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	commentID := vars["id"]
+	if _, err := uuid.Parse(commentID); err != nil {
+		h.logger.ErrorContext(r.Context(), "invalid comment ID format", slog.Any("error", err))
+		h.writeProblem(w, r, http.StatusBadRequest, "invalid comment ID format")
+		return
+	}
+
+	maxDepth, err := queryInt(r, "max_depth")
+	if err != nil {
+		h.writeProblem(w, r, http.StatusBadRequest, "invalid max_depth")
+		return
+	}
+
+	replies, err := h.Service.GetReplies(r.Context(), commentID, maxDepth)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to get replies", slog.Any("error", err))
+		h.writeError(w, r, err)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(replies); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to encode response", slog.Any("error", err))
+	}
+}
+
+// queryInt reads an optional integer query parameter, returning zero when it
+// is absent.
+func queryInt(r *http.Request, name string) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// queryBool reads an optional boolean query parameter, returning false when it
+// is absent.
+func queryBool(r *http.Request, name string) (bool, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(v)
+}
+
+var (
+	errMissingIfMatch = errors.New("missing If-Match header")
+	errInvalidIfMatch = errors.New("invalid If-Match header")
+	errWeakIfMatch    = errors.New("weak entity tags never match If-Match")
+)
+
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// parseIfMatch extracts the comment version from a strong If-Match entity tag.
+// As in RFC 9110, "*" matches any version and a weak tag matches none, since
+// If-Match compares tags strongly.
+func parseIfMatch(r *http.Request) (int, error) {
+	v := strings.TrimSpace(r.Header.Get("If-Match"))
+	switch {
+	case v == "":
+		return 0, errMissingIfMatch
+	case v == "*":
+		return comment.AnyVersion, nil
+	case strings.HasPrefix(v, "W/"):
+		return 0, errWeakIfMatch
+	}
+
+	unquoted, err := strconv.Unquote(v)
+	if err != nil {
+		return 0, errInvalidIfMatch
+	}
+
+	version, err := strconv.Atoi(unquoted)
+	if err != nil || version == comment.AnyVersion {
+		return 0, errInvalidIfMatch
+	}
+
+	return version, nil
+}
+
+// writeIfMatchError reports a missing, unmatchable or malformed If-Match
+// header.
+func (h *Handler) writeIfMatchError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errMissingIfMatch):
+		h.writeProblem(w, r, http.StatusPreconditionRequired, err.Error())
+	case errors.Is(err, errWeakIfMatch):
+		h.writeProblem(w, r, http.StatusPreconditionFailed, err.Error())
+	default:
+		h.writeProblem(w, r, http.StatusBadRequest, err.Error())
+	}
+}
+
+// UpdateCommentRequest may repeat the slug of the comment, but not change it.
 type UpdateCommentRequest struct {
-	ID     string `json:"id"     validate:"required,uuid"`
-	Slug   string `json:"slug"   validate:"required"`
-	Body   string `json:"body"   validate:"required"`
-	Author string `json:"author" validate:"required"`
+	ID   string `json:"id"             validate:"required,uuid"`
+	Slug string `json:"slug,omitempty"`
+	Body string `json:"body"           validate:"required,max=10000"`
 }
 
 func convertToUpdateComment(ucr UpdateCommentRequest) comment.Comment {
 	return comment.Comment{
-		ID:     ucr.ID,
-		Slug:   ucr.Slug,
-		Body:   ucr.Body,
-		Author: ucr.Author,
+		ID:   ucr.ID,
+		Slug: ucr.Slug,
+		Body: ucr.Body,
 	}
 }
 
@@ -116,39 +295,45 @@ func (h *Handler) UpdateComment(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	commentID := vars["id"]
 	if commentID == "" {
-		http.Error(w, "comment ID is required", http.StatusBadRequest)
+		h.writeProblem(w, r, http.StatusBadRequest, "comment ID is required")
 		return
 	}
 
 	if _, err := uuid.Parse(commentID); err != nil {
 		h.logger.ErrorContext(r.Context(), "invalid comment ID format", slog.Any("error", err))
-		http.Error(w, "invalid comment ID format", http.StatusBadRequest)
+		h.writeProblem(w, r, http.StatusBadRequest, "invalid comment ID format")
+		return
+	}
+
+	version, err := parseIfMatch(r)
+	if err != nil {
+		h.writeIfMatchError(w, r, err)
 		return
 	}
 
 	var ucr UpdateCommentRequest
-	if err := json.NewDecoder(r.Body).Decode(&ucr); err != nil {
-		h.logger.ErrorContext(r.Context(), "failed to decode request body", slog.Any("error", err))
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err = json.NewDecoder(r.Body).Decode(&ucr); err != nil {
+		h.writeDecodeProblem(w, r, err)
 		return
 	}
 
 	if ucr.ID != commentID {
-		http.Error(w, "comment ID in URL and body must match", http.StatusBadRequest)
+		h.writeProblem(w, r, http.StatusBadRequest, "comment ID in URL and body must match")
 		return
 	}
 
-	if err := h.validator.Struct(ucr); err != nil {
+	if err = h.validator.Struct(ucr); err != nil {
 		h.logger.ErrorContext(r.Context(), "validation failed", slog.Any("error", err))
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.writeValidationProblem(w, r, err)
 		return
 	}
 
-	comment := convertToUpdateComment(ucr)
+	cmt := convertToUpdateComment(ucr)
+	cmt.Version = version
 
-	if err := h.Service.UpdateComment(r.Context(), comment); err != nil {
+	if err = h.Service.UpdateComment(r.Context(), cmt); err != nil {
 		h.logger.ErrorContext(r.Context(), "failed to update comment", slog.Any("error", err))
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeError(w, r, err)
 		return
 	}
 
@@ -159,13 +344,43 @@ func (h *Handler) DeleteComment(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	commentID := vars["id"]
 	if commentID == "" {
-		http.Error(w, "comment ID is required", http.StatusBadRequest)
+		h.writeProblem(w, r, http.StatusBadRequest, "comment ID is required")
+		return
+	}
+
+	if _, err := uuid.Parse(commentID); err != nil {
+		h.logger.ErrorContext(r.Context(), "invalid comment ID format", slog.Any("error", err))
+		h.writeProblem(w, r, http.StatusBadRequest, "invalid comment ID format")
+		return
+	}
+
+	version, err := parseIfMatch(r)
+	if err != nil {
+		h.writeIfMatchError(w, r, err)
 		return
 	}
 
-	if err := h.Service.DeleteComment(r.Context(), commentID); err != nil {
+	if err = h.Service.DeleteComment(r.Context(), commentID, version); err != nil {
 		h.logger.ErrorContext(r.Context(), "failed to delete comment", slog.Any("error", err))
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) RestoreComment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	commentID := vars["id"]
+	if _, err := uuid.Parse(commentID); err != nil {
+		h.logger.ErrorContext(r.Context(), "invalid comment ID format", slog.Any("error", err))
+		h.writeProblem(w, r, http.StatusBadRequest, "invalid comment ID format")
+		return
+	}
+
+	if err := h.Service.RestoreComment(r.Context(), commentID); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to restore comment", slog.Any("error", err))
+		h.writeError(w, r, err)
 		return
 	}
 
@@ -2,86 +2,218 @@ package http
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/azdanov/go-rest-api/internal/config"
+	"github.com/azdanov/go-rest-api/internal/health"
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
-)
-
-const (
-	addr            = "0.0.0.0:8080"
-	requestTimeout  = 10
-	serverTimeout   = 15
-	shutdownTimeout = 15
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type Handler struct {
-	Router    *mux.Router
-	Service   CommentService
+	Router     *mux.Router
+	Service    CommentService
+	Webhooks   WebhookService
+	Streamer   CommentStreamer
+	Migrations MigrationSource
+	// Readiness and Liveness hold the checks of /readyz and /livez.
+	Readiness *health.Registry
+	Liveness  *health.Registry
 	Server    *http.Server
 	logger    *slog.Logger
 	validator *validator.Validate
+	upgrader  websocket.Upgrader
+	tenants   tenantResolver
+	// requestTimeout and shutdownTimeout come from config.HTTP.
+	requestTimeout  time.Duration
+	shutdownTimeout time.Duration
+	signingKey      []byte
+	// metrics and gatherer are set by WithMetrics.
+	metrics  *httpMetrics
+	gatherer prometheus.Gatherer
+	// shuttingDown is closed by beginShutdown when the server shuts down, so
+	// that long-lived responses end instead of holding up the shutdown and
+	// readiness fails.
+	shuttingDown  chan struct{}
+	beginShutdown func()
+	// sockets counts the open WebSocket connections, which Server.Shutdown
+	// does not wait for.
+	sockets sync.WaitGroup
 }
 
-func NewHandler(service CommentService, logger *slog.Logger) *Handler {
+// HandlerOption configures optional parts of the API.
+type HandlerOption func(*Handler)
+
+func NewHandler(service CommentService, logger *slog.Logger, cfg config.Config, opts ...HandlerOption) *Handler {
 	h := &Handler{
-		Service:   service,
-		logger:    logger,
-		validator: validator.New(validator.WithRequiredStructEnabled()),
+		Service:         service,
+		logger:          logger,
+		validator:       newValidator(),
+		upgrader:        websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024},
+		tenants:         newTenantResolver(cfg.Tenants),
+		requestTimeout:  cfg.HTTP.RequestTimeout,
+		shutdownTimeout: cfg.HTTP.ShutdownTimeout,
+		signingKey:      []byte(cfg.Auth.JWTSigningKey),
+		shuttingDown:    make(chan struct{}),
+		Readiness:       &health.Registry{},
+		Liveness:        &health.Registry{},
+	}
+	h.beginShutdown = sync.OnceFunc(func() { close(h.shuttingDown) })
+	h.Readiness.Register("shutdown", 0, h.checkShutdown)
+	for _, opt := range opts {
+		opt(h)
 	}
 
 	h.Router = mux.NewRouter()
 
+	h.mapHealthRoutes()
 	h.mapRoutes()
+	if h.Webhooks != nil {
+		h.mapWebhookRoutes()
+	}
+	if h.Migrations != nil {
+		h.mapMigrationRoutes()
+	}
+	if h.metrics != nil {
+		h.mapMetricsRoutes()
+	}
+
+	// Router.Use does not apply to the handlers of unmatched requests.
+	h.Router.NotFoundHandler = h.unmatched(http.StatusNotFound)
+	h.Router.MethodNotAllowedHandler = h.unmatched(http.StatusMethodNotAllowed)
 
 	h.Router.Use(
+		h.RequestIDMiddleware,
+		h.MetricsMiddleware,
 		h.LoggingMiddleware,
+		h.TenantMiddleware,
+		h.BodyLimitMiddleware,
 		h.JSONMiddleware,
 		h.TimeoutMiddleware,
 	)
 
 	h.Server = &http.Server{
-		IdleTimeout:       serverTimeout * time.Second,
-		WriteTimeout:      serverTimeout * time.Second,
-		ReadHeaderTimeout: serverTimeout * time.Second,
-		ReadTimeout:       serverTimeout * time.Second,
-		Addr:              addr,
+		IdleTimeout:       cfg.HTTP.ServerTimeout,
+		WriteTimeout:      cfg.HTTP.ServerTimeout,
+		ReadHeaderTimeout: cfg.HTTP.ServerTimeout,
+		ReadTimeout:       cfg.HTTP.ServerTimeout,
+		Addr:              cfg.HTTP.Addr,
 		Handler:           h.Router,
 	}
+	h.Server.RegisterOnShutdown(h.beginShutdown)
 
 	return h
 }
 
+// newValidator reports field errors under their JSON names, which is what
+// clients know them by.
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
+
+// unmatched answers requests that match no route with a problem for status.
+func (h *Handler) unmatched(status int) http.Handler {
+	return h.RequestIDMiddleware(h.MetricsMiddleware(h.statusProblem(status)))
+}
+
+// statusProblem answers every request with a generic problem for status.
+func (h *Handler) statusProblem(status int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.writeProblem(w, r, status, "")
+	})
+}
+
 func (h *Handler) mapRoutes() {
 	h.Router.HandleFunc("/api/v1/comments", h.JWTAuth(h.PostComment)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/api/v1/comments", h.OptionalJWTAuth(h.ListComments)).Methods(http.MethodGet)
+	h.Router.HandleFunc("/api/v1/comments/search", h.SearchComments).Methods(http.MethodGet)
+	if h.Streamer != nil {
+		h.Router.HandleFunc("/api/v1/comments/stream", h.StreamComments).
+			Methods(http.MethodGet).
+			Name(streamRouteName)
+	}
 	h.Router.HandleFunc("/api/v1/comments/{id}", h.JWTAuth(h.UpdateComment)).Methods(http.MethodPut)
 	h.Router.HandleFunc("/api/v1/comments/{id}", h.JWTAuth(h.DeleteComment)).Methods(http.MethodDelete)
-	h.Router.HandleFunc("/api/v1/comments/{id}", h.GetComment).Methods(http.MethodGet)
+	h.Router.HandleFunc("/api/v1/comments/{id}", h.OptionalJWTAuth(h.GetComment)).Methods(http.MethodGet)
+	h.Router.HandleFunc("/api/v1/comments/{id}/replies", h.OptionalJWTAuth(h.GetReplies)).Methods(http.MethodGet)
+	h.Router.HandleFunc("/api/v1/comments/{id}/restore", h.JWTAuth(h.RestoreComment)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/api/v1/comments/{id}/revisions", h.JWTAuth(h.ListRevisions)).Methods(http.MethodGet)
+	h.Router.HandleFunc("/api/v1/comments/{id}/revisions/{n}", h.JWTAuth(h.GetRevision)).Methods(http.MethodGet)
+	h.Router.HandleFunc("/api/v1/comments/{id}/moderate", h.JWTAuth(h.ModerateComment)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/api/v1/comments/{id}/reactions/{kind}", h.JWTAuth(h.PutReaction)).Methods(http.MethodPut)
+	h.Router.HandleFunc("/api/v1/comments/{id}/reactions/{kind}", h.JWTAuth(h.DeleteReaction)).
+		Methods(http.MethodDelete)
+	h.Router.HandleFunc("/api/v1/moderation/queue", h.JWTAuth(h.ModerationQueue)).Methods(http.MethodGet)
+	if h.Streamer != nil {
+		h.Router.HandleFunc("/api/v1/ws", h.JWTAuth(h.ServeSocket)).Methods(http.MethodGet).Name(socketRouteName)
+	}
 }
 
 func (h *Handler) Serve() error {
+	errs := make(chan error, 1)
 	go func() {
-		if err := h.Server.ListenAndServe(); err != nil {
-			h.logger.Error("failed to start server", slog.Any("error", err))
+		if err := h.Server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			errs <- err
 		}
 	}()
 
 	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, os.Interrupt)
-	<-ch
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(ch)
+	select {
+	case err := <-errs:
+		return fmt.Errorf("failed to start server: %w", err)
+	case <-ch:
+	}
+	h.beginShutdown()
 
-	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), h.shutdownTimeout)
 	defer cancel()
 	if err := h.Server.Shutdown(ctx); err != nil {
 		return fmt.Errorf("failed to shutdown server: %w", err)
 	}
+	if err := h.waitForSockets(ctx); err != nil {
+		return err
+	}
 
 	h.logger.Info("server shutdown gracefully")
 
 	return nil
 }
+
+// waitForSockets waits until the WebSocket connections, which are told to
+// close when the server shuts down, are gone.
+func (h *Handler) waitForSockets(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		h.sockets.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to close websockets: %w", ctx.Err())
+	}
+}
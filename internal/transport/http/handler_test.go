@@ -3,23 +3,34 @@
 package http_test
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/azdanov/go-rest-api/internal/config"
 	"github.com/azdanov/go-rest-api/internal/db"
+	"github.com/azdanov/go-rest-api/internal/health"
 	transportHttp "github.com/azdanov/go-rest-api/internal/transport/http"
+	"github.com/azdanov/go-rest-api/internal/webhook"
+	"github.com/azdanov/go-rest-api/migrations"
 	uuid "github.com/gofrs/uuid/v5"
 	jwt "github.com/golang-jwt/jwt/v5"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/gorilla/websocket"
 	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/suite"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/postgres"
@@ -31,6 +42,11 @@ const (
 	testServerHost = "localhost"
 	startupTimeout = 10 * time.Second
 	jwtSigningKey  = "default"
+	testUserID     = "test-user-id"
+	moderatedSlug  = "e2e-moderated-slug"
+	blockedWord    = "e2e-blocked"
+	otherTenant    = "e2e-other-tenant"
+	otherHost      = "other.example"
 )
 
 type HandlerE2ETestSuite struct {
@@ -38,7 +54,11 @@ type HandlerE2ETestSuite struct {
 	pgContainer  *postgres.PostgresContainer
 	db           *db.Database
 	handler      *transportHttp.Handler
+	webhooks     *webhook.Service
+	relay        *db.OutboxRelay
+	logger       *slog.Logger
 	client       *resty.Client
+	anonClient   *resty.Client
 	serverCtx    context.Context
 	serverCancel context.CancelFunc
 	jwtToken     string
@@ -86,23 +106,57 @@ func (s *HandlerE2ETestSuite) SetupSuite() {
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
 
-	newDB, err := db.NewDatabase(logger, connStr)
+	newDB, err := db.NewDatabase(logger, config.Database{URL: connStr})
 	s.Require().NoError(err)
 	s.db = newDB
 
-	err = s.db.Migrate("../../../migrations")
+	err = s.db.Migrate()
 	s.Require().NoError(err)
 
-	commentService := comment.NewService(s.db, logger)
+	s.logger = logger
+	s.webhooks = webhook.NewService(s.db, logger)
+	events := comment.NewDispatcher(logger)
+	events.Subscribe(s.webhooks.HandleEvent)
+	s.relay = db.NewOutboxRelay(s.db, events, logger)
+	changes := comment.NewDispatcher(logger)
+	broker := comment.NewBroker(s.db, 16)
+	changes.Subscribe(broker.Handle)
+	listener := db.NewListener(s.db, changes, logger)
+	listener.MinReconnect = 10 * time.Millisecond
+
+	commentService := comment.NewService(
+		s.db,
+		logger,
+		comment.WithModerationPolicy(comment.SlugPolicy{Slugs: map[string]bool{moderatedSlug: true}}),
+		comment.WithFilters(comment.NewWordListFilter([]string{blockedWord}, comment.ActionReject)),
+	)
 
 	freePort, err := findFreePort()
 	s.Require().NoError(err, "Failed to find free port")
 	testAddr := fmt.Sprintf("%s:%d", testServerHost, freePort)
 
-	s.handler = transportHttp.NewHandler(commentService, logger)
-	s.handler.Server.Addr = testAddr
+	cfg := config.Defaults()
+	cfg.Dev = true
+	cfg.HTTP.Addr = testAddr
+	cfg.Auth.JWTSigningKey = jwtSigningKey
+	cfg.Tenants.Hosts = map[string]string{otherHost: otherTenant}
+
+	s.handler = transportHttp.NewHandler(
+		commentService,
+		logger,
+		cfg,
+		transportHttp.WithWebhooks(s.webhooks),
+		transportHttp.WithStreamer(broker),
+		transportHttp.WithMigrations(s.db),
+		transportHttp.WithReadinessCheck("database", 0, s.db.Ping),
+	)
 
 	s.serverCtx, s.serverCancel = context.WithCancel(context.Background())
+	go func() {
+		if listenErr := listener.Run(s.serverCtx); listenErr != nil {
+			logger.Error("Listener failed", slog.Any("error", listenErr))
+		}
+	}()
 	go func() {
 		logger.Info("Starting test server", "address", s.handler.Server.Addr)
 		if err = s.handler.Server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
@@ -124,6 +178,7 @@ func (s *HandlerE2ETestSuite) SetupSuite() {
 	}, startupTimeout, 100*time.Millisecond, "Server did not start within timeout")
 
 	s.client = resty.New().SetBaseURL("http://" + s.handler.Server.Addr + "/api/v1")
+	s.anonClient = resty.New().SetBaseURL("http://" + s.handler.Server.Addr + "/api/v1")
 	s.setupTestJWT()
 }
 
@@ -155,8 +210,11 @@ func (s *HandlerE2ETestSuite) TearDownSuite() {
 func (s *HandlerE2ETestSuite) SetupTest() {
 	_, err := s.db.Client.ExecContext(context.Background(), "DELETE FROM comments")
 	s.Require().NoError(err)
+	_, err = s.db.Client.ExecContext(context.Background(), "DELETE FROM webhooks")
+	s.Require().NoError(err)
+	_, err = s.db.Client.ExecContext(context.Background(), "DELETE FROM outbox")
+	s.Require().NoError(err)
 	s.client.SetAuthToken(s.jwtToken)
-	os.Setenv("JWT_SIGNING_KEY", jwtSigningKey)
 }
 
 func TestHandlerE2ETestSuite(t *testing.T) {
@@ -164,27 +222,37 @@ func TestHandlerE2ETestSuite(t *testing.T) {
 }
 
 func (s *HandlerE2ETestSuite) setupTestJWT() {
-	jwtSecret := []byte(jwtSigningKey)
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub":   "test-user-id",
+	tokenString := s.signToken(jwt.MapClaims{
+		"sub":   testUserID,
 		"email": "test@example.com",
-		"iat":   time.Now().Unix(),
-		"exp":   time.Now().Add(24 * time.Hour).Unix(),
 	})
 
-	tokenString, err := token.SignedString(jwtSecret)
-	s.Require().NoError(err, "Failed to sign JWT token")
-
 	s.jwtToken = tokenString
 	s.client.SetAuthToken(tokenString)
 }
 
+func (s *HandlerE2ETestSuite) signToken(claims jwt.MapClaims) string {
+	claims["iat"] = time.Now().Unix()
+	claims["exp"] = time.Now().Add(24 * time.Hour).Unix()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	tokenString, err := token.SignedString([]byte(jwtSigningKey))
+	s.Require().NoError(err, "Failed to sign JWT token")
+
+	return tokenString
+}
+
+// tenantContext scopes direct database calls to the tenant of requests for
+// unmapped hosts.
+func tenantContext() context.Context {
+	return comment.WithTenant(context.Background(), comment.DefaultTenant)
+}
+
 func (s *HandlerE2ETestSuite) TestPostComment_Success() {
 	commentInput := map[string]string{
-		"slug":   "e2e-test-slug",
-		"body":   "This is the e2e test body",
-		"author": "e2e-tester",
+		"slug": "e2e-test-slug",
+		"body": "This is the e2e test body",
 	}
 
 	var createdComment comment.Comment
@@ -199,11 +267,15 @@ func (s *HandlerE2ETestSuite) TestPostComment_Success() {
 	s.NotEmpty(createdComment.ID)
 	s.Equal(commentInput["slug"], createdComment.Slug)
 	s.Equal(commentInput["body"], createdComment.Body)
-	s.Equal(commentInput["author"], createdComment.Author)
+	s.Equal(testUserID, createdComment.Author, "author comes from the token subject")
+
+	s.False(createdComment.CreatedAt.IsZero())
 
-	dbComment, dbErr := s.db.GetComment(context.Background(), createdComment.ID)
+	dbComment, dbErr := s.db.GetComment(tenantContext(), createdComment.ID)
 	s.Require().NoError(dbErr)
-	s.Equal(createdComment, dbComment)
+	s.Equal(createdComment.ID, dbComment.ID)
+	s.Equal(createdComment.Body, dbComment.Body)
+	s.True(createdComment.CreatedAt.Equal(dbComment.CreatedAt))
 }
 
 func (s *HandlerE2ETestSuite) TestGetComment_Success() {
@@ -213,7 +285,7 @@ func (s *HandlerE2ETestSuite) TestGetComment_Success() {
 		Body:   "get body",
 		Author: "get author",
 	}
-	_, err := s.db.CreateComment(context.Background(), seedComment)
+	_, err := s.db.CreateComment(tenantContext(), seedComment)
 	s.Require().NoError(err)
 
 	var fetchedComment comment.Comment
@@ -223,6 +295,7 @@ func (s *HandlerE2ETestSuite) TestGetComment_Success() {
 
 	s.Require().NoError(err)
 	s.Equal(http.StatusOK, resp.StatusCode(), "Response body: %s", resp.String())
+	s.Equal(`"1"`, resp.Header().Get("ETag"))
 	s.Equal(seedComment.ID, fetchedComment.ID)
 	s.Equal(seedComment.Slug, fetchedComment.Slug)
 	s.Equal(seedComment.Body, fetchedComment.Body)
@@ -237,6 +310,22 @@ func (s *HandlerE2ETestSuite) TestGetComment_NotFound() {
 
 	s.Require().NoError(err)
 	s.Equal(http.StatusNotFound, resp.StatusCode(), "Response body: %s", resp.String())
+	s.Equal("application/problem+json", resp.Header().Get("Content-Type"))
+}
+
+func (s *HandlerE2ETestSuite) TestProblem_RequestID() {
+	var problem transportHttp.Problem
+	resp, err := s.client.R().
+		SetHeader("X-Request-ID", "e2e-request-id").
+		SetError(&problem).
+		Get("/comments/" + s.getUUID())
+
+	s.Require().NoError(err)
+	s.Equal(http.StatusNotFound, resp.StatusCode())
+	s.Equal("e2e-request-id", resp.Header().Get("X-Request-ID"))
+	s.Equal("e2e-request-id", problem.RequestID)
+	s.Equal("/problems/comment-not-found", problem.Type)
+	s.Empty(problem.Detail)
 }
 
 func (s *HandlerE2ETestSuite) TestUpdateComment_Success() {
@@ -244,20 +333,20 @@ func (s *HandlerE2ETestSuite) TestUpdateComment_Success() {
 		ID:     s.getUUID(),
 		Slug:   "update-slug-initial",
 		Body:   "update body initial",
-		Author: "update author",
+		Author: testUserID,
 	}
-	_, err := s.db.CreateComment(context.Background(), seedComment)
+	_, err := s.db.CreateComment(tenantContext(), seedComment)
 	s.Require().NoError(err)
 
 	updateInput := map[string]string{
-		"id":     seedComment.ID,
-		"slug":   "update-slug-final",
-		"body":   "update body final",
-		"author": seedComment.Author,
+		"id":   seedComment.ID,
+		"slug": seedComment.Slug,
+		"body": "update body final",
 	}
 
 	var updatedComment any
 	resp, err := s.client.R().
+		SetHeader("If-Match", `"1"`).
 		SetBody(updateInput).
 		Put("/comments/" + seedComment.ID)
 
@@ -265,7 +354,7 @@ func (s *HandlerE2ETestSuite) TestUpdateComment_Success() {
 	s.Equal(http.StatusNoContent, resp.StatusCode())
 	s.Nil(updatedComment)
 
-	dbComment, dbErr := s.db.GetComment(context.Background(), seedComment.ID)
+	dbComment, dbErr := s.db.GetComment(tenantContext(), seedComment.ID)
 	s.Require().NoError(dbErr)
 	s.Equal(seedComment.ID, dbComment.ID)
 	s.Equal(updateInput["slug"], dbComment.Slug)
@@ -273,17 +362,45 @@ func (s *HandlerE2ETestSuite) TestUpdateComment_Success() {
 	s.Equal(seedComment.Author, dbComment.Author)
 }
 
+func (s *HandlerE2ETestSuite) TestUpdateComment_SlugChanged() {
+	seedComment := comment.Comment{
+		ID:     s.getUUID(),
+		Slug:   "update-slug-fixed",
+		Body:   "update body",
+		Author: testUserID,
+	}
+	_, err := s.db.CreateComment(tenantContext(), seedComment)
+	s.Require().NoError(err)
+
+	var problem transportHttp.Problem
+	resp, err := s.client.R().
+		SetHeader("If-Match", `"1"`).
+		SetBody(map[string]string{"id": seedComment.ID, "slug": "update-slug-moved", "body": "moved body"}).
+		SetError(&problem).
+		Put("/comments/" + seedComment.ID)
+
+	s.Require().NoError(err)
+	s.Equal(http.StatusBadRequest, resp.StatusCode())
+	s.Equal("/problems/slug-changed", problem.Type)
+
+	dbComment, err := s.db.GetComment(tenantContext(), seedComment.ID)
+	s.Require().NoError(err)
+	s.Equal(seedComment.Slug, dbComment.Slug)
+	s.Equal(seedComment.Body, dbComment.Body)
+}
+
 func (s *HandlerE2ETestSuite) TestDeleteComment_Success() {
 	seedComment := comment.Comment{
 		ID:     s.getUUID(),
 		Slug:   "delete-slug",
 		Body:   "delete body",
-		Author: "delete author",
+		Author: testUserID,
 	}
-	_, err := s.db.CreateComment(context.Background(), seedComment)
+	_, err := s.db.CreateComment(tenantContext(), seedComment)
 	s.Require().NoError(err)
 
 	resp, err := s.client.R().
+		SetHeader("If-Match", `"1"`).
 		Delete("/comments/" + seedComment.ID)
 
 	s.Require().NoError(err)
@@ -294,26 +411,66 @@ func (s *HandlerE2ETestSuite) TestDeleteComment_Success() {
 	s.Require().NoError(err)
 	s.Equal(http.StatusNotFound, getResp.StatusCode())
 
-	_, dbErr := s.db.GetComment(context.Background(), seedComment.ID)
+	_, dbErr := s.db.GetComment(tenantContext(), seedComment.ID)
 	s.Require().Error(dbErr)
 }
 
+func (s *HandlerE2ETestSuite) TestUpdateDeleteComment_NotFound() {
+	nonExistentID := s.getUUID()
+
+	resp, err := s.client.R().
+		SetHeader("If-Match", `"1"`).
+		SetBody(map[string]string{"id": nonExistentID, "slug": "missing-slug", "body": "missing body"}).
+		Put("/comments/" + nonExistentID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusNotFound, resp.StatusCode(), "Response body: %s", resp.String())
+
+	resp, err = s.client.R().
+		SetHeader("If-Match", `"1"`).
+		Delete("/comments/" + nonExistentID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusNotFound, resp.StatusCode(), "Response body: %s", resp.String())
+
+	resp, err = s.client.R().
+		SetHeader("If-Match", `"1"`).
+		Delete("/comments/not-a-uuid")
+	s.Require().NoError(err)
+	s.Equal(http.StatusBadRequest, resp.StatusCode())
+}
+
 func (s *HandlerE2ETestSuite) TestPostComment_ValidationError() {
 	commentInput := map[string]string{
-		"slug":   "e2e-validation-slug",
-		"author": "e2e-validator",
+		"slug": "e2e-validation-slug",
 		// "body": "missing",
 	}
 
-	var errorResponse map[string]any
+	var problem transportHttp.Problem
 	resp, err := s.client.R().
 		SetBody(commentInput).
-		SetError(&errorResponse).
+		SetError(&problem).
 		Post("/comments")
 
 	s.Require().NoError(err)
 	s.Equal(http.StatusBadRequest, resp.StatusCode())
-	s.Nil(errorResponse)
+	s.Equal("application/problem+json", resp.Header().Get("Content-Type"))
+	s.Equal(http.StatusBadRequest, problem.Status)
+	s.Equal("/api/v1/comments", problem.Instance)
+	s.NotEmpty(problem.RequestID)
+	s.Equal([]transportHttp.FieldError{{Field: "body", Message: "is required"}}, problem.Errors)
+}
+
+func (s *HandlerE2ETestSuite) TestPostComment_TooLarge() {
+	resp, err := s.client.R().
+		SetBody(map[string]string{"slug": "e2e-large-slug", "body": strings.Repeat("\n", 10001)}).
+		Post("/comments")
+	s.Require().NoError(err)
+	s.Equal(http.StatusBadRequest, resp.StatusCode(), "Response body: %s", resp.String())
+
+	resp, err = s.client.R().
+		SetBody(map[string]string{"slug": "e2e-large-slug", "body": strings.Repeat("a", 1<<20)}).
+		Post("/comments")
+	s.Require().NoError(err)
+	s.Equal(http.StatusRequestEntityTooLarge, resp.StatusCode(), "Response body: %s", resp.String())
 }
 
 func (s *HandlerE2ETestSuite) TestGetComment_InvalidIDFormat() {
@@ -326,3 +483,966 @@ func (s *HandlerE2ETestSuite) TestGetComment_InvalidIDFormat() {
 
 	s.Equal(http.StatusBadRequest, resp.StatusCode())
 }
+
+func (s *HandlerE2ETestSuite) TestListComments_Pagination() {
+	for range 3 {
+		_, err := s.db.CreateComment(tenantContext(), comment.Comment{
+			ID:     s.getUUID(),
+			Slug:   "list-slug",
+			Body:   "list body",
+			Author: "list author",
+		})
+		s.Require().NoError(err)
+	}
+
+	var firstPage comment.Page
+	resp, err := s.client.R().
+		SetQueryParams(map[string]string{"slug": "list-slug", "limit": "2"}).
+		SetResult(&firstPage).
+		Get("/comments")
+
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode(), "Response body: %s", resp.String())
+	s.Len(firstPage.Comments, 2)
+	s.NotEmpty(firstPage.NextCursor)
+
+	var secondPage comment.Page
+	resp, err = s.client.R().
+		SetQueryParams(map[string]string{"slug": "list-slug", "limit": "2", "cursor": firstPage.NextCursor}).
+		SetResult(&secondPage).
+		Get("/comments")
+
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode(), "Response body: %s", resp.String())
+	s.Len(secondPage.Comments, 1)
+	s.Empty(secondPage.NextCursor)
+	s.Less(firstPage.Comments[1].ID, secondPage.Comments[0].ID)
+}
+
+func (s *HandlerE2ETestSuite) TestListComments_MissingSlug() {
+	resp, err := s.client.R().
+		Get("/comments")
+
+	s.Require().NoError(err)
+	s.Equal(http.StatusBadRequest, resp.StatusCode())
+}
+
+func (s *HandlerE2ETestSuite) TestReplies() {
+	root := comment.Comment{
+		ID:     s.getUUID(),
+		Slug:   "thread-slug",
+		Body:   "root body",
+		Author: "root author",
+	}
+	_, err := s.db.CreateComment(tenantContext(), root)
+	s.Require().NoError(err)
+
+	var reply comment.Comment
+	resp, err := s.client.R().
+		SetBody(map[string]string{
+			"parent_id": root.ID,
+			"slug":      root.Slug,
+			"body":      "reply body",
+		}).
+		SetResult(&reply).
+		Post("/comments")
+	s.Require().NoError(err)
+	s.Equal(http.StatusCreated, resp.StatusCode(), "Response body: %s", resp.String())
+	s.Equal(root.ID, reply.ParentID)
+
+	var replies []comment.Thread
+	resp, err = s.client.R().
+		SetResult(&replies).
+		Get("/comments/" + root.ID + "/replies")
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode(), "Response body: %s", resp.String())
+	s.Require().Len(replies, 1)
+	s.Equal(reply.ID, replies[0].ID)
+
+	var page comment.ThreadPage
+	resp, err = s.client.R().
+		SetQueryParams(map[string]string{"slug": root.Slug, "tree": "true"}).
+		SetResult(&page).
+		Get("/comments")
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode(), "Response body: %s", resp.String())
+	s.Require().Len(page.Threads, 1)
+	s.Require().Len(page.Threads[0].Replies, 1)
+	s.Equal(reply.ID, page.Threads[0].Replies[0].ID)
+}
+
+func (s *HandlerE2ETestSuite) TestPostComment_ParentSlugMismatch() {
+	root := comment.Comment{
+		ID:     s.getUUID(),
+		Slug:   "thread-slug",
+		Body:   "root body",
+		Author: "root author",
+	}
+	_, err := s.db.CreateComment(tenantContext(), root)
+	s.Require().NoError(err)
+
+	resp, err := s.client.R().
+		SetBody(map[string]string{
+			"parent_id": root.ID,
+			"slug":      "another-slug",
+			"body":      "reply body",
+		}).
+		Post("/comments")
+	s.Require().NoError(err)
+	s.Equal(http.StatusBadRequest, resp.StatusCode())
+}
+
+func (s *HandlerE2ETestSuite) TestRestoreComment_Success() {
+	seedComment := comment.Comment{
+		ID:     s.getUUID(),
+		Slug:   "restore-slug",
+		Body:   "restore body",
+		Author: testUserID,
+	}
+	_, err := s.db.CreateComment(tenantContext(), seedComment)
+	s.Require().NoError(err)
+
+	resp, err := s.client.R().
+		SetHeader("If-Match", `"1"`).
+		Delete("/comments/" + seedComment.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusNoContent, resp.StatusCode())
+
+	resp, err = s.client.R().
+		Post("/comments/" + seedComment.ID + "/restore")
+	s.Require().NoError(err)
+	s.Equal(http.StatusNoContent, resp.StatusCode(), "Response body: %s", resp.String())
+
+	resp, err = s.client.R().
+		Get("/comments/" + seedComment.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode())
+
+	resp, err = s.client.R().
+		Post("/comments/" + seedComment.ID + "/restore")
+	s.Require().NoError(err)
+	s.Equal(http.StatusNotFound, resp.StatusCode())
+}
+
+func (s *HandlerE2ETestSuite) TestGetComment_IncludeDeleted() {
+	seedComment := comment.Comment{
+		ID:     s.getUUID(),
+		Slug:   "deleted-slug",
+		Body:   "deleted body",
+		Author: "deleted author",
+	}
+	_, err := s.db.CreateComment(tenantContext(), seedComment)
+	s.Require().NoError(err)
+	s.Require().NoError(s.db.DeleteComment(tenantContext(), seedComment.ID, 1))
+
+	resp, err := s.client.R().
+		SetQueryParam("include_deleted", "true").
+		Get("/comments/" + seedComment.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusForbidden, resp.StatusCode(), "non-admins cannot see deleted comments")
+
+	resp, err = s.anonClient.R().
+		SetQueryParam("include_deleted", "true").
+		Get("/comments/" + seedComment.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusUnauthorized, resp.StatusCode())
+
+	resp, err = s.anonClient.R().
+		SetAuthToken(s.signToken(jwt.MapClaims{"roles": []string{"admin"}})).
+		SetQueryParam("include_deleted", "true").
+		Get("/comments/" + seedComment.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusUnauthorized, resp.StatusCode(), "tokens without a subject are rejected")
+
+	var fetchedComment comment.Comment
+	resp, err = s.client.R().
+		SetAuthToken(s.signToken(jwt.MapClaims{"sub": "admin-user-id", "roles": []string{"admin"}})).
+		SetQueryParam("include_deleted", "true").
+		SetResult(&fetchedComment).
+		Get("/comments/" + seedComment.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode(), "Response body: %s", resp.String())
+	s.NotNil(fetchedComment.DeletedAt)
+}
+
+func (s *HandlerE2ETestSuite) TestUpdateComment_PreconditionFailed() {
+	seedComment := comment.Comment{
+		ID:     s.getUUID(),
+		Slug:   "conflict-slug",
+		Body:   "conflict body",
+		Author: testUserID,
+	}
+	_, err := s.db.CreateComment(tenantContext(), seedComment)
+	s.Require().NoError(err)
+
+	updateInput := map[string]string{
+		"id":   seedComment.ID,
+		"slug": seedComment.Slug,
+		"body": "edited body",
+	}
+
+	resp, err := s.client.R().
+		SetBody(updateInput).
+		Put("/comments/" + seedComment.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusPreconditionRequired, resp.StatusCode())
+
+	resp, err = s.client.R().
+		SetHeader("If-Match", `"1"`).
+		SetBody(updateInput).
+		Put("/comments/" + seedComment.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusNoContent, resp.StatusCode(), "Response body: %s", resp.String())
+
+	resp, err = s.client.R().
+		SetHeader("If-Match", `"1"`).
+		SetBody(updateInput).
+		Put("/comments/" + seedComment.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusPreconditionFailed, resp.StatusCode())
+
+	resp, err = s.client.R().
+		SetHeader("If-Match", `"1"`).
+		Delete("/comments/" + seedComment.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusPreconditionFailed, resp.StatusCode())
+
+	// Weak tags never match, "*" matches whichever version is stored.
+	resp, err = s.client.R().
+		SetHeader("If-Match", `W/"2"`).
+		SetBody(updateInput).
+		Put("/comments/" + seedComment.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusPreconditionFailed, resp.StatusCode())
+
+	resp, err = s.client.R().
+		SetHeader("If-Match", "*").
+		SetBody(updateInput).
+		Put("/comments/" + seedComment.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusNoContent, resp.StatusCode(), "Response body: %s", resp.String())
+
+	resp, err = s.client.R().
+		SetHeader("If-Match", `"0"`).
+		Delete("/comments/" + seedComment.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusBadRequest, resp.StatusCode())
+
+	resp, err = s.client.R().
+		SetHeader("If-Match", "*").
+		Delete("/comments/" + seedComment.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusNoContent, resp.StatusCode(), "Response body: %s", resp.String())
+}
+
+func (s *HandlerE2ETestSuite) TestRevisions() {
+	seedComment := comment.Comment{
+		ID:     s.getUUID(),
+		Slug:   "revision-slug",
+		Body:   "revision body initial",
+		Author: testUserID,
+	}
+	_, err := s.db.CreateComment(tenantContext(), seedComment)
+	s.Require().NoError(err)
+
+	resp, err := s.client.R().
+		SetHeader("If-Match", `"1"`).
+		SetBody(map[string]string{
+			"id":   seedComment.ID,
+			"slug": seedComment.Slug,
+			"body": "revision body updated",
+		}).
+		Put("/comments/" + seedComment.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusNoContent, resp.StatusCode(), "Response body: %s", resp.String())
+
+	var revisions []comment.Revision
+	resp, err = s.client.R().
+		SetResult(&revisions).
+		Get("/comments/" + seedComment.ID + "/revisions")
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode(), "Response body: %s", resp.String())
+	s.Require().Len(revisions, 1)
+	s.Equal("revision body initial", revisions[0].Body)
+	s.Equal(testUserID, revisions[0].EditedBy)
+	s.Contains(revisions[0].Diff, "+revision body updated")
+
+	var revision comment.Revision
+	resp, err = s.client.R().
+		SetResult(&revision).
+		Get("/comments/" + seedComment.ID + "/revisions/1")
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode(), "Response body: %s", resp.String())
+	s.Equal(1, revision.Number)
+	s.Equal(revisions[0].Diff, revision.Diff)
+
+	resp, err = s.client.R().
+		Get("/comments/" + seedComment.ID + "/revisions/2")
+	s.Require().NoError(err)
+	s.Equal(http.StatusNotFound, resp.StatusCode())
+}
+
+func (s *HandlerE2ETestSuite) TestUpdateComment_Forbidden() {
+	seedComment := comment.Comment{
+		ID:     s.getUUID(),
+		Slug:   "owner-slug",
+		Body:   "owner body",
+		Author: "someone-else",
+	}
+	_, err := s.db.CreateComment(tenantContext(), seedComment)
+	s.Require().NoError(err)
+
+	updateInput := map[string]string{
+		"id":   seedComment.ID,
+		"slug": seedComment.Slug,
+		"body": "hijacked body",
+	}
+
+	resp, err := s.client.R().
+		SetHeader("If-Match", `"1"`).
+		SetBody(updateInput).
+		Put("/comments/" + seedComment.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusForbidden, resp.StatusCode())
+
+	resp, err = s.client.R().
+		SetHeader("If-Match", `"1"`).
+		Delete("/comments/" + seedComment.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusForbidden, resp.StatusCode())
+
+	resp, err = s.client.R().
+		SetAuthToken(s.signToken(jwt.MapClaims{"sub": "moderator-id", "roles": []string{"moderator"}})).
+		SetHeader("If-Match", `"1"`).
+		SetBody(updateInput).
+		Put("/comments/" + seedComment.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusNoContent, resp.StatusCode(), "Response body: %s", resp.String())
+
+	dbComment, dbErr := s.db.GetComment(tenantContext(), seedComment.ID)
+	s.Require().NoError(dbErr)
+	s.Equal(seedComment.Author, dbComment.Author)
+}
+
+func (s *HandlerE2ETestSuite) TestModerationWorkflow() {
+	moderatorToken := s.signToken(jwt.MapClaims{"sub": "moderator-id", "roles": []string{"moderator"}})
+
+	var created comment.Comment
+	resp, err := s.client.R().
+		SetBody(map[string]string{"slug": moderatedSlug, "body": "held for review"}).
+		SetResult(&created).
+		Post("/comments")
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusCreated, resp.StatusCode(), "Response body: %s", resp.String())
+	s.Equal(comment.StatusPending, created.Status)
+
+	resp, err = s.anonClient.R().Get("/comments/" + created.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusNotFound, resp.StatusCode())
+
+	resp, err = s.client.R().Get("/comments/" + created.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode(), "authors see their own pending comments")
+
+	var page comment.Page
+	resp, err = s.anonClient.R().SetResult(&page).Get("/comments?slug=" + moderatedSlug)
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode())
+	s.Empty(page.Comments)
+
+	resp, err = s.client.R().Get("/moderation/queue")
+	s.Require().NoError(err)
+	s.Equal(http.StatusForbidden, resp.StatusCode())
+
+	resp, err = s.client.R().
+		SetAuthToken(moderatorToken).
+		SetResult(&page).
+		Get("/moderation/queue?slug=" + moderatedSlug)
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode())
+	s.Require().Len(page.Comments, 1)
+	s.Equal(created.ID, page.Comments[0].ID)
+
+	var moderated comment.Comment
+	resp, err = s.client.R().
+		SetAuthToken(moderatorToken).
+		SetBody(map[string]string{"status": "approved", "reason": "on topic"}).
+		SetResult(&moderated).
+		Post("/comments/" + created.ID + "/moderate")
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode(), "Response body: %s", resp.String())
+	s.Equal(comment.StatusApproved, moderated.Status)
+
+	resp, err = s.anonClient.R().Get("/comments/" + created.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode())
+
+	var problem transportHttp.Problem
+	resp, err = s.client.R().
+		SetAuthToken(moderatorToken).
+		SetBody(map[string]string{"status": "rejected", "reason": "changed my mind"}).
+		SetError(&problem).
+		Post("/comments/" + created.ID + "/moderate")
+	s.Require().NoError(err)
+	s.Equal(http.StatusConflict, resp.StatusCode())
+	s.Equal("/problems/invalid-transition", problem.Type)
+}
+
+func (s *HandlerE2ETestSuite) TestPostComment_RejectedByFilter() {
+	var problem transportHttp.Problem
+	resp, err := s.client.R().
+		SetBody(map[string]string{"slug": "e2e-filter-slug", "body": "this is " + blockedWord}).
+		SetError(&problem).
+		Post("/comments")
+
+	s.Require().NoError(err)
+	s.Equal(http.StatusUnprocessableEntity, resp.StatusCode(), "Response body: %s", resp.String())
+	s.Equal("/problems/comment-rejected", problem.Type)
+	s.Require().NotNil(problem.Verdict)
+	s.Equal("word-list", problem.Verdict.Filter)
+	s.Equal(comment.ActionReject, problem.Verdict.Action)
+}
+
+func (s *HandlerE2ETestSuite) TestSearchComments() {
+	seedComment := comment.Comment{
+		ID:     s.getUUID(),
+		Slug:   "e2e-search-slug",
+		Body:   "searching for a distinctive marmalade",
+		Author: testUserID,
+	}
+	_, err := s.db.CreateComment(tenantContext(), seedComment)
+	s.Require().NoError(err)
+
+	var page comment.SearchPage
+	resp, err := s.client.R().
+		SetResult(&page).
+		Get("/comments/search?q=marmalade&slug=" + seedComment.Slug)
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode(), "Response body: %s", resp.String())
+	s.Require().Len(page.Results, 1)
+	s.Equal(seedComment.ID, page.Results[0].ID)
+	s.Contains(page.Results[0].Snippet, "<mark>marmalade</mark>")
+
+	resp, err = s.client.R().Get("/comments/search")
+	s.Require().NoError(err)
+	s.Equal(http.StatusBadRequest, resp.StatusCode())
+}
+
+func (s *HandlerE2ETestSuite) TestReactions() {
+	seedComment := comment.Comment{
+		ID:     s.getUUID(),
+		Slug:   "e2e-reaction-slug",
+		Body:   "react to me",
+		Author: "someone-else",
+	}
+	_, err := s.db.CreateComment(tenantContext(), seedComment)
+	s.Require().NoError(err)
+
+	otherToken := s.signToken(jwt.MapClaims{"sub": "other-user-id"})
+	for _, token := range []string{s.jwtToken, s.jwtToken, otherToken} {
+		resp, putErr := s.client.R().
+			SetAuthToken(token).
+			Put("/comments/" + seedComment.ID + "/reactions/like")
+		s.Require().NoError(putErr)
+		s.Equal(http.StatusNoContent, resp.StatusCode(), "Response body: %s", resp.String())
+	}
+
+	resp, err := s.client.R().
+		SetAuthToken(otherToken).
+		Put("/comments/" + seedComment.ID + "/reactions/love")
+	s.Require().NoError(err)
+	s.Equal(http.StatusNoContent, resp.StatusCode())
+
+	var fetched comment.Comment
+	resp, err = s.client.R().SetResult(&fetched).Get("/comments/" + seedComment.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode())
+	s.Equal([]comment.ReactionSummary{
+		{Kind: comment.ReactionLike, Count: 2, ReactedByMe: true},
+		{Kind: comment.ReactionLove, Count: 1, ReactedByMe: false},
+	}, fetched.Reactions)
+
+	resp, err = s.client.R().Delete("/comments/" + seedComment.ID + "/reactions/like")
+	s.Require().NoError(err)
+	s.Equal(http.StatusNoContent, resp.StatusCode())
+
+	resp, err = s.client.R().Delete("/comments/" + seedComment.ID + "/reactions/like")
+	s.Require().NoError(err)
+	s.Equal(http.StatusNoContent, resp.StatusCode(), "removing twice is idempotent")
+
+	var page comment.Page
+	resp, err = s.anonClient.R().SetResult(&page).Get("/comments?slug=" + seedComment.Slug)
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode())
+	s.Require().Len(page.Comments, 1)
+	s.Equal([]comment.ReactionSummary{
+		{Kind: comment.ReactionLike, Count: 1},
+		{Kind: comment.ReactionLove, Count: 1},
+	}, page.Comments[0].Reactions)
+
+	resp, err = s.client.R().Put("/comments/" + seedComment.ID + "/reactions/shrug")
+	s.Require().NoError(err)
+	s.Equal(http.StatusBadRequest, resp.StatusCode())
+
+	resp, err = s.anonClient.R().Put("/comments/" + seedComment.ID + "/reactions/like")
+	s.Require().NoError(err)
+	s.Equal(http.StatusUnauthorized, resp.StatusCode())
+}
+
+func (s *HandlerE2ETestSuite) TestWebhooks() {
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	deliveries := make(chan received, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- received{header: r.Header, body: body}
+	}))
+	defer receiver.Close()
+
+	adminToken := s.signToken(jwt.MapClaims{"sub": "admin-id", "roles": []string{transportHttp.RoleAdmin}})
+	webhookInput := map[string]any{
+		"url":    receiver.URL,
+		"events": []string{string(comment.CommentCreated)},
+		"secret": "e2e-webhook-secret",
+	}
+
+	resp, err := s.client.R().SetBody(webhookInput).Post("/webhooks")
+	s.Require().NoError(err)
+	s.Equal(http.StatusForbidden, resp.StatusCode(), "only admins manage webhooks")
+
+	resp, err = s.client.R().
+		SetAuthToken(adminToken).
+		SetBody(map[string]any{"url": "not a url"}).
+		Post("/webhooks")
+	s.Require().NoError(err)
+	s.Equal(http.StatusBadRequest, resp.StatusCode())
+
+	var created webhook.Webhook
+	resp, err = s.client.R().SetAuthToken(adminToken).SetBody(webhookInput).SetResult(&created).Post("/webhooks")
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusCreated, resp.StatusCode(), "Response body: %s", resp.String())
+	s.Equal("e2e-webhook-secret", created.Secret)
+	s.True(created.Active)
+
+	var listed []webhook.Webhook
+	resp, err = s.client.R().SetAuthToken(adminToken).SetResult(&listed).Get("/webhooks")
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode())
+	s.Require().Len(listed, 1)
+	s.Empty(listed[0].Secret, "secrets are only shown on creation")
+
+	resp, err = s.client.R().
+		SetBody(map[string]string{"slug": "e2e-webhook-slug", "body": "notify partners"}).
+		Post("/comments")
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusCreated, resp.StatusCode())
+
+	_, err = s.relay.RelayBatch(context.Background())
+	s.Require().NoError(err)
+
+	worker := webhook.NewWorker(s.db, s.logger, receiver.Client())
+	n, err := worker.ProcessDue(context.Background())
+	s.Require().NoError(err)
+	s.Equal(1, n)
+
+	select {
+	case got := <-deliveries:
+		s.Equal(string(comment.CommentCreated), got.header.Get(webhook.EventHeader))
+		timestamp, parseErr := strconv.ParseInt(got.header.Get(webhook.TimestampHeader), 10, 64)
+		s.Require().NoError(parseErr)
+		s.True(webhook.Verify("e2e-webhook-secret", timestamp, got.body, got.header.Get(webhook.SignatureHeader)))
+
+		var e comment.Event
+		s.Require().NoError(json.Unmarshal(got.body, &e))
+		s.Equal(comment.CommentCreated, e.Type)
+		s.Equal(testUserID, e.Actor)
+	case <-time.After(5 * time.Second):
+		s.Fail("webhook was not delivered")
+	}
+
+	var log []webhook.Delivery
+	resp, err = s.client.R().SetAuthToken(adminToken).SetResult(&log).Get("/webhooks/" + created.ID + "/deliveries")
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode())
+	s.Require().Len(log, 1)
+	s.Equal(webhook.DeliverySucceeded, log[0].Status)
+	s.Equal(1, log[0].Attempts)
+
+	resp, err = s.client.R().SetAuthToken(adminToken).Delete("/webhooks/" + created.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusNoContent, resp.StatusCode())
+
+	resp, err = s.client.R().SetAuthToken(adminToken).Get("/webhooks/" + created.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusNotFound, resp.StatusCode())
+}
+
+type sseEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+// openStream connects to the comment stream of slug and returns the events it
+// receives. The stream is closed when the test ends.
+func (s *HandlerE2ETestSuite) TestListMigrations() {
+	resp, err := s.client.R().Get("/admin/migrations")
+	s.Require().NoError(err)
+	s.Equal(http.StatusForbidden, resp.StatusCode(), "only admins list migrations")
+
+	adminToken := s.signToken(jwt.MapClaims{"sub": "admin-id", "roles": []string{transportHttp.RoleAdmin}})
+	var list []migrations.Migration
+	resp, err = s.client.R().SetAuthToken(adminToken).SetResult(&list).Get("/admin/migrations")
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusOK, resp.StatusCode())
+
+	want, err := migrations.List(migrations.FS)
+	s.Require().NoError(err)
+	s.Equal(want, list)
+	s.Equal(migrations.Migration{Version: 1, Name: "create_comments_table"}, list[0])
+}
+
+func (s *HandlerE2ETestSuite) TestHealth() {
+	client := resty.New().SetBaseURL("http://" + s.handler.Server.Addr)
+
+	for _, path := range []string{"/healthz", "/livez", "/readyz"} {
+		var report health.Report
+		resp, err := client.R().SetResult(&report).Get(path)
+		s.Require().NoError(err)
+		s.Equal(http.StatusOK, resp.StatusCode(), path)
+		s.Equal(health.StatusPass, report.Status, path)
+	}
+
+	var report health.Report
+	_, err := client.R().SetResult(&report).Get("/readyz")
+	s.Require().NoError(err)
+	s.Require().Len(report.Checks, 2)
+	s.Equal("shutdown", report.Checks[0].Name)
+	s.Equal("database", report.Checks[1].Name)
+}
+
+func (s *HandlerE2ETestSuite) TestMetrics() {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(s.db.Collectors()...)
+	cfg := config.Defaults()
+	cfg.Dev = true
+	h := transportHttp.NewHandler(
+		comment.NewService(s.db, s.logger),
+		s.logger,
+		cfg,
+		transportHttp.WithMetrics(reg),
+	)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	s.Equal(http.StatusNotFound, get("/api/v1/comments/"+s.getUUID()).Code)
+	s.Equal(http.StatusNotFound, get("/no/such/path").Code)
+
+	rec := get("/metrics")
+	s.Require().Equal(http.StatusOK, rec.Code)
+	s.Contains(rec.Header().Get("Content-Type"), "text/plain")
+	body := rec.Body.String()
+	s.Contains(body, `http_requests_total{code="404",method="get",route="/api/v1/comments/{id}"} 1`)
+	s.Contains(body, `http_requests_total{code="404",method="get",route="unmatched"} 1`)
+	s.Contains(body, `http_request_duration_seconds_count{code="404",method="get",route="/api/v1/comments/{id}"} 1`)
+	s.Contains(body, `http_requests_in_flight{route="/metrics"} 1`)
+	s.Contains(body, `db_query_duration_seconds_count{operation="get_comment"}`)
+	s.Contains(body, `go_sql_open_connections{db_name="comments"}`)
+}
+
+func (s *HandlerE2ETestSuite) TestReadyz_FailsOnShutdown() {
+	cfg := config.Defaults()
+	cfg.Dev = true
+	h := transportHttp.NewHandler(nil, s.logger, cfg)
+
+	probe := func(path string) (int, health.Report) {
+		rec := httptest.NewRecorder()
+		h.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var report health.Report
+		s.Require().NoError(json.NewDecoder(rec.Body).Decode(&report))
+		return rec.Code, report
+	}
+
+	code, _ := probe("/readyz")
+	s.Equal(http.StatusOK, code)
+
+	s.Require().NoError(h.Server.Shutdown(context.Background()))
+	s.Eventually(func() bool {
+		code, _ = probe("/readyz")
+		return code == http.StatusServiceUnavailable
+	}, time.Second, 10*time.Millisecond)
+	_, report := probe("/readyz")
+	s.Equal(health.StatusFail, report.Status)
+	s.Equal("server is shutting down", report.Checks[0].Error)
+
+	code, _ = probe("/livez")
+	s.Equal(http.StatusOK, code, "a server that shuts down is still alive")
+}
+
+func (s *HandlerE2ETestSuite) openStream(slug, lastEventID string) <-chan sseEvent {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.T().Cleanup(cancel)
+
+	url := "http://" + s.handler.Server.Addr + "/api/v1/comments/stream?slug=" + slug
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	s.Require().NoError(err)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer resp.Body.Close()
+		defer close(events)
+
+		var e sseEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			field, value, _ := strings.Cut(scanner.Text(), ": ")
+			switch field {
+			case "id":
+				e.id = value
+			case "event":
+				e.event = value
+			case "data":
+				e.data = value
+			case "":
+				if e.event != "" {
+					events <- e
+				}
+				e = sseEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+func (s *HandlerE2ETestSuite) nextStreamEvent(events <-chan sseEvent) sseEvent {
+	select {
+	case e, ok := <-events:
+		s.Require().True(ok, "stream closed")
+		return e
+	case <-time.After(5 * time.Second):
+		s.FailNow("no stream event received")
+		return sseEvent{}
+	}
+}
+
+func (s *HandlerE2ETestSuite) TestStreamComments() {
+	const slug = "e2e-stream-slug"
+
+	resp, err := s.anonClient.R().Get("/comments/stream")
+	s.Require().NoError(err)
+	s.Equal(http.StatusBadRequest, resp.StatusCode())
+
+	events := s.openStream(slug, "")
+
+	var first comment.Comment
+	resp, err = s.client.R().
+		SetBody(map[string]string{"slug": slug, "body": "first streamed comment"}).
+		SetResult(&first).
+		Post("/comments")
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusCreated, resp.StatusCode())
+
+	// Comments on other slugs and comments held for moderation stay off the stream.
+	_, err = s.client.R().SetBody(map[string]string{"slug": "e2e-other-slug", "body": "elsewhere"}).Post("/comments")
+	s.Require().NoError(err)
+	_, err = s.client.R().SetBody(map[string]string{"slug": moderatedSlug, "body": "held back"}).Post("/comments")
+	s.Require().NoError(err)
+
+	created := s.nextStreamEvent(events)
+	s.Equal("created", created.event)
+	s.NotEmpty(created.id)
+	var streamed comment.Comment
+	s.Require().NoError(json.Unmarshal([]byte(created.data), &streamed))
+	s.Equal(first.ID, streamed.ID)
+	s.Equal("first streamed comment", streamed.Body)
+
+	resp, err = s.client.R().SetHeader("If-Match", `"1"`).Delete("/comments/" + first.ID)
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusNoContent, resp.StatusCode(), "Response body: %s", resp.String())
+
+	deleted := s.nextStreamEvent(events)
+	s.Equal("deleted", deleted.event)
+	s.JSONEq(`{"id":"`+first.ID+`"}`, deleted.data)
+
+	// A client that reconnects catches up on what it missed.
+	resumed := s.openStream(slug, created.id)
+	s.Equal(deleted, s.nextStreamEvent(resumed))
+}
+
+func (s *HandlerE2ETestSuite) TestWebSocket() {
+	const slug = "e2e-socket-slug"
+	url := "ws://" + s.handler.Server.Addr + "/api/v1/ws"
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	s.Require().ErrorIs(err, websocket.ErrBadHandshake)
+	s.Equal(http.StatusUnauthorized, resp.StatusCode)
+	resp.Body.Close()
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+s.jwtToken)
+	conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+	s.Require().NoError(err)
+	resp.Body.Close()
+	defer conn.Close()
+
+	read := func() transportHttp.SocketMessage {
+		s.Require().NoError(conn.SetReadDeadline(time.Now().Add(5 * time.Second)))
+		var msg transportHttp.SocketMessage
+		s.Require().NoError(conn.ReadJSON(&msg))
+		return msg
+	}
+
+	s.Require().NoError(conn.WriteJSON(transportHttp.SocketCommand{Type: "subscribe", Ref: "1", Slug: slug}))
+	msg := read()
+	s.Equal(transportHttp.MessageSubscribed, msg.Type)
+	s.Equal("1", msg.Ref)
+
+	s.Require().NoError(conn.WriteJSON(transportHttp.SocketCommand{
+		Type:    "post",
+		Ref:     "2",
+		Comment: &transportHttp.PostCommentRequest{Slug: slug},
+	}))
+	msg = read()
+	s.Equal(transportHttp.MessageError, msg.Type)
+	s.Equal("2", msg.Ref)
+	s.Require().NotNil(msg.Error)
+	s.Equal("/problems/validation-failed", msg.Error.Type)
+
+	s.Require().NoError(conn.WriteJSON(transportHttp.SocketCommand{
+		Type:    "post",
+		Ref:     "3",
+		Comment: &transportHttp.PostCommentRequest{Slug: slug, Body: "posted over the socket"},
+	}))
+	// The answer and the event for the new comment may arrive in either order.
+	received := map[string]transportHttp.SocketMessage{}
+	for range 2 {
+		msg = read()
+		received[msg.Type] = msg
+	}
+
+	posted := received[transportHttp.MessagePosted]
+	s.Equal("3", posted.Ref)
+	s.Require().NotNil(posted.Comment)
+	s.Equal(testUserID, posted.Comment.Author)
+
+	event := received[transportHttp.MessageEvent]
+	s.Equal(slug, event.Slug)
+	s.Equal(comment.ChangeCreated, event.Event)
+	s.Equal(posted.Comment.ID, event.CommentID)
+
+	s.Require().NoError(conn.WriteJSON(transportHttp.SocketCommand{Type: "unsubscribe", Ref: "4", Slug: slug}))
+	msg = read()
+	s.Equal(transportHttp.MessageUnsubscribed, msg.Type)
+
+	s.Require().NoError(conn.WriteJSON(transportHttp.SocketCommand{Type: "shout"}))
+	msg = read()
+	s.Equal(transportHttp.MessageError, msg.Type)
+	s.Equal(http.StatusBadRequest, msg.Error.Status)
+}
+
+func (s *HandlerE2ETestSuite) TestTenants() {
+	const slug = "tenant-slug"
+	baseURL := "http://" + s.handler.Server.Addr + "/api/v1"
+	otherClient := resty.New().SetBaseURL(baseURL).SetHeader("Host", otherHost).SetAuthToken(s.jwtToken)
+
+	var own, other comment.Comment
+	resp, err := s.client.R().
+		SetBody(map[string]string{"slug": slug, "body": "default tenant body"}).
+		SetResult(&own).
+		Post("/comments")
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusCreated, resp.StatusCode(), "Response body: %s", resp.String())
+
+	resp, err = otherClient.R().
+		SetBody(map[string]string{"slug": slug, "body": "other tenant body"}).
+		SetResult(&other).
+		Post("/comments")
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusCreated, resp.StatusCode(), "Response body: %s", resp.String())
+
+	// Each host only sees the comments of its tenant.
+	var page comment.Page
+	resp, err = otherClient.R().SetQueryParam("slug", slug).SetResult(&page).Get("/comments")
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusOK, resp.StatusCode())
+	s.Require().Len(page.Comments, 1)
+	s.Equal(other.ID, page.Comments[0].ID)
+
+	resp, err = otherClient.R().Get("/comments/" + own.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusNotFound, resp.StatusCode())
+
+	resp, err = otherClient.R().SetHeader("If-Match", `"1"`).Delete("/comments/" + own.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusNotFound, resp.StatusCode())
+
+	_, err = s.db.GetComment(tenantContext(), own.ID)
+	s.Require().NoError(err, "the comment of the default tenant is untouched")
+
+	// A token with a tenant claim is scoped to that tenant on unmapped hosts,
+	// and rejected on the hosts of other tenants.
+	tenantToken := s.signToken(jwt.MapClaims{"sub": testUserID, "tenant": otherTenant})
+	page = comment.Page{}
+	resp, err = s.anonClient.R().
+		SetAuthToken(tenantToken).
+		SetQueryParam("slug", slug).
+		SetResult(&page).
+		Get("/comments")
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusOK, resp.StatusCode())
+	s.Require().Len(page.Comments, 1)
+	s.Equal(other.ID, page.Comments[0].ID)
+
+	defaultToken := s.signToken(jwt.MapClaims{"sub": testUserID, "tenant": comment.DefaultTenant})
+	resp, err = otherClient.R().SetAuthToken(defaultToken).Get("/comments/" + other.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusForbidden, resp.StatusCode())
+
+	// Neither do the admin views accept the token of another tenant.
+	defaultAdminToken := s.signToken(jwt.MapClaims{
+		"sub":    "admin-id",
+		"roles":  []string{transportHttp.RoleAdmin},
+		"tenant": comment.DefaultTenant,
+	})
+	resp, err = otherClient.R().
+		SetAuthToken(defaultAdminToken).
+		SetQueryParam("include_deleted", "true").
+		Get("/comments/" + other.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusForbidden, resp.StatusCode())
+
+	resp, err = otherClient.R().
+		SetAuthToken(defaultAdminToken).
+		SetQueryParams(map[string]string{"slug": slug, "include_deleted": "true"}).
+		Get("/comments")
+	s.Require().NoError(err)
+	s.Equal(http.StatusForbidden, resp.StatusCode())
+
+	// Privileged tokens without a tenant are limited to unmapped hosts.
+	adminToken := s.signToken(jwt.MapClaims{"sub": "admin-id", "roles": []string{transportHttp.RoleAdmin}})
+	resp, err = otherClient.R().SetAuthToken(adminToken).Get("/webhooks")
+	s.Require().NoError(err)
+	s.Equal(http.StatusForbidden, resp.StatusCode())
+
+	resp, err = s.client.R().SetAuthToken(adminToken).Get("/webhooks")
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode())
+
+	resp, err = otherClient.R().Get("/comments/" + other.ID)
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode(), "unprivileged tokens act on the tenant of the host")
+}
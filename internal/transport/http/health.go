@@ -0,0 +1,72 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/azdanov/go-rest-api/internal/health"
+)
+
+var errShuttingDown = errors.New("server is shutting down")
+
+// WithReadinessCheck adds a check to /readyz, which tells the orchestrator
+// whether to route requests to the server.
+func WithReadinessCheck(name string, timeout time.Duration, fn health.Func) HandlerOption {
+	return func(h *Handler) {
+		h.Readiness.Register(name, timeout, fn)
+	}
+}
+
+// WithLivenessCheck adds a check to /livez, which tells the orchestrator
+// whether to restart the server.
+func WithLivenessCheck(name string, timeout time.Duration, fn health.Func) HandlerOption {
+	return func(h *Handler) {
+		h.Liveness.Register(name, timeout, fn)
+	}
+}
+
+// mapHealthRoutes registers the probes. They need no token, since the
+// orchestrator has none.
+func (h *Handler) mapHealthRoutes() {
+	h.Router.HandleFunc("/healthz", h.Healthz).Methods(http.MethodGet, http.MethodHead)
+	h.Router.HandleFunc("/livez", h.Livez).Methods(http.MethodGet, http.MethodHead)
+	h.Router.HandleFunc("/readyz", h.Readyz).Methods(http.MethodGet, http.MethodHead)
+}
+
+// checkShutdown fails once the server begins to shut down, so that no new
+// requests are routed to it while it finishes the open ones.
+func (h *Handler) checkShutdown(context.Context) error {
+	select {
+	case <-h.shuttingDown:
+		return errShuttingDown
+	default:
+		return nil
+	}
+}
+
+// Healthz answers as long as the process serves requests at all.
+func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+	h.writeReport(w, r, health.Report{Status: health.StatusPass, Checks: []health.Result{}})
+}
+
+func (h *Handler) Livez(w http.ResponseWriter, r *http.Request) {
+	h.writeReport(w, r, h.Liveness.Run(r.Context()))
+}
+
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	h.writeReport(w, r, h.Readiness.Run(r.Context()))
+}
+
+func (h *Handler) writeReport(w http.ResponseWriter, r *http.Request, report health.Report) {
+	if report.Status != health.StatusPass {
+		h.logger.WarnContext(r.Context(), "health check failed", slog.Any("checks", report.Checks))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to encode response", slog.Any("error", err))
+	}
+}
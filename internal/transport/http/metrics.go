@@ -0,0 +1,85 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// unmatchedRoute labels requests that match no route, so that probing for
+// paths cannot create new series.
+const unmatchedRoute = "unmatched"
+
+type httpMetrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight *prometheus.GaugeVec
+}
+
+func newHTTPMetrics(reg prometheus.Registerer) *httpMetrics {
+	m := &httpMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Number of HTTP requests served.",
+		}, []string{"route", "method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Duration of HTTP requests, until the response is written.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "code"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "Number of HTTP requests being served.",
+		}, []string{"route"}),
+	}
+	reg.MustRegister(m.requests, m.duration, m.inFlight)
+	return m
+}
+
+// WithMetrics records metrics of the requests the API serves in reg and
+// serves everything registered with reg on /metrics. Like the health probes,
+// the endpoint needs no token, so it should not be reachable from outside.
+func WithMetrics(reg *prometheus.Registry) HandlerOption {
+	return func(h *Handler) {
+		h.metrics = newHTTPMetrics(reg)
+		h.gatherer = reg
+	}
+}
+
+func (h *Handler) mapMetricsRoutes() {
+	h.Router.Handle("/metrics", promhttp.HandlerFor(h.gatherer, promhttp.HandlerOpts{})).Methods(http.MethodGet)
+}
+
+// MetricsMiddleware counts and times requests by their route template rather
+// than their path, which would give every comment its own series.
+func (h *Handler) MetricsMiddleware(next http.Handler) http.Handler {
+	if h.metrics == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := prometheus.Labels{"route": routeTemplate(r)}
+		handler := promhttp.InstrumentHandlerInFlight(
+			h.metrics.inFlight.With(route),
+			promhttp.InstrumentHandlerDuration(
+				h.metrics.duration.MustCurryWith(route),
+				promhttp.InstrumentHandlerCounter(h.metrics.requests.MustCurryWith(route), next),
+			),
+		)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func routeTemplate(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return unmatchedRoute
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return unmatchedRoute
+	}
+	return template
+}
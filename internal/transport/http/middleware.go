@@ -3,9 +3,48 @@ package http
 import (
 	"context"
 	"net/http"
-	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 128
+	// maxRequestBodyBytes bounds request bodies well above the longest
+	// comment, so that a client cannot make the server buffer arbitrary data.
+	maxRequestBodyBytes = 1 << 20
 )
 
+type requestIDKey struct{}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestIDMiddleware propagates the caller's X-Request-ID, or assigns a new
+// one, so that logs and error responses can be correlated.
+func (h *Handler) RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = uuid.NewString()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// BodyLimitMiddleware fails reading request bodies beyond maxRequestBodyBytes.
+func (h *Handler) BodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (h *Handler) JSONMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -15,14 +54,27 @@ func (h *Handler) JSONMiddleware(next http.Handler) http.Handler {
 
 func (h *Handler) LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		h.logger.InfoContext(r.Context(), "Received request", "method", r.Method, "path", r.URL.Path)
+		h.logger.InfoContext(
+			r.Context(),
+			"Received request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"request_id", RequestIDFromContext(r.Context()),
+		)
 		next.ServeHTTP(w, r)
 	})
 }
 
+// TimeoutMiddleware bounds the time spent on a request, except on long-lived
+// routes such as event streams.
 func (h *Handler) TimeoutMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), requestTimeout*time.Second)
+		if isLongLived(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), h.requestTimeout)
 		defer cancel()
 
 		r = r.WithContext(ctx)
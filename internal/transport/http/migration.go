@@ -0,0 +1,38 @@
+package http
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/azdanov/go-rest-api/migrations"
+)
+
+type MigrationSource interface {
+	Migrations() ([]migrations.Migration, error)
+}
+
+// WithMigrations enables the admin endpoint that lists the schema migrations
+// the server applies.
+func WithMigrations(source MigrationSource) HandlerOption {
+	return func(h *Handler) {
+		h.Migrations = source
+	}
+}
+
+func (h *Handler) mapMigrationRoutes() {
+	h.Router.HandleFunc("/api/v1/admin/migrations", h.AdminAuth(h.ListMigrations)).Methods(http.MethodGet)
+}
+
+func (h *Handler) ListMigrations(w http.ResponseWriter, r *http.Request) {
+	list, err := h.Migrations.Migrations()
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to list migrations", slog.Any("error", err))
+		h.writeError(w, r, err)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(list); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to encode response", slog.Any("error", err))
+	}
+}
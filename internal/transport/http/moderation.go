@@ -0,0 +1,77 @@
+package http
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type ModerateCommentRequest struct {
+	Status string `json:"status" validate:"required,oneof=approved rejected hidden"`
+	Reason string `json:"reason" validate:"max=1000"`
+}
+
+func (h *Handler) ModerationQueue(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	opts := comment.ListOptions{
+		Slug:   query.Get("slug"),
+		Cursor: query.Get("cursor"),
+		Order:  comment.Order(query.Get("order")),
+	}
+
+	var err error
+	if opts.Limit, err = queryInt(r, "limit"); err != nil {
+		h.writeProblem(w, r, http.StatusBadRequest, "invalid limit")
+		return
+	}
+
+	page, err := h.Service.ModerationQueue(r.Context(), opts)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to list moderation queue", slog.Any("error", err))
+		h.writeError(w, r, err)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(page); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to encode response", slog.Any("error", err))
+	}
+}
+
+func (h *Handler) ModerateComment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	commentID := vars["id"]
+	if _, err := uuid.Parse(commentID); err != nil {
+		h.logger.ErrorContext(r.Context(), "invalid comment ID format", slog.Any("error", err))
+		h.writeProblem(w, r, http.StatusBadRequest, "invalid comment ID format")
+		return
+	}
+
+	var mcr ModerateCommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&mcr); err != nil {
+		h.writeDecodeProblem(w, r, err)
+		return
+	}
+
+	if err := h.validator.Struct(mcr); err != nil {
+		h.logger.ErrorContext(r.Context(), "validation failed", slog.Any("error", err))
+		h.writeValidationProblem(w, r, err)
+		return
+	}
+
+	cmt, err := h.Service.Moderate(r.Context(), commentID, comment.Status(mcr.Status), mcr.Reason)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to moderate comment", slog.Any("error", err))
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(cmt.Version))
+	if err = json.NewEncoder(w).Encode(cmt); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to encode response", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,208 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/azdanov/go-rest-api/internal/webhook"
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	problemContentType = "application/problem+json"
+	// retryAfterSeconds is sent with 503 responses caused by the database
+	// being unreachable.
+	retryAfterSeconds = "5"
+)
+
+// Problem is an RFC 7807 problem details object. It is the only shape of
+// error body the API ever returns.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	// Verdict explains which content filter rejected a comment.
+	Verdict *comment.Verdict `json:"verdict,omitempty"`
+}
+
+// FieldError describes a single failed validation rule of a request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// problemType describes how a domain error is presented to clients. Only
+// errors marked as exposed have their message copied into the detail, since
+// those messages are written with clients in mind.
+type problemType struct {
+	status int
+	slug   string
+	title  string
+	expose bool
+}
+
+func problemTypeFor(err error) (problemType, bool) {
+	switch {
+	case errors.Is(err, comment.ErrCommentNotFound):
+		return problemType{http.StatusNotFound, "comment-not-found", "Comment not found", false}, true
+	case errors.Is(err, comment.ErrRevisionNotFound):
+		return problemType{http.StatusNotFound, "revision-not-found", "Revision not found", false}, true
+	case errors.Is(err, comment.ErrInvalidCursor):
+		return problemType{http.StatusBadRequest, "invalid-cursor", "Invalid cursor", false}, true
+	case errors.Is(err, comment.ErrInvalidListOptions):
+		return problemType{http.StatusBadRequest, "invalid-list-options", "Invalid list options", true}, true
+	case errors.Is(err, comment.ErrInvalidParent):
+		return problemType{http.StatusBadRequest, "invalid-parent", "Invalid parent comment", true}, true
+	case errors.Is(err, comment.ErrSlugChanged):
+		return problemType{http.StatusBadRequest, "slug-changed", "Comment slug cannot be changed", false}, true
+	case errors.Is(err, comment.ErrForbidden):
+		return problemType{http.StatusForbidden, "forbidden", "Not allowed to modify this comment", false}, true
+	case errors.Is(err, comment.ErrVersionConflict):
+		return problemType{
+			http.StatusPreconditionFailed, "version-conflict", "Comment has been modified", false,
+		}, true
+	case errors.Is(err, comment.ErrInvalidReaction):
+		return problemType{http.StatusBadRequest, "invalid-reaction", "Invalid reaction", true}, true
+	case errors.Is(err, comment.ErrRejected):
+		return problemType{
+			http.StatusUnprocessableEntity, "comment-rejected", "Comment rejected by content filter", false,
+		}, true
+	case errors.Is(err, comment.ErrInvalidTransition):
+		return problemType{http.StatusConflict, "invalid-transition", "Invalid moderation transition", true}, true
+	case errors.Is(err, comment.ErrUnavailable):
+		return problemType{
+			http.StatusServiceUnavailable, "service-unavailable", "Service temporarily unavailable", false,
+		}, true
+	case errors.Is(err, comment.ErrInvalidEventID):
+		return problemType{http.StatusBadRequest, "invalid-event-id", "Invalid Last-Event-ID", false}, true
+	case errors.Is(err, comment.ErrTenantRequired):
+		return problemType{http.StatusBadRequest, "unknown-tenant", "Tenant could not be determined", false}, true
+	case errors.Is(err, webhook.ErrWebhookNotFound):
+		return problemType{http.StatusNotFound, "webhook-not-found", "Webhook not found", false}, true
+	case errors.Is(err, webhook.ErrInvalidWebhook):
+		return problemType{http.StatusBadRequest, "invalid-webhook", "Invalid webhook", true}, true
+	default:
+		return problemType{}, false
+	}
+}
+
+// writeDecodeProblem reports a request body that is not valid JSON, or that
+// BodyLimitMiddleware cut off.
+func (h *Handler) writeDecodeProblem(w http.ResponseWriter, r *http.Request, err error) {
+	h.logger.ErrorContext(r.Context(), "failed to decode request body", slog.Any("error", err))
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		h.writeProblem(
+			w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit),
+		)
+		return
+	}
+	h.writeProblem(w, r, http.StatusBadRequest, "request body is not valid JSON")
+}
+
+// writeProblem renders a generic problem for status.
+func (h *Handler) writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	h.renderProblem(w, r, statusProblemFor(status, detail))
+}
+
+func statusProblemFor(status int, detail string) Problem {
+	return Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail}
+}
+
+// writeError maps err to a problem. Errors that are not part of the domain
+// vocabulary become an opaque 500 so that internal details never leak.
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	p := problemFor(err)
+	if p.Status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", retryAfterSeconds)
+	}
+
+	h.renderProblem(w, r, p)
+}
+
+// problemFor builds the problem that describes err to clients.
+func problemFor(err error) Problem {
+	pt, ok := problemTypeFor(err)
+	if !ok {
+		return statusProblemFor(http.StatusInternalServerError, "")
+	}
+
+	p := Problem{
+		Type:   "/problems/" + pt.slug,
+		Title:  pt.title,
+		Status: pt.status,
+	}
+	if pt.expose {
+		p.Detail = err.Error()
+	}
+	var fe *comment.FilterError
+	if errors.As(err, &fe) {
+		p.Verdict = &fe.Verdict
+		p.Detail = fe.Verdict.Reason
+	}
+	return p
+}
+
+// writeValidationProblem lists every failed validation rule of a request body.
+func (h *Handler) writeValidationProblem(w http.ResponseWriter, r *http.Request, err error) {
+	h.renderProblem(w, r, validationProblem(err))
+}
+
+func validationProblem(err error) Problem {
+	p := Problem{
+		Type:   "/problems/validation-failed",
+		Title:  "Request validation failed",
+		Status: http.StatusBadRequest,
+	}
+
+	var verrs validator.ValidationErrors
+	if errors.As(err, &verrs) {
+		for _, fe := range verrs {
+			p.Errors = append(p.Errors, FieldError{
+				Field:   fe.Field(),
+				Message: validationMessage(fe),
+			})
+		}
+	}
+
+	return p
+}
+
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "uuid":
+		return "must be a valid UUID"
+	case "oneof":
+		return "must be one of: " + fe.Param()
+	case "max":
+		return "must be at most " + fe.Param()
+	case "min":
+		return "must be at least " + fe.Param()
+	case "url":
+		return "must be a valid URL"
+	default:
+		return fmt.Sprintf("failed the %q rule", fe.Tag())
+	}
+}
+
+func (h *Handler) renderProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+	p.Instance = r.URL.Path
+	p.RequestID = RequestIDFromContext(r.Context())
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to encode problem", slog.Any("error", err))
+	}
+}
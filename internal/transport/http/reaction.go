@@ -0,0 +1,43 @@
+package http
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func (h *Handler) PutReaction(w http.ResponseWriter, r *http.Request) {
+	h.handleReaction(w, r, h.Service.AddReaction)
+}
+
+func (h *Handler) DeleteReaction(w http.ResponseWriter, r *http.Request) {
+	h.handleReaction(w, r, h.Service.RemoveReaction)
+}
+
+// handleReaction serves both reaction endpoints. They are idempotent, so both
+// answer 204 whether or not the reaction existed before.
+func (h *Handler) handleReaction(
+	w http.ResponseWriter,
+	r *http.Request,
+	apply func(ctx context.Context, id string, kind comment.ReactionKind) error,
+) {
+	vars := mux.Vars(r)
+	commentID := vars["id"]
+	if _, err := uuid.Parse(commentID); err != nil {
+		h.logger.ErrorContext(r.Context(), "invalid comment ID format", slog.Any("error", err))
+		h.writeProblem(w, r, http.StatusBadRequest, "invalid comment ID format")
+		return
+	}
+
+	if err := apply(r.Context(), commentID, comment.ReactionKind(vars["kind"])); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to apply reaction", slog.Any("error", err))
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,59 @@
+package http
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func (h *Handler) ListRevisions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	commentID := vars["id"]
+	if _, err := uuid.Parse(commentID); err != nil {
+		h.logger.ErrorContext(r.Context(), "invalid comment ID format", slog.Any("error", err))
+		h.writeProblem(w, r, http.StatusBadRequest, "invalid comment ID format")
+		return
+	}
+
+	revisions, err := h.Service.ListRevisions(r.Context(), commentID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to list revisions", slog.Any("error", err))
+		h.writeError(w, r, err)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(revisions); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to encode response", slog.Any("error", err))
+	}
+}
+
+func (h *Handler) GetRevision(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	commentID := vars["id"]
+	if _, err := uuid.Parse(commentID); err != nil {
+		h.logger.ErrorContext(r.Context(), "invalid comment ID format", slog.Any("error", err))
+		h.writeProblem(w, r, http.StatusBadRequest, "invalid comment ID format")
+		return
+	}
+
+	number, err := strconv.Atoi(vars["n"])
+	if err != nil || number < 1 {
+		h.writeProblem(w, r, http.StatusBadRequest, "invalid revision number")
+		return
+	}
+
+	revision, err := h.Service.GetRevision(r.Context(), commentID, number)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to get revision", slog.Any("error", err))
+		h.writeError(w, r, err)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(revision); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to encode response", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,41 @@
+package http
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+)
+
+func (h *Handler) SearchComments(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	opts := comment.SearchOptions{
+		Query:  query.Get("q"),
+		Slug:   query.Get("slug"),
+		Author: query.Get("author"),
+		Cursor: query.Get("cursor"),
+	}
+	if opts.Query == "" {
+		h.writeProblem(w, r, http.StatusBadRequest, "q is required")
+		return
+	}
+
+	var err error
+	if opts.Limit, err = queryInt(r, "limit"); err != nil {
+		h.writeProblem(w, r, http.StatusBadRequest, "invalid limit")
+		return
+	}
+
+	page, err := h.Service.Search(r.Context(), opts)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to search comments", slog.Any("error", err))
+		h.writeError(w, r, err)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(page); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to encode response", slog.Any("error", err))
+	}
+}
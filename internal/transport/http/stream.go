@@ -0,0 +1,143 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/gorilla/mux"
+)
+
+const (
+	streamRouteName = "comments.stream"
+	// heartbeatInterval keeps proxies from closing idle streams.
+	heartbeatInterval = 15 * time.Second
+	// streamRetry tells clients how many milliseconds to wait before they
+	// reconnect.
+	streamRetry = 3000
+)
+
+type CommentStreamer interface {
+	Subscribe(ctx context.Context, slug, lastEventID string) (*comment.Stream, error)
+}
+
+// WithStreamer enables the Server-Sent Events stream of comment changes.
+func WithStreamer(streamer CommentStreamer) HandlerOption {
+	return func(h *Handler) {
+		h.Streamer = streamer
+	}
+}
+
+// isLongLived reports whether r is served by a route that keeps the
+// connection open, which must not be cut by request or write timeouts.
+func isLongLived(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	switch route.GetName() {
+	case streamRouteName, socketRouteName:
+		return true
+	default:
+		return false
+	}
+}
+
+// StreamComments sends the public changes to the comments on a slug as
+// Server-Sent Events. Clients that reconnect with Last-Event-ID first receive
+// the changes they missed, except for changes made concurrently with the last
+// one they received, which may commit in the opposite order.
+func (h *Handler) StreamComments(w http.ResponseWriter, r *http.Request) {
+	slug := r.URL.Query().Get("slug")
+	if slug == "" {
+		h.writeProblem(w, r, http.StatusBadRequest, "slug is required")
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	stream, err := h.Streamer.Subscribe(r.Context(), slug, lastID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to open comment stream", slog.Any("error", err))
+		h.writeError(w, r, err)
+		return
+	}
+	defer stream.Close()
+
+	rc := http.NewResponseController(w)
+	if err = rc.SetWriteDeadline(time.Time{}); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to clear write deadline", slog.Any("error", err))
+		h.writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if _, err = fmt.Fprintf(w, "retry: %d\n\n", streamRetry); err != nil {
+		return
+	}
+
+	for _, c := range stream.Replay() {
+		if err = writeChange(w, c); err != nil {
+			return
+		}
+		lastID = c.EventID
+	}
+	if err = rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case c, ok := <-stream.Changes():
+			if !ok {
+				// The stream fell behind; the client reconnects and catches up.
+				return
+			}
+			if c.EventID <= lastID {
+				continue
+			}
+			err = writeChange(w, c)
+			lastID = c.EventID
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		case <-h.shuttingDown:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			h.logger.DebugContext(r.Context(), "comment stream closed", slog.Any("error", err))
+			return
+		}
+	}
+}
+
+// writeChange writes c as an event named after its kind. Created and updated
+// events carry the comment, deleted ones only its ID.
+func writeChange(w http.ResponseWriter, c comment.Change) error {
+	var data any = c.Comment
+	if c.Comment == nil {
+		data = map[string]string{"id": c.CommentID}
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode change: %w", err)
+	}
+
+	if _, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", c.EventID, c.Kind, payload); err != nil {
+		return fmt.Errorf("failed to write change: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package http
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/azdanov/go-rest-api/internal/config"
+)
+
+var errTenantMismatch = errors.New("token belongs to another tenant")
+
+// tenantResolver decides which tenant a request belongs to, following
+// config.Tenants. Host names are compared case insensitively.
+type tenantResolver struct {
+	hosts    map[string]string
+	fallback string
+}
+
+func newTenantResolver(cfg config.Tenants) tenantResolver {
+	tr := tenantResolver{hosts: make(map[string]string, len(cfg.Hosts)), fallback: cfg.Default}
+	for host, tenant := range cfg.Hosts {
+		tr.hosts[strings.ToLower(host)] = tenant
+	}
+	return tr
+}
+
+// forHost returns the tenant of host and whether host is mapped explicitly.
+func (tr tenantResolver) forHost(host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if tenant, ok := tr.hosts[strings.ToLower(host)]; ok {
+		return tenant, true
+	}
+	return tr.fallback, false
+}
+
+// TenantMiddleware scopes the request to the tenant of its host. Requests that
+// carry a token with a tenant claim are scoped to that tenant instead once
+// they are authenticated.
+func (h *Handler) TenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tenant, _ := h.tenants.forHost(r.Host); tenant != "" {
+			r = r.WithContext(comment.WithTenant(r.Context(), tenant))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkTenant reports whether the token claims may be used on r: a token
+// issued for one tenant is not accepted on the site of another. Nor are
+// moderator and admin tokens without a tenant, which would otherwise hold
+// their roles on the sites of every tenant.
+func (h *Handler) checkTenant(r *http.Request, claims *Claims) bool {
+	tenant, mapped := h.tenants.forHost(r.Host)
+	if claims.Tenant == "" {
+		return !mapped || !claims.IsModerator()
+	}
+	return !mapped || tenant == claims.Tenant
+}
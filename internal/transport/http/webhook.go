@@ -0,0 +1,205 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/azdanov/go-rest-api/internal/webhook"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type WebhookService interface {
+	CreateWebhook(context.Context, webhook.Webhook) (webhook.Webhook, error)
+	GetWebhook(context.Context, string) (webhook.Webhook, error)
+	ListWebhooks(context.Context) ([]webhook.Webhook, error)
+	UpdateWebhook(context.Context, webhook.Webhook) (webhook.Webhook, error)
+	DeleteWebhook(context.Context, string) error
+	ListDeliveries(ctx context.Context, webhookID string, limit int) ([]webhook.Delivery, error)
+}
+
+// WebhookRequest creates or replaces a webhook. An empty events list
+// subscribes to every event and a missing active flag means active. The
+// secret is only read on creation and generated when left empty.
+type WebhookRequest struct {
+	URL    string   `json:"url"    validate:"required,url,max=2048"`
+	Events []string `json:"events" validate:"dive,oneof=comment.created comment.updated comment.deleted"`
+	Active *bool    `json:"active"`
+	Secret string   `json:"secret" validate:"omitempty,min=16,max=256"`
+}
+
+func convertToWebhook(wr WebhookRequest) webhook.Webhook {
+	events := make([]comment.EventType, 0, len(wr.Events))
+	for _, e := range wr.Events {
+		events = append(events, comment.EventType(e))
+	}
+	return webhook.Webhook{
+		URL:    wr.URL,
+		Secret: wr.Secret,
+		Events: events,
+		Active: wr.Active == nil || *wr.Active,
+	}
+}
+
+// WithWebhooks enables the webhook admin endpoints.
+func WithWebhooks(service WebhookService) HandlerOption {
+	return func(h *Handler) {
+		h.Webhooks = service
+	}
+}
+
+func (h *Handler) mapWebhookRoutes() {
+	h.Router.HandleFunc("/api/v1/webhooks", h.AdminAuth(h.PostWebhook)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/api/v1/webhooks", h.AdminAuth(h.ListWebhooks)).Methods(http.MethodGet)
+	h.Router.HandleFunc("/api/v1/webhooks/{id}", h.AdminAuth(h.GetWebhook)).Methods(http.MethodGet)
+	h.Router.HandleFunc("/api/v1/webhooks/{id}", h.AdminAuth(h.UpdateWebhook)).Methods(http.MethodPut)
+	h.Router.HandleFunc("/api/v1/webhooks/{id}", h.AdminAuth(h.DeleteWebhook)).Methods(http.MethodDelete)
+	h.Router.HandleFunc("/api/v1/webhooks/{id}/deliveries", h.AdminAuth(h.ListDeliveries)).Methods(http.MethodGet)
+}
+
+func (h *Handler) PostWebhook(w http.ResponseWriter, r *http.Request) {
+	wr, ok := h.decodeWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+
+	wh, err := h.Webhooks.CreateWebhook(r.Context(), convertToWebhook(wr))
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to create webhook", slog.Any("error", err))
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/webhooks/"+wh.ID)
+	w.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(w).Encode(wh); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to encode response", slog.Any("error", err))
+	}
+}
+
+func (h *Handler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := h.Webhooks.ListWebhooks(r.Context())
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to list webhooks", slog.Any("error", err))
+		h.writeError(w, r, err)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(webhooks); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to encode response", slog.Any("error", err))
+	}
+}
+
+func (h *Handler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.webhookID(w, r)
+	if !ok {
+		return
+	}
+
+	wh, err := h.Webhooks.GetWebhook(r.Context(), id)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to get webhook", slog.Any("error", err))
+		h.writeError(w, r, err)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(wh); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to encode response", slog.Any("error", err))
+	}
+}
+
+func (h *Handler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.webhookID(w, r)
+	if !ok {
+		return
+	}
+
+	wr, ok := h.decodeWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+
+	wh := convertToWebhook(wr)
+	wh.ID = id
+	wh.Secret = ""
+
+	updated, err := h.Webhooks.UpdateWebhook(r.Context(), wh)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to update webhook", slog.Any("error", err))
+		h.writeError(w, r, err)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(updated); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to encode response", slog.Any("error", err))
+	}
+}
+
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.webhookID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.Webhooks.DeleteWebhook(r.Context(), id); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to delete webhook", slog.Any("error", err))
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListDeliveries returns the delivery log of a webhook, newest first.
+func (h *Handler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.webhookID(w, r)
+	if !ok {
+		return
+	}
+
+	limit, err := queryInt(r, "limit")
+	if err != nil {
+		h.writeProblem(w, r, http.StatusBadRequest, "invalid limit")
+		return
+	}
+
+	deliveries, err := h.Webhooks.ListDeliveries(r.Context(), id, limit)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to list deliveries", slog.Any("error", err))
+		h.writeError(w, r, err)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(deliveries); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to encode response", slog.Any("error", err))
+	}
+}
+
+func (h *Handler) webhookID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := mux.Vars(r)["id"]
+	if _, err := uuid.Parse(id); err != nil {
+		h.logger.ErrorContext(r.Context(), "invalid webhook ID format", slog.Any("error", err))
+		h.writeProblem(w, r, http.StatusBadRequest, "invalid webhook ID format")
+		return "", false
+	}
+	return id, true
+}
+
+func (h *Handler) decodeWebhookRequest(w http.ResponseWriter, r *http.Request) (WebhookRequest, bool) {
+	var wr WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&wr); err != nil {
+		h.writeDecodeProblem(w, r, err)
+		return WebhookRequest{}, false
+	}
+
+	if err := h.validator.Struct(wr); err != nil {
+		h.logger.ErrorContext(r.Context(), "validation failed", slog.Any("error", err))
+		h.writeValidationProblem(w, r, err)
+		return WebhookRequest{}, false
+	}
+
+	return wr, true
+}
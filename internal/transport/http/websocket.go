@@ -0,0 +1,309 @@
+package http
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	socketRouteName = "ws"
+	// socketBufferSize is the number of messages queued for a connection.
+	// A client that lets it fill up is disconnected.
+	socketBufferSize = 64
+	// maxSubscriptions bounds the slugs a single connection may follow.
+	maxSubscriptions = 32
+	maxMessageSize   = 64 << 10
+	socketWriteWait  = 10 * time.Second
+	socketPongWait   = 60 * time.Second
+	socketPingPeriod = socketPongWait * 9 / 10
+)
+
+// Socket command types sent by clients.
+const (
+	CommandSubscribe   = "subscribe"
+	CommandUnsubscribe = "unsubscribe"
+	CommandPost        = "post"
+)
+
+// Socket message types sent by the server. Events carry the changes on
+// subscribed slugs, the others answer commands.
+const (
+	MessageSubscribed   = "subscribed"
+	MessageUnsubscribed = "unsubscribed"
+	MessagePosted       = "posted"
+	MessageEvent        = "event"
+	MessageError        = "error"
+)
+
+// SocketCommand is a message from a client. Ref is echoed in the answer so
+// that clients can match the two up.
+type SocketCommand struct {
+	Type    string              `json:"type"`
+	Ref     string              `json:"ref,omitempty"`
+	Slug    string              `json:"slug,omitempty"`
+	Comment *PostCommentRequest `json:"comment,omitempty"`
+}
+
+// SocketMessage is a message to a client. Event messages carry the kind of
+// change, and the comment or, for deleted comments, only its ID.
+type SocketMessage struct {
+	Type      string             `json:"type"`
+	Ref       string             `json:"ref,omitempty"`
+	Slug      string             `json:"slug,omitempty"`
+	EventID   string             `json:"event_id,omitempty"`
+	Event     comment.ChangeKind `json:"event,omitempty"`
+	CommentID string             `json:"comment_id,omitempty"`
+	Comment   *comment.Comment   `json:"comment,omitempty"`
+	Error     *Problem           `json:"error,omitempty"`
+}
+
+// socket is one WebSocket connection. Messages to the client are queued in
+// send and written by a single writer goroutine; the reading side runs on the
+// request goroutine.
+type socket struct {
+	h      *Handler
+	conn   *websocket.Conn
+	author string
+	send   chan SocketMessage
+
+	mu      sync.Mutex
+	streams map[string]*comment.Stream
+
+	closeOnce   sync.Once
+	done        chan struct{}
+	closeCode   int
+	closeReason string
+}
+
+// ServeSocket upgrades the request to a WebSocket over which clients follow
+// slugs and post comments. See SocketCommand and SocketMessage.
+func (h *Handler) ServeSocket(w http.ResponseWriter, r *http.Request) {
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		h.writeUnauthorized(w, r, "missing token claims")
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already answered the request.
+		h.logger.ErrorContext(r.Context(), "failed to upgrade connection", slog.Any("error", err))
+		return
+	}
+
+	h.sockets.Add(1)
+	defer h.sockets.Done()
+
+	s := &socket{
+		h:       h,
+		conn:    conn,
+		author:  claims.Subject,
+		send:    make(chan SocketMessage, socketBufferSize),
+		streams: map[string]*comment.Stream{},
+		done:    make(chan struct{}),
+	}
+
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		s.writeLoop(r.Context())
+	}()
+
+	s.readLoop(r.Context())
+	s.close(websocket.CloseNormalClosure, "")
+	<-writerDone
+	s.unsubscribeAll()
+}
+
+func (s *socket) readLoop(ctx context.Context) {
+	s.conn.SetReadLimit(maxMessageSize)
+	_ = s.conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	})
+
+	for {
+		var cmd SocketCommand
+		if err := s.conn.ReadJSON(&cmd); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				s.h.logger.DebugContext(ctx, "websocket closed", slog.Any("error", err))
+			}
+			return
+		}
+
+		switch cmd.Type {
+		case CommandSubscribe:
+			s.subscribe(ctx, cmd)
+		case CommandUnsubscribe:
+			s.unsubscribe(cmd)
+		case CommandPost:
+			s.post(ctx, cmd)
+		default:
+			s.fail(cmd, statusProblemFor(http.StatusBadRequest, "unknown command type"))
+		}
+	}
+}
+
+func (s *socket) writeLoop(ctx context.Context) {
+	ping := time.NewTicker(socketPingPeriod)
+	defer ping.Stop()
+	defer s.conn.Close()
+
+	for {
+		var err error
+		select {
+		case msg := <-s.send:
+			_ = s.conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			err = s.conn.WriteJSON(msg)
+		case <-ping.C:
+			err = s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteWait))
+		case <-s.h.shuttingDown:
+			s.close(websocket.CloseGoingAway, "server shutting down")
+			s.writeClose()
+			return
+		case <-s.done:
+			s.writeClose()
+			return
+		}
+		if err != nil {
+			s.h.logger.DebugContext(ctx, "failed to write to websocket", slog.Any("error", err))
+			return
+		}
+	}
+}
+
+func (s *socket) writeClose() {
+	msg := websocket.FormatCloseMessage(s.closeCode, s.closeReason)
+	_ = s.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(socketWriteWait))
+}
+
+// close ends the connection with the given close code. The first call wins.
+func (s *socket) close(code int, reason string) {
+	s.closeOnce.Do(func() {
+		s.closeCode, s.closeReason = code, reason
+		close(s.done)
+	})
+}
+
+// enqueue queues msg for the client, disconnecting clients that do not keep
+// up.
+func (s *socket) enqueue(msg SocketMessage) {
+	select {
+	case s.send <- msg:
+	default:
+		s.close(websocket.ClosePolicyViolation, "send buffer full")
+	}
+}
+
+func (s *socket) fail(cmd SocketCommand, p Problem) {
+	s.enqueue(SocketMessage{Type: MessageError, Ref: cmd.Ref, Slug: cmd.Slug, Error: &p})
+}
+
+func (s *socket) subscribe(ctx context.Context, cmd SocketCommand) {
+	if cmd.Slug == "" {
+		s.fail(cmd, statusProblemFor(http.StatusBadRequest, "slug is required"))
+		return
+	}
+
+	s.mu.Lock()
+	_, subscribed := s.streams[cmd.Slug]
+	full := len(s.streams) >= maxSubscriptions
+	s.mu.Unlock()
+	if subscribed {
+		s.enqueue(SocketMessage{Type: MessageSubscribed, Ref: cmd.Ref, Slug: cmd.Slug})
+		return
+	}
+	if full {
+		s.fail(cmd, statusProblemFor(http.StatusBadRequest, "too many subscriptions"))
+		return
+	}
+
+	stream, err := s.h.Streamer.Subscribe(ctx, cmd.Slug, "")
+	if err != nil {
+		s.h.logger.ErrorContext(ctx, "failed to subscribe", slog.Any("error", err))
+		s.fail(cmd, problemFor(err))
+		return
+	}
+
+	s.mu.Lock()
+	s.streams[cmd.Slug] = stream
+	s.mu.Unlock()
+	s.enqueue(SocketMessage{Type: MessageSubscribed, Ref: cmd.Ref, Slug: cmd.Slug})
+
+	go s.forward(cmd.Slug, stream)
+}
+
+// forward passes the changes of stream on to the client. If the broker drops
+// the stream because the client fell behind, the client is disconnected.
+func (s *socket) forward(slug string, stream *comment.Stream) {
+	for c := range stream.Changes() {
+		s.enqueue(SocketMessage{
+			Type:      MessageEvent,
+			Slug:      slug,
+			EventID:   c.EventID,
+			Event:     c.Kind,
+			CommentID: c.CommentID,
+			Comment:   c.Comment,
+		})
+	}
+
+	s.mu.Lock()
+	dropped := s.streams[slug] == stream
+	s.mu.Unlock()
+	if dropped {
+		s.close(websocket.ClosePolicyViolation, "too slow to keep up")
+	}
+}
+
+func (s *socket) unsubscribe(cmd SocketCommand) {
+	s.mu.Lock()
+	stream, ok := s.streams[cmd.Slug]
+	delete(s.streams, cmd.Slug)
+	s.mu.Unlock()
+
+	if ok {
+		stream.Close()
+	}
+	s.enqueue(SocketMessage{Type: MessageUnsubscribed, Ref: cmd.Ref, Slug: cmd.Slug})
+}
+
+func (s *socket) unsubscribeAll() {
+	s.mu.Lock()
+	streams := s.streams
+	s.streams = map[string]*comment.Stream{}
+	s.mu.Unlock()
+
+	for _, stream := range streams {
+		stream.Close()
+	}
+}
+
+// post creates a comment like PostComment does.
+func (s *socket) post(ctx context.Context, cmd SocketCommand) {
+	if cmd.Comment == nil {
+		s.fail(cmd, statusProblemFor(http.StatusBadRequest, "comment is required"))
+		return
+	}
+	if err := s.h.validator.Struct(cmd.Comment); err != nil {
+		s.fail(cmd, validationProblem(err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.h.requestTimeout)
+	defer cancel()
+
+	cmt, err := s.h.Service.CreateComment(ctx, convertToComment(*cmd.Comment, s.author))
+	if err != nil {
+		s.h.logger.ErrorContext(ctx, "failed to create comment", slog.Any("error", err))
+		s.fail(cmd, problemFor(err))
+		return
+	}
+
+	s.enqueue(SocketMessage{Type: MessagePosted, Ref: cmd.Ref, Slug: cmt.Slug, CommentID: cmt.ID, Comment: &cmt})
+}
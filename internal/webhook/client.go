@@ -0,0 +1,44 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned when a webhook URL resolves to an address
+// of the network the server runs in rather than of the internet.
+var ErrForbiddenAddress = errors.New("webhook address is not public")
+
+// NewClient returns the HTTP client the Worker should send deliveries with.
+// Webhook URLs are chosen by tenants, so it refuses to connect to loopback,
+// private, link-local and unspecified addresses. The check is made on the
+// address that is dialed, after name resolution, so that a host name cannot
+// be pointed at an internal address once the webhook is registered. The
+// client does not use a proxy, which would hide the address.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Control: checkAddress}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, ForceAttemptHTTP2: true},
+	}
+}
+
+// checkAddress is a net.Dialer Control function. It runs for every address
+// that is dialed, including the addresses of redirect targets.
+func checkAddress(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, address)
+	}
+
+	ip := addrPort.Addr().Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, ip)
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package webhook
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/gofrs/uuid/v5"
+)
+
+var (
+	ErrWebhookNotFound = errors.New("webhook not found")
+	ErrInvalidWebhook  = errors.New("invalid webhook")
+)
+
+const (
+	secretBytes = 32
+	// MaxDeliveries bounds the length of a delivery log page.
+	MaxDeliveries = 100
+)
+
+// Webhook is a partner endpoint that is notified about comment events. An
+// empty Events list subscribes to every event. The secret is only returned
+// when the webhook is created.
+type Webhook struct {
+	ID        string              `json:"id"`
+	URL       string              `json:"url"`
+	Secret    string              `json:"secret,omitempty"`
+	Events    []comment.EventType `json:"events"`
+	Active    bool                `json:"active"`
+	CreatedAt time.Time           `json:"created_at"`
+	UpdatedAt time.Time           `json:"updated_at"`
+}
+
+func (w Webhook) wants(t comment.EventType) bool {
+	return w.Active && (len(w.Events) == 0 || slices.Contains(w.Events, t))
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryRetrying  DeliveryStatus = "retrying"
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	// DeliveryDead marks deliveries that ran out of attempts.
+	DeliveryDead DeliveryStatus = "dead"
+)
+
+// Delivery is one event on its way to one webhook.
+type Delivery struct {
+	ID             string            `json:"id"`
+	WebhookID      string            `json:"webhook_id"`
+	EventID        string            `json:"event_id"`
+	EventType      comment.EventType `json:"event_type"`
+	Payload        json.RawMessage   `json:"payload"`
+	Status         DeliveryStatus    `json:"status"`
+	Attempts       int               `json:"attempts"`
+	NextAttemptAt  time.Time         `json:"next_attempt_at"`
+	LastError      string            `json:"last_error,omitempty"`
+	ResponseStatus int               `json:"response_status,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+	DeliveredAt    *time.Time        `json:"delivered_at,omitempty"`
+}
+
+// Job is a claimed Delivery together with where to send it and how to sign it.
+type Job struct {
+	Delivery Delivery
+	URL      string
+	Secret   string
+}
+
+type Store interface {
+	CreateWebhook(context.Context, Webhook) (Webhook, error)
+	GetWebhook(context.Context, string) (Webhook, error)
+	ListWebhooks(context.Context) ([]Webhook, error)
+	UpdateWebhook(context.Context, Webhook) (Webhook, error)
+	DeleteWebhook(context.Context, string) error
+	CreateDeliveries(context.Context, []Delivery) error
+	// ClaimDeliveries leases up to limit due deliveries for lease, so that
+	// concurrent workers never send the same delivery at the same time.
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]Job, error)
+	UpdateDelivery(context.Context, Delivery) error
+	ListDeliveries(ctx context.Context, webhookID string, limit int) ([]Delivery, error)
+}
+
+type Service struct {
+	Store  Store
+	logger *slog.Logger
+}
+
+func NewService(store Store, logger *slog.Logger) *Service {
+	return &Service{
+		Store:  store,
+		logger: logger,
+	}
+}
+
+// CreateWebhook registers a webhook. A secret is generated unless one is
+// given.
+func (s *Service) CreateWebhook(ctx context.Context, w Webhook) (Webhook, error) {
+	if err := validate(w); err != nil {
+		return Webhook{}, err
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to generate UUID", slog.Any("error", err))
+		return Webhook{}, fmt.Errorf("failed to generate UUID: %w", err)
+	}
+	w.ID = id.String()
+
+	if w.Secret == "" {
+		if w.Secret, err = generateSecret(); err != nil {
+			s.logger.ErrorContext(ctx, "failed to generate secret", slog.Any("error", err))
+			return Webhook{}, err
+		}
+	}
+
+	created, err := s.Store.CreateWebhook(ctx, w)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to create webhook", slog.Any("error", err))
+		return Webhook{}, fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return created, nil
+}
+
+func (s *Service) GetWebhook(ctx context.Context, id string) (Webhook, error) {
+	w, err := s.Store.GetWebhook(ctx, id)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to get webhook", slog.Any("error", err))
+		return Webhook{}, fmt.Errorf("failed to get webhook: %w", err)
+	}
+	w.Secret = ""
+	return w, nil
+}
+
+func (s *Service) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	webhooks, err := s.Store.ListWebhooks(ctx)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to list webhooks", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhook changes the URL, events and active flag of a webhook. The
+// secret stays as it is.
+func (s *Service) UpdateWebhook(ctx context.Context, w Webhook) (Webhook, error) {
+	if err := validate(w); err != nil {
+		return Webhook{}, err
+	}
+
+	updated, err := s.Store.UpdateWebhook(ctx, w)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to update webhook", slog.Any("error", err))
+		return Webhook{}, fmt.Errorf("failed to update webhook: %w", err)
+	}
+	updated.Secret = ""
+	return updated, nil
+}
+
+func (s *Service) DeleteWebhook(ctx context.Context, id string) error {
+	if err := s.Store.DeleteWebhook(ctx, id); err != nil {
+		s.logger.ErrorContext(ctx, "failed to delete webhook", slog.Any("error", err))
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	return nil
+}
+
+// ListDeliveries returns the most recent deliveries of a webhook, newest
+// first.
+func (s *Service) ListDeliveries(ctx context.Context, webhookID string, limit int) ([]Delivery, error) {
+	if limit == 0 {
+		limit = MaxDeliveries
+	}
+	if limit < 1 || limit > MaxDeliveries {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidWebhook, MaxDeliveries)
+	}
+
+	if _, err := s.Store.GetWebhook(ctx, webhookID); err != nil {
+		s.logger.ErrorContext(ctx, "failed to get webhook", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to get webhook: %w", err)
+	}
+
+	deliveries, err := s.Store.ListDeliveries(ctx, webhookID, limit)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to list deliveries", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to list deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// HandleEvent is a comment.Subscriber that queues a delivery of e for every
+// webhook of its tenant interested in it. The Worker sends them.
+func (s *Service) HandleEvent(ctx context.Context, e comment.Event) error {
+	// Events recorded before there were tenants belong to the default one.
+	ctx = comment.WithTenant(ctx, cmp.Or(e.TenantID, comment.DefaultTenant))
+
+	webhooks, err := s.Store.ListWebhooks(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list webhooks: %w", err)
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	var deliveries []Delivery
+	for _, w := range webhooks {
+		if !w.wants(e.Type) {
+			continue
+		}
+
+		id, idErr := uuid.NewV7()
+		if idErr != nil {
+			return fmt.Errorf("failed to generate UUID: %w", idErr)
+		}
+		deliveries = append(deliveries, Delivery{
+			ID:        id.String(),
+			WebhookID: w.ID,
+			EventID:   e.ID,
+			EventType: e.Type,
+			Payload:   payload,
+			Status:    DeliveryPending,
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	if err = s.Store.CreateDeliveries(ctx, deliveries); err != nil {
+		return fmt.Errorf("failed to queue deliveries: %w", err)
+	}
+	return nil
+}
+
+func validate(w Webhook) error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidWebhook)
+	}
+	for _, t := range w.Events {
+		switch t {
+		case comment.CommentCreated, comment.CommentUpdated, comment.CommentDeleted:
+		default:
+			return fmt.Errorf("%w: unknown event %q", ErrInvalidWebhook, t)
+		}
+	}
+	return nil
+}
+
+func generateSecret() (string, error) {
+	b := make([]byte, secretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
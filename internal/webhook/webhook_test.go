@@ -0,0 +1,322 @@
+//go:build unit
+
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/azdanov/go-rest-api/internal/comment"
+	"github.com/azdanov/go-rest-api/internal/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockStore struct {
+	mock.Mock
+}
+
+func (m *MockStore) CreateWebhook(ctx context.Context, w webhook.Webhook) (webhook.Webhook, error) {
+	args := m.Called(ctx, w)
+	return args.Get(0).(webhook.Webhook), args.Error(1)
+}
+
+func (m *MockStore) GetWebhook(ctx context.Context, id string) (webhook.Webhook, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(webhook.Webhook), args.Error(1)
+}
+
+func (m *MockStore) ListWebhooks(ctx context.Context) ([]webhook.Webhook, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]webhook.Webhook), args.Error(1)
+}
+
+func (m *MockStore) UpdateWebhook(ctx context.Context, w webhook.Webhook) (webhook.Webhook, error) {
+	args := m.Called(ctx, w)
+	return args.Get(0).(webhook.Webhook), args.Error(1)
+}
+
+func (m *MockStore) DeleteWebhook(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockStore) CreateDeliveries(ctx context.Context, deliveries []webhook.Delivery) error {
+	args := m.Called(ctx, deliveries)
+	return args.Error(0)
+}
+
+func (m *MockStore) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]webhook.Job, error) {
+	args := m.Called(ctx, limit, lease)
+	return args.Get(0).([]webhook.Job), args.Error(1)
+}
+
+func (m *MockStore) UpdateDelivery(ctx context.Context, d webhook.Delivery) error {
+	args := m.Called(ctx, d)
+	return args.Error(0)
+}
+
+func (m *MockStore) ListDeliveries(ctx context.Context, webhookID string, limit int) ([]webhook.Delivery, error) {
+	args := m.Called(ctx, webhookID, limit)
+	return args.Get(0).([]webhook.Delivery), args.Error(1)
+}
+
+// receiver is a local webhook endpoint that answers with the queued statuses
+// and records what it received.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+
+	status := http.StatusOK
+	if len(rc.statuses) > 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func TestHandleEvent_QueuesMatchingWebhooks(t *testing.T) {
+	mockStore := new(MockStore)
+	service := webhook.NewService(mockStore, slog.Default())
+	ctx := context.Background()
+	// The webhooks and deliveries are those of the tenant of the event.
+	inTenant := mock.MatchedBy(func(ctx context.Context) bool {
+		tenant, ok := comment.TenantFromContext(ctx)
+		return ok && tenant == "blog"
+	})
+
+	mockStore.On("ListWebhooks", inTenant).Return([]webhook.Webhook{
+		{ID: "all", Active: true},
+		{ID: "created", Active: true, Events: []comment.EventType{comment.CommentCreated}},
+		{ID: "deleted", Active: true, Events: []comment.EventType{comment.CommentDeleted}},
+		{ID: "inactive", Active: false},
+	}, nil)
+
+	var queued []webhook.Delivery
+	mockStore.On("CreateDeliveries", inTenant, mock.Anything).Run(func(args mock.Arguments) {
+		queued = args.Get(1).([]webhook.Delivery)
+	}).Return(nil)
+
+	e := comment.Event{ID: "event-1", Type: comment.CommentCreated, CommentID: "comment-1", TenantID: "blog"}
+	require.NoError(t, service.HandleEvent(ctx, e))
+
+	require.Len(t, queued, 2)
+	assert.Equal(t, "all", queued[0].WebhookID)
+	assert.Equal(t, "created", queued[1].WebhookID)
+	for _, d := range queued {
+		assert.Equal(t, "event-1", d.EventID)
+		assert.Equal(t, webhook.DeliveryPending, d.Status)
+
+		var got comment.Event
+		require.NoError(t, json.Unmarshal(d.Payload, &got))
+		assert.Equal(t, e, got)
+	}
+}
+
+func TestCreateWebhook_Validation(t *testing.T) {
+	mockStore := new(MockStore)
+	service := webhook.NewService(mockStore, slog.Default())
+	ctx := context.Background()
+
+	_, err := service.CreateWebhook(ctx, webhook.Webhook{URL: "ftp://example.com"})
+	require.ErrorIs(t, err, webhook.ErrInvalidWebhook)
+
+	_, err = service.CreateWebhook(ctx, webhook.Webhook{
+		URL:    "https://example.com/hook",
+		Events: []comment.EventType{"comment.liked"},
+	})
+	require.ErrorIs(t, err, webhook.ErrInvalidWebhook)
+
+	mockStore.On("CreateWebhook", ctx, mock.Anything).Return(webhook.Webhook{}, nil).Run(func(args mock.Arguments) {
+		w := args.Get(1).(webhook.Webhook)
+		assert.NotEmpty(t, w.ID)
+		assert.Len(t, w.Secret, 64, "a secret should be generated")
+	})
+	_, err = service.CreateWebhook(ctx, webhook.Webhook{URL: "https://example.com/hook", Active: true})
+	require.NoError(t, err)
+	mockStore.AssertExpectations(t)
+}
+
+func TestWorker_DeliversSignedPayload(t *testing.T) {
+	rc := &receiver{}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	mockStore := new(MockStore)
+	worker := webhook.NewWorker(mockStore, slog.Default(), server.Client())
+	ctx := context.Background()
+
+	payload := []byte(`{"id":"event-1","type":"comment.created"}`)
+	job := webhook.Job{
+		Delivery: webhook.Delivery{
+			ID:        "delivery-1",
+			WebhookID: "webhook-1",
+			EventID:   "event-1",
+			EventType: comment.CommentCreated,
+			Payload:   payload,
+			Status:    webhook.DeliveryPending,
+		},
+		URL:    server.URL,
+		Secret: "s3cret",
+	}
+
+	mockStore.On("ClaimDeliveries", ctx, worker.BatchSize, worker.Lease).Return([]webhook.Job{job}, nil).Once()
+	mockStore.On("UpdateDelivery", ctx, mock.MatchedBy(func(d webhook.Delivery) bool {
+		return d.Status == webhook.DeliverySucceeded && d.Attempts == 1 && d.ResponseStatus == http.StatusOK &&
+			d.DeliveredAt != nil
+	})).Return(nil).Once()
+
+	n, err := worker.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	mockStore.AssertExpectations(t)
+
+	require.Len(t, rc.requests, 1)
+	req := rc.requests[0]
+	assert.Equal(t, payload, rc.bodies[0])
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	assert.Equal(t, "comment.created", req.Header.Get(webhook.EventHeader))
+	assert.Equal(t, "delivery-1", req.Header.Get(webhook.DeliveryHeader))
+
+	timestamp, err := strconv.ParseInt(req.Header.Get(webhook.TimestampHeader), 10, 64)
+	require.NoError(t, err)
+	assert.True(t, webhook.Verify("s3cret", timestamp, payload, req.Header.Get(webhook.SignatureHeader)))
+	assert.False(t, webhook.Verify("other", timestamp, payload, req.Header.Get(webhook.SignatureHeader)))
+}
+
+func TestWorker_AttemptsBatchWithinLease(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	rc := &receiver{}
+	fast := httptest.NewServer(rc)
+	defer fast.Close()
+
+	mockStore := new(MockStore)
+	worker := webhook.NewWorker(mockStore, slog.Default(), http.DefaultClient)
+	worker.Lease = 200 * time.Millisecond
+	ctx := context.Background()
+
+	jobs := []webhook.Job{
+		{Delivery: webhook.Delivery{ID: "slow-1", Payload: []byte(`{}`)}, URL: slow.URL},
+		{Delivery: webhook.Delivery{ID: "slow-2", Payload: []byte(`{}`)}, URL: slow.URL},
+		{Delivery: webhook.Delivery{ID: "fast", Payload: []byte(`{}`)}, URL: fast.URL},
+	}
+	mockStore.On("ClaimDeliveries", ctx, worker.BatchSize, worker.Lease).Return(jobs, nil).Once()
+	mockStore.On("UpdateDelivery", ctx, mock.MatchedBy(func(d webhook.Delivery) bool {
+		return d.ID == "slow-1" && d.Status == webhook.DeliveryRetrying
+	})).Return(errors.New("db error")).Once()
+	mockStore.On("UpdateDelivery", ctx, mock.MatchedBy(func(d webhook.Delivery) bool {
+		return d.ID == "slow-2" && d.Status == webhook.DeliveryRetrying
+	})).Return(nil).Once()
+	mockStore.On("UpdateDelivery", ctx, mock.MatchedBy(func(d webhook.Delivery) bool {
+		return d.ID == "fast" && d.Status == webhook.DeliverySucceeded
+	})).Return(nil).Once()
+
+	start := time.Now()
+	n, err := worker.ProcessDue(ctx)
+	assert.Less(t, time.Since(start), worker.Lease)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "slow-1")
+	assert.Equal(t, 3, n)
+	mockStore.AssertExpectations(t)
+}
+
+func TestWorker_RetriesWithBackoffThenDies(t *testing.T) {
+	rc := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusNotFound}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	mockStore := new(MockStore)
+	worker := webhook.NewWorker(mockStore, slog.Default(), server.Client())
+	worker.MaxAttempts = 3
+	worker.BaseBackoff = time.Minute
+	worker.MaxBackoff = 90 * time.Second
+	ctx := context.Background()
+
+	d := webhook.Delivery{ID: "delivery-1", Payload: []byte(`{}`), Status: webhook.DeliveryPending}
+
+	expected := []struct {
+		status  webhook.DeliveryStatus
+		code    int
+		backoff time.Duration
+	}{
+		{webhook.DeliveryRetrying, http.StatusInternalServerError, time.Minute},
+		{webhook.DeliveryRetrying, http.StatusBadGateway, 90 * time.Second},
+		{webhook.DeliveryDead, http.StatusNotFound, 0},
+	}
+
+	for i, want := range expected {
+		mockStore.On("ClaimDeliveries", ctx, worker.BatchSize, worker.Lease).
+			Return([]webhook.Job{{Delivery: d, URL: server.URL, Secret: "s3cret"}}, nil).Once()
+
+		var recorded webhook.Delivery
+		mockStore.On("UpdateDelivery", ctx, mock.Anything).Run(func(args mock.Arguments) {
+			recorded = args.Get(1).(webhook.Delivery)
+		}).Return(nil).Once()
+
+		before := time.Now()
+		_, err := worker.ProcessDue(ctx)
+		require.NoError(t, err)
+
+		assert.Equal(t, want.status, recorded.Status, "attempt %d", i+1)
+		assert.Equal(t, i+1, recorded.Attempts)
+		assert.Equal(t, want.code, recorded.ResponseStatus)
+		assert.Contains(t, recorded.LastError, strconv.Itoa(want.code))
+		assert.Nil(t, recorded.DeliveredAt)
+		if want.backoff > 0 {
+			assert.WithinDuration(t, before.Add(want.backoff), recorded.NextAttemptAt, 5*time.Second)
+		}
+
+		d = recorded
+	}
+	mockStore.AssertExpectations(t)
+}
+
+func TestNewClient_RejectsInternalAddresses(t *testing.T) {
+	local := httptest.NewServer(&receiver{})
+	defer local.Close()
+
+	client := webhook.NewClient(time.Second)
+	for _, target := range []string{
+		local.URL,
+		"http://[::1]:1",
+		"http://0.0.0.0:1",
+		"http://10.0.0.1",
+		"http://192.168.1.1",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[fe80::1]:1",
+	} {
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, target, nil)
+		require.NoError(t, err)
+
+		resp, err := client.Do(req)
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+		require.ErrorIs(t, err, webhook.ErrForbiddenAddress, target)
+	}
+}
@@ -0,0 +1,220 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+
+	signaturePrefix = "sha256="
+
+	defaultBatchSize   = 20
+	defaultMaxAttempts = 8
+	defaultBaseBackoff = 10 * time.Second
+	// maxErrorBody bounds how much of a failed response is kept as last_error.
+	maxErrorBody = 512
+	// leaseDivisor leaves the attempts of a batch half the lease, and the
+	// other half for recording their outcomes.
+	leaseDivisor = 2
+)
+
+// Sign returns the signature header value for a payload sent at timestamp.
+// It is the hex encoded HMAC-SHA256 of "<timestamp>.<payload>" keyed with the
+// webhook secret. Including the timestamp lets receivers reject replays.
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is valid for payload and timestamp.
+func Verify(secret string, timestamp int64, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, payload)), []byte(signature))
+}
+
+// Worker sends queued deliveries. Failed deliveries are retried with
+// exponential backoff until MaxAttempts is reached, after which they are
+// marked dead. Several workers may share a Store.
+type Worker struct {
+	store  Store
+	logger *slog.Logger
+	client *http.Client
+
+	// Interval is how often the queue is polled.
+	Interval time.Duration
+	// BatchSize is the number of deliveries claimed per poll.
+	BatchSize int
+	// MaxAttempts is the number of attempts before a delivery is dead.
+	MaxAttempts int
+	// BaseBackoff is the wait after the first failure. It doubles with every
+	// further failure up to MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// Lease is how long a claimed delivery is hidden from other workers. The
+	// attempts of a batch are cut off at half the lease.
+	Lease time.Duration
+
+	now func() time.Time
+}
+
+func NewWorker(store Store, logger *slog.Logger, client *http.Client) *Worker {
+	return &Worker{
+		store:       store,
+		logger:      logger,
+		client:      client,
+		Interval:    time.Second,
+		BatchSize:   defaultBatchSize,
+		MaxAttempts: defaultMaxAttempts,
+		BaseBackoff: defaultBaseBackoff,
+		MaxBackoff:  time.Hour,
+		Lease:       time.Minute,
+		now:         time.Now,
+	}
+}
+
+// Run polls for due deliveries until ctx is done.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := w.ProcessDue(ctx); err != nil {
+			w.logger.ErrorContext(ctx, "failed to process webhook deliveries", slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessDue claims one batch of due deliveries, attempts them concurrently
+// and records the outcomes. The attempts are cut off at half the lease, so
+// that the outcomes are recorded before another worker may claim the same
+// deliveries. It returns the number of deliveries attempted, together with
+// the failures to record their outcomes.
+func (w *Worker) ProcessDue(ctx context.Context) (int, error) {
+	jobs, err := w.store.ClaimDeliveries(ctx, w.BatchSize, w.Lease)
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim deliveries: %w", err)
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, w.Lease/leaseDivisor)
+	defer cancel()
+
+	deliveries := make([]Delivery, len(jobs))
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			deliveries[i] = w.attempt(attemptCtx, job)
+		}()
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, d := range deliveries {
+		if err = w.store.UpdateDelivery(ctx, d); err != nil {
+			errs = append(errs, fmt.Errorf("failed to update delivery %s: %w", d.ID, err))
+		}
+	}
+	return len(jobs), errors.Join(errs...)
+}
+
+// attempt sends job once and returns its delivery updated with the outcome.
+func (w *Worker) attempt(ctx context.Context, job Job) Delivery {
+	d := job.Delivery
+	d.Attempts++
+
+	status, err := w.send(ctx, job)
+	d.ResponseStatus = status
+
+	now := w.now().UTC()
+	if err == nil {
+		d.Status = DeliverySucceeded
+		d.LastError = ""
+		d.DeliveredAt = &now
+		return d
+	}
+
+	d.LastError = err.Error()
+	if d.Attempts >= w.MaxAttempts {
+		d.Status = DeliveryDead
+		w.logger.WarnContext(
+			ctx,
+			"webhook delivery is dead",
+			slog.String("delivery_id", d.ID),
+			slog.String("webhook_id", d.WebhookID),
+			slog.Int("attempts", d.Attempts),
+			slog.String("error", d.LastError),
+		)
+		return d
+	}
+
+	d.Status = DeliveryRetrying
+	d.NextAttemptAt = now.Add(w.backoff(d.Attempts))
+	return d
+}
+
+// send posts the payload of job and returns the response status. Any status
+// outside 2xx is an error.
+func (w *Worker) send(ctx context.Context, job Job) (int, error) {
+	timestamp := w.now().Unix()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.URL, bytes.NewReader(job.Delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(job.Delivery.EventType))
+	req.Header.Set(DeliveryHeader, job.Delivery.ID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(job.Secret, timestamp, job.Delivery.Payload))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return resp.StatusCode, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	// Drain the body so that the connection can be reused.
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// backoff returns the wait after the given number of failed attempts.
+func (w *Worker) backoff(attempts int) time.Duration {
+	d := w.BaseBackoff
+	for range attempts - 1 {
+		d *= 2
+		if d >= w.MaxBackoff {
+			return w.MaxBackoff
+		}
+	}
+	return d
+}
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS comments_slug_id_idx;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS comments_slug_id_idx ON comments (slug, id);
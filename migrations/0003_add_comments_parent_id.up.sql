@@ -0,0 +1,3 @@
+ALTER TABLE comments ADD COLUMN parent_id UUID REFERENCES comments (id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS comments_parent_id_idx ON comments (parent_id);
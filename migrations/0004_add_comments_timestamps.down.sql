@@ -0,0 +1,4 @@
+ALTER TABLE comments
+DROP COLUMN IF EXISTS deleted_at,
+DROP COLUMN IF EXISTS updated_at,
+DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,4 @@
+ALTER TABLE comments
+ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+ADD COLUMN deleted_at TIMESTAMPTZ;
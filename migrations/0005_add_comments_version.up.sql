@@ -0,0 +1 @@
+ALTER TABLE comments ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
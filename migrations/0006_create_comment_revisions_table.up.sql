@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS comment_revisions (
+	comment_id UUID NOT NULL REFERENCES comments (id) ON DELETE CASCADE,
+	revision INTEGER NOT NULL,
+	slug TEXT,
+	body TEXT,
+	author TEXT,
+	edited_by TEXT,
+	edited_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (comment_id, revision)
+);
@@ -0,0 +1,17 @@
+ALTER TABLE comments
+ADD COLUMN status TEXT NOT NULL DEFAULT 'approved'
+CHECK (status IN ('pending', 'approved', 'rejected', 'hidden'));
+
+CREATE INDEX IF NOT EXISTS comments_status_id_idx ON comments (status, id);
+
+CREATE TABLE IF NOT EXISTS comment_moderations (
+	id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	comment_id UUID NOT NULL REFERENCES comments (id) ON DELETE CASCADE,
+	from_status TEXT NOT NULL,
+	to_status TEXT NOT NULL,
+	reason TEXT,
+	moderator TEXT,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS comment_moderations_comment_id_idx ON comment_moderations (comment_id);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS comment_reactions (
+	comment_id UUID NOT NULL REFERENCES comments (id) ON DELETE CASCADE,
+	user_id TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (comment_id, user_id, kind)
+);
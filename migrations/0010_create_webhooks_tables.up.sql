@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+	id UUID PRIMARY KEY,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	events TEXT[] NOT NULL DEFAULT '{}',
+	active BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id UUID PRIMARY KEY,
+	webhook_id UUID NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+	event_id UUID NOT NULL,
+	event_type TEXT NOT NULL,
+	payload JSONB NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending'
+		CHECK (status IN ('pending', 'retrying', 'succeeded', 'dead')),
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	last_error TEXT,
+	response_status INTEGER,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	delivered_at TIMESTAMPTZ,
+	UNIQUE (webhook_id, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at)
+	WHERE status IN ('pending', 'retrying');
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, created_at DESC);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS outbox (
+	id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	event_id UUID NOT NULL UNIQUE,
+	event_type TEXT NOT NULL,
+	comment_id UUID NOT NULL,
+	payload JSONB NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	delivered_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS outbox_undelivered_idx ON outbox (id) WHERE delivered_at IS NULL;
@@ -0,0 +1,26 @@
+DROP POLICY IF EXISTS comment_reactions_tenant_isolation ON comment_reactions;
+ALTER TABLE comment_reactions NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE comment_reactions DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS comment_moderations_tenant_isolation ON comment_moderations;
+ALTER TABLE comment_moderations NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE comment_moderations DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS comment_revisions_tenant_isolation ON comment_revisions;
+ALTER TABLE comment_revisions NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE comment_revisions DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS comments_tenant_isolation ON comments;
+ALTER TABLE comments NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE comments DISABLE ROW LEVEL SECURITY;
+
+REVOKE ALL ON comments, comment_revisions, comment_moderations, comment_reactions, outbox FROM comments_tenant;
+REVOKE ALL ON SEQUENCE comment_moderations_id_seq, outbox_id_seq FROM comments_tenant;
+
+ALTER TABLE outbox
+DROP COLUMN IF EXISTS tenant_id;
+
+DROP INDEX IF EXISTS comments_tenant_id_slug_id_idx;
+
+ALTER TABLE comments
+DROP COLUMN IF EXISTS tenant_id;
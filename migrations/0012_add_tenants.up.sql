@@ -0,0 +1,56 @@
+-- Existing comments belong to the default tenant. New rows have to name their
+-- tenant explicitly.
+ALTER TABLE comments
+ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default' CHECK (tenant_id <> '');
+
+ALTER TABLE comments
+ALTER COLUMN tenant_id DROP DEFAULT;
+
+CREATE INDEX IF NOT EXISTS comments_tenant_id_slug_id_idx ON comments (tenant_id, slug, id);
+
+ALTER TABLE outbox
+ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+
+ALTER TABLE outbox
+ALTER COLUMN tenant_id DROP DEFAULT;
+
+-- Tenant transactions switch to this role, which is subject to row-level
+-- security even when the application connects as the owner of the tables or
+-- as a superuser.
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = 'comments_tenant') THEN
+		CREATE ROLE comments_tenant NOLOGIN;
+	END IF;
+END
+$$;
+
+GRANT comments_tenant TO CURRENT_USER;
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON comments, comment_revisions, comment_moderations, comment_reactions
+TO comments_tenant;
+GRANT INSERT ON outbox TO comments_tenant;
+GRANT USAGE ON SEQUENCE comment_moderations_id_seq, outbox_id_seq TO comments_tenant;
+
+-- app.tenant_id is set per transaction. Without it no row is visible.
+ALTER TABLE comments ENABLE ROW LEVEL SECURITY;
+ALTER TABLE comments FORCE ROW LEVEL SECURITY;
+CREATE POLICY comments_tenant_isolation ON comments
+	USING (tenant_id = current_setting('app.tenant_id', true))
+	WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
+
+-- The rows of the child tables are visible when their comment is.
+ALTER TABLE comment_revisions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE comment_revisions FORCE ROW LEVEL SECURITY;
+CREATE POLICY comment_revisions_tenant_isolation ON comment_revisions
+	USING (EXISTS (SELECT FROM comments WHERE comments.id = comment_revisions.comment_id));
+
+ALTER TABLE comment_moderations ENABLE ROW LEVEL SECURITY;
+ALTER TABLE comment_moderations FORCE ROW LEVEL SECURITY;
+CREATE POLICY comment_moderations_tenant_isolation ON comment_moderations
+	USING (EXISTS (SELECT FROM comments WHERE comments.id = comment_moderations.comment_id));
+
+ALTER TABLE comment_reactions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE comment_reactions FORCE ROW LEVEL SECURITY;
+CREATE POLICY comment_reactions_tenant_isolation ON comment_reactions
+	USING (EXISTS (SELECT FROM comments WHERE comments.id = comment_reactions.comment_id));
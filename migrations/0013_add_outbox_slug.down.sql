@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS outbox_delivered_at_idx;
+
+DROP INDEX IF EXISTS outbox_tenant_id_slug_event_id_idx;
+
+ALTER TABLE outbox
+DROP COLUMN IF EXISTS slug;
@@ -0,0 +1,15 @@
+-- Stream clients catch up from the events of one slug of their tenant. The
+-- slug of a comment never changes, so every event has exactly one.
+ALTER TABLE outbox
+ADD COLUMN slug TEXT NOT NULL DEFAULT '';
+
+UPDATE outbox
+SET slug = COALESCE(payload->'after'->>'slug', payload->'before'->>'slug', '');
+
+ALTER TABLE outbox
+ALTER COLUMN slug DROP DEFAULT;
+
+CREATE INDEX IF NOT EXISTS outbox_tenant_id_slug_event_id_idx ON outbox (tenant_id, slug, event_id);
+
+-- Delivered events are pruned once they are older than the retention.
+CREATE INDEX IF NOT EXISTS outbox_delivered_at_idx ON outbox (delivered_at) WHERE delivered_at IS NOT NULL;
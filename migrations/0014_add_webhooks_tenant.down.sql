@@ -0,0 +1,15 @@
+DROP POLICY IF EXISTS webhook_deliveries_tenant_isolation ON webhook_deliveries;
+ALTER TABLE webhook_deliveries DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS webhooks_tenant_isolation ON webhooks;
+ALTER TABLE webhooks DISABLE ROW LEVEL SECURITY;
+
+REVOKE ALL ON webhooks, webhook_deliveries FROM comments_tenant;
+
+DROP INDEX IF EXISTS webhooks_tenant_id_id_idx;
+
+ALTER TABLE webhook_deliveries
+DROP COLUMN IF EXISTS tenant_id;
+
+ALTER TABLE webhooks
+DROP COLUMN IF EXISTS tenant_id;
@@ -0,0 +1,29 @@
+-- Existing webhooks belong to the default tenant, like the comments did.
+ALTER TABLE webhooks
+ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default' CHECK (tenant_id <> '');
+
+ALTER TABLE webhooks
+ALTER COLUMN tenant_id DROP DEFAULT;
+
+ALTER TABLE webhook_deliveries
+ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default' CHECK (tenant_id <> '');
+
+ALTER TABLE webhook_deliveries
+ALTER COLUMN tenant_id DROP DEFAULT;
+
+CREATE INDEX IF NOT EXISTS webhooks_tenant_id_id_idx ON webhooks (tenant_id, id);
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON webhooks, webhook_deliveries TO comments_tenant;
+
+-- Unlike the comment tables, the webhook tables do not force row-level
+-- security on their owner: the delivery worker claims the due deliveries of
+-- all tenants. Tenant transactions run as comments_tenant and are isolated.
+ALTER TABLE webhooks ENABLE ROW LEVEL SECURITY;
+CREATE POLICY webhooks_tenant_isolation ON webhooks
+	USING (tenant_id = current_setting('app.tenant_id', true))
+	WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
+
+ALTER TABLE webhook_deliveries ENABLE ROW LEVEL SECURITY;
+CREATE POLICY webhook_deliveries_tenant_isolation ON webhook_deliveries
+	USING (tenant_id = current_setting('app.tenant_id', true))
+	WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS outbox_undelivered_idx;
+CREATE INDEX IF NOT EXISTS outbox_undelivered_idx ON outbox (id) WHERE delivered_at IS NULL;
+
+ALTER TABLE outbox
+DROP COLUMN IF EXISTS dead_at,
+DROP COLUMN IF EXISTS last_error,
+DROP COLUMN IF EXISTS attempts;
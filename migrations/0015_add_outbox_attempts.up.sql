@@ -0,0 +1,9 @@
+-- Events that the relay cannot deliver are set aside as dead, with the error
+-- of their last attempt, instead of holding up the events behind them.
+ALTER TABLE outbox
+ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN last_error TEXT,
+ADD COLUMN dead_at TIMESTAMPTZ;
+
+DROP INDEX IF EXISTS outbox_undelivered_idx;
+CREATE INDEX IF NOT EXISTS outbox_undelivered_idx ON outbox (id) WHERE delivered_at IS NULL AND dead_at IS NULL;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS outbox_created_at_id_idx;
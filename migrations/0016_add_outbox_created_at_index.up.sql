@@ -0,0 +1,3 @@
+-- Listeners catch up on the events recorded since shortly before the newest
+-- one they saw.
+CREATE INDEX IF NOT EXISTS outbox_created_at_id_idx ON outbox (created_at, id);
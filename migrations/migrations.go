@@ -0,0 +1,46 @@
+// Package migrations embeds the SQL migrations of the database schema, so that
+// the binary migrates the database wherever it is run from.
+package migrations
+
+import (
+	"cmp"
+	"embed"
+	"fmt"
+	"io/fs"
+	"slices"
+
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+// FS holds the migrations as NNNN_name.up.sql and NNNN_name.down.sql files.
+//
+//go:embed *.sql
+var FS embed.FS
+
+// Migration is a schema version and the name of the migration that creates it.
+type Migration struct {
+	Version uint   `json:"version"`
+	Name    string `json:"name"`
+}
+
+// List returns the migrations in fsys ordered by version.
+func List(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	var migrations []Migration
+	for _, e := range entries {
+		m, parseErr := source.Parse(e.Name())
+		if parseErr != nil || e.IsDir() || m.Direction != source.Up {
+			continue
+		}
+		migrations = append(migrations, Migration{Version: m.Version, Name: m.Identifier})
+	}
+
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return migrations, nil
+}
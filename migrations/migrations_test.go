@@ -0,0 +1,46 @@
+//go:build unit
+
+package migrations_test
+
+import (
+	"fmt"
+	"testing"
+	"testing/fstest"
+
+	"github.com/azdanov/go-rest-api/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestList(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0010_create_webhooks_tables.up.sql":   {},
+		"0010_create_webhooks_tables.down.sql": {},
+		"0002_add_index.up.sql":                {},
+		"0002_add_index.down.sql":              {},
+		"README.md":                            {},
+	}
+
+	list, err := migrations.List(fsys)
+	require.NoError(t, err)
+	assert.Equal(t, []migrations.Migration{
+		{Version: 2, Name: "add_index"},
+		{Version: 10, Name: "create_webhooks_tables"},
+	}, list)
+}
+
+func TestEmbedded(t *testing.T) {
+	list, err := migrations.List(migrations.FS)
+	require.NoError(t, err)
+	require.NotEmpty(t, list)
+
+	// Every migration can be rolled back.
+	for _, m := range list {
+		_, err = migrations.FS.Open(migrationFile(m, "down"))
+		assert.NoError(t, err, m.Name)
+	}
+}
+
+func migrationFile(m migrations.Migration, direction string) string {
+	return fmt.Sprintf("%04d_%s.%s.sql", m.Version, m.Name, direction)
+}